@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+	"github.com/Oxygenss/yandex_final_project/internal/repository/migrations"
+)
+
+const usage = `Использование:
+  migrate status         текущая и последняя известная версия схемы
+  migrate up [VERSION]   применить миграции до VERSION (по умолчанию до последней)
+  migrate down [VERSION] откатить миграции до VERSION (по умолчанию на одну назад)`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	cfg := config.MustLoad()
+
+	db, set, err := repository.Open(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	current, err := migrations.Version(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch os.Args[1] {
+	case "status":
+		fmt.Printf("current: %d\nlatest: %d\n", current, set.Latest())
+	case "up":
+		target := targetVersion(set.Latest())
+		err = set.Up(db, target)
+	case "down":
+		target := targetVersion(current - 1)
+		err = set.Down(db, target)
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Версия из второго аргумента или def, если он не указан
+func targetVersion(def int) int {
+	if len(os.Args) < 3 {
+		return def
+	}
+
+	version, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		log.Fatalf("invalid version %q: %v", os.Args[2], err)
+	}
+
+	return version
+}
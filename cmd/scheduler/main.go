@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 
@@ -13,12 +14,23 @@ import (
 func main() {
 	cfg := config.MustLoad()
 
-	repository, err := repository.New(cfg.Database.Path)
+	repository, err := repository.New(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	service := service.NewService(repository, *cfg)
+
+	err = service.EnsureAdminPassword(context.Background(), cfg.Auth.Password)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = service.ResolveAuditAdmins(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	service := service.NewService(repository)
 	handler := handler.NewHandler(*service, *cfg)
 
 	router := handler.InitRoutes(*cfg)
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+	"github.com/go-chi/chi"
+)
+
+type APITokenHandler struct {
+	service service.Service
+}
+
+func NewAPITokenHandler(service service.Service) *APITokenHandler {
+	return &APITokenHandler{service: service}
+}
+
+func (h *APITokenHandler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req models.CreateAPITokenRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.service.CreateAPIToken(r.Context(), middleware.UserID(r.Context()), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *APITokenHandler) GetAPITokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.service.GetAPITokens(r.Context(), middleware.UserID(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.GetAPITokensResponse{Tokens: tokens})
+}
+
+func (h *APITokenHandler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := h.service.RevokeAPIToken(r.Context(), middleware.UserID(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+type AuditHandler struct {
+	service service.Service
+}
+
+func NewAuditHandler(service service.Service) *AuditHandler {
+	return &AuditHandler{service: service}
+}
+
+// Страница журнала аудита, от новых записей к старым. Следующая страница - before=id последней записи
+func (h *AuditHandler) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	events, err := h.service.GetAuditEvents(r.Context(), middleware.UserID(r.Context()), filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.GetAuditEventsResponse{Events: events})
+}
+
+// Выгружает все подходящие под фильтр записи в формате JSON Lines: по объекту на строку
+func (h *AuditHandler) ExportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	// Заголовки отправляются с первой записью, чтобы ошибка до нее, например
+	// отказ в доступе, успела стать обычным ответом с ошибкой
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	err = h.service.ExportAuditEvents(r.Context(), middleware.UserID(r.Context()), filter, func(event models.AuditEvent) error {
+		if !started {
+			start()
+		}
+
+		err := encoder.Encode(event)
+		if err == nil && flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	})
+	if err != nil {
+		// После начала выгрузки статус уже не изменить: клиент получит оборванный файл
+		if !started {
+			writeError(w, r, err)
+		}
+		return
+	}
+
+	if !started {
+		start()
+	}
+}
+
+// Фильтр из параметров запроса: user, action, ip, success, since и until (RFC 3339), before, limit
+func auditFilter(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+
+	var filter models.AuditFilter
+	var errs models.ValidationErrors
+
+	parseInt := func(name string) int64 {
+		value := query.Get(name)
+		if value == "" {
+			return 0
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			errs = append(errs, models.NewValidationError(name, "failed to parse "+name, err))
+		}
+		return n
+	}
+
+	parseTime := func(name string) time.Time {
+		value := query.Get(name)
+		if value == "" {
+			return time.Time{}
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errs = append(errs, models.NewValidationError(name, name+" must be in RFC 3339 format", err))
+		}
+		return t
+	}
+
+	filter.UserID = parseInt("user")
+	filter.BeforeID = parseInt("before")
+	filter.Limit = int(parseInt("limit"))
+	filter.Since = parseTime("since")
+	filter.Until = parseTime("until")
+	filter.Action = query.Get("action")
+	filter.IP = query.Get("ip")
+
+	if value := query.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, models.NewValidationError("success", "success must be true or false", err))
+		}
+		filter.Success = &success
+	}
+
+	return filter, errs.Err()
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+// Веб-интерфейс хранит токен обновления в cookie, недоступной скриптам
+// и отправляемой только на /api/refresh
+const (
+	refreshCookie     = "refresh_token"
+	refreshCookiePath = "/api/refresh"
+)
+
+type AuthHandler struct {
+	service service.Service
+	cfg     *config.Config
+}
+
+func NewAuthHandler(service service.Service, cfg config.Config) *AuthHandler {
+	return &AuthHandler{service: service, cfg: &cfg}
+}
+
+// Регистрирует пользователя и сразу выдает ему токен
+func (h *AuthHandler) SignUp(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req models.SignUpRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.service.SignUp(r.Context(), req.Login, req.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.startSession(w, r, user, false, http.StatusCreated)
+}
+
+// Пустой login - вход администратора по паролю из config.Auth
+func (h *AuthHandler) SignIn(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var AuthRequest models.SignInRequest
+	err = json.Unmarshal(body, &AuthRequest)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.service.SignIn(r.Context(), AuthRequest.Login, AuthRequest.Password)
+
+	var secondFactor bool
+	if err == nil {
+		secondFactor, err = h.service.VerifySecondFactor(r.Context(), user.ID, AuthRequest.Code)
+	}
+
+	err = h.service.AuditSignIn(r.Context(), AuthRequest.Login, user.ID, service.SignInPassword, err)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.startSession(w, r, user, secondFactor, http.StatusOK)
+}
+
+// Обменивает токен обновления на новую пару токенов. Без тела запроса токен берется
+// из cookie веб-интерфейса, и новый токен доступа тоже ставится в cookie
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req models.RefreshRequest
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fromCookie := false
+	if cookie, err := r.Cookie(refreshCookie); err == nil && req.RefreshToken == "" {
+		req.RefreshToken = cookie.Value
+		fromCookie = true
+	}
+
+	if req.RefreshToken == "" {
+		writeError(w, r, models.NewValidationError("refresh_token", "refresh_token is required", nil))
+		return
+	}
+
+	user, session, refreshToken, err := h.service.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		// Иначе страница входа не сможет заменить недействительный токен своим
+		if fromCookie {
+			clearSessionCookies(w)
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	if fromCookie {
+		token, err := h.newToken(user, session)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		setSessionCookies(w, r, h.cfg, token, refreshToken)
+	}
+
+	h.writeTokens(w, r, user, session, refreshToken, http.StatusOK)
+}
+
+// Завершает сеанс, к которому относится токен доступа
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	err := h.service.Logout(r.Context(), middleware.UserID(r.Context()), middleware.SessionID(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user models.User, secondFactor bool, status int) {
+	session, refreshToken, err := h.service.StartSession(r.Context(), user, secondFactor)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.writeTokens(w, r, user, session, refreshToken, status)
+}
+
+// Отдает пару токенов в ответе, а токен обновления еще и в cookie для веб-интерфейса
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, r *http.Request, user models.User, session models.Session, refreshToken string, status int) {
+	signedToken, err := h.newToken(user, session)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setRefreshCookie(w, r, h.cfg, refreshToken)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(models.SignInResponse{
+		Token:        signedToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(h.cfg.Auth.TokenTTL / time.Second),
+	})
+}
+
+func (h *AuthHandler) newToken(user models.User, session models.Session) (string, error) {
+	return middleware.NewToken(h.cfg.Auth.Secret, user.ID, session.ID, service.PasswordFingerprint(user), h.cfg.Auth.TokenTTL)
+}
+
+// Ставит cookie с токенами сеанса веб-интерфейса
+func setSessionCookies(w http.ResponseWriter, r *http.Request, cfg *config.Config, token, refreshToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(cfg.Auth.TokenTTL),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	setRefreshCookie(w, r, cfg, refreshToken)
+}
+
+func setRefreshCookie(w http.ResponseWriter, r *http.Request, cfg *config.Config, refreshToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    refreshToken,
+		Path:     refreshCookiePath,
+		Expires:  time.Now().Add(cfg.Auth.RefreshTTL),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "token", Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: refreshCookie, Path: refreshCookiePath, MaxAge: -1, HttpOnly: true})
+}
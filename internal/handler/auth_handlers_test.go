@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func responseCookie(resp testResponse, name string) *http.Cookie {
+	for _, cookie := range (&http.Response{Header: resp.header}).Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// Веб-интерфейс обменивает токен обновления из cookie, не видя его
+func TestRefreshCookie(t *testing.T) {
+	s := newTestServer(t)
+	s.token = ""
+
+	resp := s.request(t, http.MethodPost, "/api/signin", `{"password": "`+testPassword+`"}`, nil)
+	require.Equal(t, http.StatusOK, resp.status)
+	first := responseCookie(resp, refreshCookie)
+	require.NotNil(t, first)
+	assert.True(t, first.HttpOnly)
+	assert.Equal(t, refreshCookiePath, first.Path)
+	assert.Equal(t, resp.json(t)["refresh_token"], first.Value)
+
+	refresh := func(cookie *http.Cookie) testResponse {
+		req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return testResponse{status: rec.Code, header: rec.Header(), body: rec.Body.Bytes()}
+	}
+
+	resp = refresh(first)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+	second := responseCookie(resp, refreshCookie)
+	require.NotNil(t, second)
+	assert.NotEqual(t, first.Value, second.Value)
+	token := responseCookie(resp, "token")
+	require.NotNil(t, token)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.AddCookie(token)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Повторный обмен отзывает сеанс, а cookie удаляются, чтобы страница входа могла поставить новые
+	resp = refresh(first)
+	assert.Equal(t, http.StatusUnauthorized, resp.status)
+	assert.Equal(t, -1, responseCookie(resp, "token").MaxAge)
+	assert.Equal(t, -1, responseCookie(resp, refreshCookie).MaxAge)
+
+	resp = refresh(second)
+	assert.Equal(t, http.StatusUnauthorized, resp.status)
+
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestRefreshRequiresToken(t *testing.T) {
+	s := newTestServer(t)
+
+	resp := s.request(t, http.MethodPost, "/api/refresh", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.status)
+
+	resp = s.request(t, http.MethodPost, "/api/refresh", `{"refresh_token": "unknown"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.status)
+}
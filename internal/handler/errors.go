@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/Oxygenss/yandex_final_project/internal/handler/problem"
+)
+
+func writeJSONError(w http.ResponseWriter, r *http.Request, message string, statusCode int) {
+	problem.Write(w, r, problem.New(statusCode, message))
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	problem.WriteError(w, r, err)
+}
@@ -7,21 +7,100 @@ import (
 	"github.com/Oxygenss/yandex_final_project/internal/service"
 )
 
-type Task interface {
+type Auth interface {
+	SignUp(w http.ResponseWriter, r *http.Request)
 	SignIn(w http.ResponseWriter, r *http.Request)
+	Refresh(w http.ResponseWriter, r *http.Request)
+	Logout(w http.ResponseWriter, r *http.Request)
+}
+
+type OIDC interface {
+	OIDCLogin(w http.ResponseWriter, r *http.Request)
+	OIDCCallback(w http.ResponseWriter, r *http.Request)
+	OIDCSecondFactor(w http.ResponseWriter, r *http.Request)
+	SecondFactorLogin(r *http.Request) string
+}
+
+type TwoFactor interface {
+	GetTwoFactorStatus(w http.ResponseWriter, r *http.Request)
+	SetupTwoFactor(w http.ResponseWriter, r *http.Request)
+	EnableTwoFactor(w http.ResponseWriter, r *http.Request)
+	DisableTwoFactor(w http.ResponseWriter, r *http.Request)
+	RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request)
+}
+
+type APIToken interface {
+	CreateAPIToken(w http.ResponseWriter, r *http.Request)
+	GetAPITokens(w http.ResponseWriter, r *http.Request)
+	RevokeAPIToken(w http.ResponseWriter, r *http.Request)
+}
+
+type Task interface {
 	DoneTask(w http.ResponseWriter, r *http.Request)
 	DeleteTask(w http.ResponseWriter, r *http.Request)
 	EditTask(w http.ResponseWriter, r *http.Request)
+	PatchTask(w http.ResponseWriter, r *http.Request)
 	GetTaskByID(w http.ResponseWriter, r *http.Request)
 	GetTasks(w http.ResponseWriter, r *http.Request)
 	AddTask(w http.ResponseWriter, r *http.Request)
 	NextDateHandler(w http.ResponseWriter, r *http.Request)
+	GetRevisions(w http.ResponseWriter, r *http.Request)
+	RollbackTask(w http.ResponseWriter, r *http.Request)
+	BulkTasks(w http.ResponseWriter, r *http.Request)
+}
+
+type List interface {
+	CreateList(w http.ResponseWriter, r *http.Request)
+	GetLists(w http.ResponseWriter, r *http.Request)
+	GetList(w http.ResponseWriter, r *http.Request)
+	RenameList(w http.ResponseWriter, r *http.Request)
+	DeleteList(w http.ResponseWriter, r *http.Request)
+	InviteMember(w http.ResponseWriter, r *http.Request)
+	UpdateMemberRole(w http.ResponseWriter, r *http.Request)
+	RemoveMember(w http.ResponseWriter, r *http.Request)
+	GetInvitations(w http.ResponseWriter, r *http.Request)
+	AcceptInvitation(w http.ResponseWriter, r *http.Request)
+	DeclineInvitation(w http.ResponseWriter, r *http.Request)
+}
+
+type Journal interface {
+	Undo(w http.ResponseWriter, r *http.Request)
+}
+
+type Idempotency interface {
+	Idempotent(scope string, next http.HandlerFunc) http.HandlerFunc
+}
+
+type Audit interface {
+	GetAuditEvents(w http.ResponseWriter, r *http.Request)
+	ExportAuditEvents(w http.ResponseWriter, r *http.Request)
 }
 
 type Handler struct {
+	Auth
+	OIDC
+	TwoFactor
+	APIToken
 	Task
+	List
+	Journal
+	Idempotency
+	Audit
+
+	service service.Service
 }
 
 func NewHandler(service service.Service, cfg config.Config) *Handler {
-	return &Handler{Task: NewTaskHandler(service, cfg)}
+	return &Handler{
+		Auth:        NewAuthHandler(service, cfg),
+		OIDC:        NewOIDCHandler(service, cfg),
+		TwoFactor:   NewTwoFactorHandler(service),
+		APIToken:    NewAPITokenHandler(service),
+		Task:        NewTaskHandler(service, cfg),
+		List:        NewListHandler(service),
+		Journal:     NewJournalHandler(service),
+		Idempotency: NewIdempotencyHandler(service),
+		Audit:       NewAuditHandler(service),
+		service:     service,
+	}
 }
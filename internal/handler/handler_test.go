@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+const testPassword = "test-password"
+
+// Маршрутизатор с хранилищем в памяти и токеном администратора
+type testServer struct {
+	router *chi.Mux
+	svc    *service.Service
+	token  string
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func testConfig() config.Config {
+	return config.Config{
+		Server:   config.Server{LegacyErrors: true},
+		Database: config.Database{Driver: repository.DriverMemory, QueryTimeout: 5 * time.Second},
+		Auth: config.Auth{
+			Password:                testPassword,
+			Secret:                  "test-secret",
+			TokenTTL:                15 * time.Minute,
+			RefreshTTL:              time.Hour,
+			SignInMaxAttempts:       5,
+			SignInGlobalMaxAttempts: 100,
+			SignInWindow:            15 * time.Minute,
+			SignInLockout:           time.Minute,
+			SignInMaxLockout:        time.Hour,
+		},
+		Undo:        config.Undo{Window: time.Minute},
+		Idempotency: config.Idempotency{Window: time.Hour},
+	}
+}
+
+// edit меняет конфиг перед запуском, например чтобы требовать If-Match
+func newTestServer(t *testing.T, edit ...func(cfg *config.Config)) *testServer {
+	t.Helper()
+
+	cfg := testConfig()
+	for _, fn := range edit {
+		fn(&cfg)
+	}
+
+	repo, err := repository.New(cfg.Database)
+	require.NoError(t, err)
+
+	svc := service.NewService(repo, cfg)
+	require.NoError(t, svc.EnsureAdminPassword(context.Background(), cfg.Auth.Password))
+	require.NoError(t, svc.ResolveAuditAdmins(context.Background()))
+
+	s := &testServer{router: NewHandler(*svc, cfg).InitRoutes(cfg), svc: svc}
+
+	resp := s.request(t, http.MethodPost, "/api/signin", `{"password": "`+testPassword+`"}`, nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+	s.token = resp.json(t)["token"].(string)
+
+	return s
+}
+
+// Запрос от имени администратора. Заголовок Authorization из header заменяет его токен
+func (s *testServer) request(t *testing.T, method, path, body string, header map[string]string) testResponse {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+
+	return testResponse{status: rec.Code, header: rec.Header(), body: rec.Body.Bytes()}
+}
+
+func (r testResponse) json(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	var v map[string]interface{}
+	require.NoError(t, json.Unmarshal(r.body, &v), string(r.body))
+	return v
+}
+
+// Создает задачу и возвращает ее id
+func (s *testServer) addTask(t *testing.T, body string) string {
+	t.Helper()
+
+	resp := s.request(t, http.MethodPost, "/api/task", body, nil)
+	require.Equal(t, http.StatusCreated, resp.status, string(resp.body))
+	return fmt.Sprint(resp.json(t)["id"])
+}
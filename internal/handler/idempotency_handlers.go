@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/problem"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// Заголовки ответа, которые сохраняются вместе с телом и повторяются при replay
+var replayedHeaders = []string{"Content-Type", "X-Operation-ID", "ETag"}
+
+type IdempotencyHandler struct {
+	service service.Service
+}
+
+func NewIdempotencyHandler(service service.Service) *IdempotencyHandler {
+	return &IdempotencyHandler{service: service}
+}
+
+// Оборачивает обработчик поддержкой заголовка Idempotency-Key: первый запрос
+// с ключом выполняется и его ответ сохраняется, повторный с тем же телом получает
+// сохраненный ответ. scope разделяет ключи разных операций, а у каждого
+// пользователя ключи свои. Запросы без ключа выполняются как обычно
+func (h *IdempotencyHandler) Idempotent(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			writeJSONError(w, r, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := middleware.UserID(r.Context())
+		rec, replay, err := h.service.ReserveKey(r.Context(), userID, key, scope, requestHash(taskID(r), body))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if replay {
+			writeReplay(w, rec)
+			return
+		}
+
+		// Контекст запроса мог уже истечь, а ключ нужно сохранить или освободить в любом случае
+		ctx := context.Background()
+
+		// Паника в обработчике тоже освобождает ключ, иначе повтор получал бы 409
+		// до конца аренды ключа. Дальше паника передается net/http как есть
+		defer func() {
+			if p := recover(); p != nil {
+				h.service.ReleaseKey(ctx, userID, key, scope)
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+
+		if recorder.status >= http.StatusInternalServerError || recorder.status == problem.StatusClientClosedRequest {
+			h.service.ReleaseKey(ctx, userID, key, scope)
+			return
+		}
+
+		rec.Status = recorder.status
+		rec.Body = recorder.body.Bytes()
+		rec.Headers = map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				rec.Headers[name] = value
+			}
+		}
+
+		err = h.service.SaveResponse(ctx, userID, rec)
+		if err != nil {
+			// Ответ клиенту уже отправлен, поэтому ключ просто освобождается
+			h.service.ReleaseKey(ctx, userID, key, scope)
+		}
+	}
+}
+
+// Отпечаток запроса: id задачи и тело. Путь не учитывается, чтобы
+// /api/task/done?id=1 и /api/tasks/1/done считались одним запросом
+func requestHash(id string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(id))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func writeReplay(w http.ResponseWriter, rec models.IdempotencyRecord) {
+	for name, value := range rec.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+// Передает ответ клиенту и одновременно запоминает статус и тело
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	s := newTestServer(t)
+	key := map[string]string{"Idempotency-Key": "create-1"}
+
+	first := s.request(t, http.MethodPost, "/api/task", `{"title": "Зарядка"}`, key)
+	require.Equal(t, http.StatusCreated, first.status, string(first.body))
+
+	// Повтор получает сохраненный ответ, вторая задача не создается
+	replay := s.request(t, http.MethodPost, "/api/task", `{"title": "Зарядка"}`, key)
+	assert.Equal(t, http.StatusCreated, replay.status)
+	assert.Equal(t, "true", replay.header.Get("Idempotent-Replayed"))
+	assert.Equal(t, first.header.Get("X-Operation-ID"), replay.header.Get("X-Operation-ID"))
+	assert.Equal(t, string(first.body), string(replay.body))
+
+	tasks := s.request(t, http.MethodGet, "/api/tasks", "", nil).json(t)["tasks"]
+	assert.Len(t, tasks, 1)
+
+	// Тот же ключ с другим телом
+	resp := s.request(t, http.MethodPost, "/api/task", `{"title": "Пробежка"}`, key)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.status)
+
+	// У отметки о выполнении ключи свои
+	resp = s.request(t, http.MethodPost, "/api/task", `{"title": "Зарядка"}`, map[string]string{"Idempotency-Key": "create-2"})
+	require.Equal(t, http.StatusCreated, resp.status)
+	id := resp.json(t)["id"]
+
+	done := map[string]string{"Idempotency-Key": "create-1"}
+	resp = s.request(t, http.MethodPost, "/api/tasks/"+fmt.Sprint(id)+"/done", "", done)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+	resp = s.request(t, http.MethodPost, "/api/task/done?id="+fmt.Sprint(id), "", done)
+	assert.Equal(t, http.StatusOK, resp.status)
+	assert.Equal(t, "true", resp.header.Get("Idempotent-Replayed"))
+}
+
+// Паника в обработчике освобождает ключ: повтор выполняется, а не получает 409
+func TestIdempotencyKeyPanic(t *testing.T) {
+	s := newTestServer(t)
+	h := NewIdempotencyHandler(*s.svc)
+
+	request := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/task", strings.NewReader(`{"title": "Зарядка"}`))
+		req.Header.Set("Idempotency-Key", "create-1")
+		return req
+	}
+
+	panicking := h.Idempotent("add", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+	assert.PanicsWithValue(t, "handler failed", func() {
+		panicking(httptest.NewRecorder(), request())
+	})
+
+	created := h.Idempotent("add", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	rec := httptest.NewRecorder()
+	created(rec, request())
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+}
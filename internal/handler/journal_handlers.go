@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+type JournalHandler struct {
+	service service.Service
+}
+
+func NewJournalHandler(service service.Service) *JournalHandler {
+	return &JournalHandler{service: service}
+}
+
+func (h *JournalHandler) Undo(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Query().Get("id")
+	if idStr == "" {
+		writeJSONError(w, r, "Identifier not specified", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.Undo(r.Context(), middleware.UserID(r.Context()), idStr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct{}{})
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndo(t *testing.T) {
+	s := newTestServer(t)
+
+	resp := s.request(t, http.MethodPost, "/api/task", `{"title": "Зарядка"}`, nil)
+	require.Equal(t, http.StatusCreated, resp.status)
+	opID := resp.header.Get("X-Operation-ID")
+	require.NotEmpty(t, opID)
+	id := resp.json(t)["id"]
+
+	resp = s.request(t, http.MethodPost, "/api/undo?id="+opID, "", nil)
+	assert.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	resp = s.request(t, http.MethodGet, "/api/tasks/"+fmt.Sprint(id), "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.status)
+
+	// Операцию отменяют один раз
+	resp = s.request(t, http.MethodPost, "/api/undo?id="+opID, "", nil)
+	assert.Equal(t, http.StatusConflict, resp.status)
+
+	resp = s.request(t, http.MethodPost, "/api/undo?id=100", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.status)
+
+	resp = s.request(t, http.MethodPost, "/api/undo", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.status)
+}
+
+// Удаленная задача возвращается с прежним id
+func TestUndoDelete(t *testing.T) {
+	s := newTestServer(t)
+	id := s.addTask(t, `{"title": "Зарядка", "comment": "утром"}`)
+
+	resp := s.request(t, http.MethodDelete, "/api/task?id="+id, "", nil)
+	require.Equal(t, http.StatusOK, resp.status)
+
+	resp = s.request(t, http.MethodPost, "/api/undo?id="+resp.header.Get("X-Operation-ID"), "", nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	resp = s.request(t, http.MethodGet, "/api/task?id="+id, "", nil)
+	require.Equal(t, http.StatusOK, resp.status)
+	assert.Equal(t, "утром", resp.json(t)["comment"])
+}
+
+// Отмена изменения проходит только для последней версии задачи и попадает в историю
+func TestUndoEdit(t *testing.T) {
+	s := newTestServer(t)
+	id := s.addTask(t, `{"title": "Зарядка"}`)
+
+	resp := s.request(t, http.MethodPut, "/api/task", `{"id": "`+id+`", "title": "Пробежка"}`, nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+	first := resp.header.Get("X-Operation-ID")
+
+	resp = s.request(t, http.MethodPut, "/api/task", `{"id": "`+id+`", "title": "Плавание"}`, nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+	second := resp.header.Get("X-Operation-ID")
+
+	// Первую правку уже перекрыла вторая: ее отмена затерла бы чужое изменение
+	resp = s.request(t, http.MethodPost, "/api/undo?id="+first, "", nil)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.status, string(resp.body))
+
+	resp = s.request(t, http.MethodGet, "/api/task?id="+id, "", nil)
+	assert.Equal(t, "Плавание", resp.json(t)["title"])
+
+	resp = s.request(t, http.MethodPost, "/api/undo?id="+second, "", nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	resp = s.request(t, http.MethodGet, "/api/task?id="+id, "", nil)
+	assert.Equal(t, "Пробежка", resp.json(t)["title"])
+
+	resp = s.request(t, http.MethodGet, "/api/task/revisions?id="+id, "", nil)
+	revisions := resp.json(t)["revisions"].([]interface{})
+	require.Len(t, revisions, 3)
+	changes := revisions[2].(map[string]interface{})["changes"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"old": "Плавание", "new": "Пробежка"}, changes["title"])
+
+	// Отмена тоже меняет версию, поэтому первая правка так и остается неотменяемой
+	resp = s.request(t, http.MethodPost, "/api/undo?id="+first, "", nil)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.status)
+
+	// Созданную задачу, которую потом изменили, отмена создания не удаляет
+	resp = s.request(t, http.MethodPost, "/api/task", `{"title": "Растяжка"}`, nil)
+	require.Equal(t, http.StatusCreated, resp.status)
+	added := resp.header.Get("X-Operation-ID")
+	id = fmt.Sprint(resp.json(t)["id"])
+
+	resp = s.request(t, http.MethodPut, "/api/task", `{"id": "`+id+`", "title": "Йога"}`, nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	resp = s.request(t, http.MethodPost, "/api/undo?id="+added, "", nil)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.status)
+	assert.Equal(t, http.StatusOK, s.request(t, http.MethodGet, "/api/task?id="+id, "", nil).status)
+}
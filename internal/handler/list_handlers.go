@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+	"github.com/go-chi/chi"
+)
+
+type ListHandler struct {
+	service service.Service
+}
+
+func NewListHandler(service service.Service) *ListHandler {
+	return &ListHandler{service: service}
+}
+
+func (h *ListHandler) CreateList(w http.ResponseWriter, r *http.Request) {
+	var req models.ListRequest
+	if !readJSONBody(w, r, &req) {
+		return
+	}
+
+	list, err := h.service.CreateList(r.Context(), middleware.UserID(r.Context()), req.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, list)
+}
+
+func (h *ListHandler) GetLists(w http.ResponseWriter, r *http.Request) {
+	lists, err := h.service.GetLists(r.Context(), middleware.UserID(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.GetListsResponse{Lists: lists})
+}
+
+func (h *ListHandler) GetList(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.GetList(r.Context(), middleware.UserID(r.Context()), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *ListHandler) RenameList(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req models.ListRequest
+	if !readJSONBody(w, r, &req) {
+		return
+	}
+
+	err := h.service.RenameList(r.Context(), middleware.UserID(r.Context()), id, req.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ListHandler) DeleteList(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	err := h.service.DeleteList(r.Context(), middleware.UserID(r.Context()), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Приглашает пользователя по логину. В списке он появится после того,
+// как примет приглашение через /api/invitations/{id}/accept
+func (h *ListHandler) InviteMember(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req models.InviteRequest
+	if !readJSONBody(w, r, &req) {
+		return
+	}
+
+	member, err := h.service.InviteMember(r.Context(), middleware.UserID(r.Context()), id, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, member)
+}
+
+func (h *ListHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	userID, ok := pathID(w, r, "user")
+	if !ok {
+		return
+	}
+
+	var req models.MemberRoleRequest
+	if !readJSONBody(w, r, &req) {
+		return
+	}
+
+	err := h.service.UpdateMemberRole(r.Context(), middleware.UserID(r.Context()), id, userID, req.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Исключает участника, отменяет приглашение или, если указан сам пользователь, выводит его из списка
+func (h *ListHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	userID, ok := pathID(w, r, "user")
+	if !ok {
+		return
+	}
+
+	err := h.service.RemoveMember(r.Context(), middleware.UserID(r.Context()), id, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ListHandler) GetInvitations(w http.ResponseWriter, r *http.Request) {
+	lists, err := h.service.GetInvitations(r.Context(), middleware.UserID(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.GetListsResponse{Lists: lists})
+}
+
+func (h *ListHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	err := h.service.AcceptInvitation(r.Context(), middleware.UserID(r.Context()), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ListHandler) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	err := h.service.DeclineInvitation(r.Context(), middleware.UserID(r.Context()), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+	if err != nil {
+		writeError(w, r, models.NewValidationError(name, "failed to parse "+name, err))
+		return 0, false
+	}
+	return id, true
+}
+
+func readJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
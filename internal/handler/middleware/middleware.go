@@ -1,29 +1,178 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/problem"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware(config config.Config, next http.Handler) http.Handler {
+type contextKey int
+
+const principalKey contextKey = iota
+
+// Кто выполняет запрос. У сессии, полученной через вход, scopes nil - ей доступно все,
+// у токена API - только перечисленные при его создании права
+type principal struct {
+	userID    int64
+	sessionID int64
+	scopes    []string
+}
+
+const realm = "scheduler"
+
+var errMalformedAuthorization = errors.New("malformed Authorization header")
+
+// Пропускает запросы с действующим токеном. Для токена доступа подпись должна быть верна,
+// срок не истек, сеанс не завершен, а пароль пользователя не менялся после выдачи токена.
+// Токен API (с префиксом sch_) должен существовать и не быть просроченным
+func AuthMiddleware(config config.Config, svc service.Service, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("token")
+		value, err := requestToken(r)
+		if err != nil {
+			writeAuthError(w, r, http.StatusBadRequest, "invalid_request", "Authorization header must be in the form 'Bearer <token>'")
+			return
+		}
+		if value == "" {
+			writeAuthError(w, r, http.StatusUnauthorized, "", "Authentication required")
+			return
+		}
+
+		if service.IsAPIToken(value) {
+			token, err := svc.AuthenticateAPIToken(r.Context(), value)
+			if errors.Is(err, models.ErrUnauthorized) {
+				writeAuthError(w, r, http.StatusUnauthorized, "invalid_token", err.Error())
+				return
+			}
+			if err != nil {
+				problem.WriteError(w, r, err)
+				return
+			}
+
+			next.ServeHTTP(w, withPrincipal(r, principal{userID: token.UserID, scopes: token.Scopes}))
+			return
+		}
+
+		claims, err := parseToken(config.Auth.Secret, value)
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			writeAuthError(w, r, http.StatusUnauthorized, "invalid_token", "Token has expired")
+			return
+		}
+
+		// Токены, выданные до появления учетных записей и сеансов, не содержат uid и sid
+		if err != nil || claims.UserID < 1 || claims.SessionID < 1 {
+			writeAuthError(w, r, http.StatusUnauthorized, "invalid_token", "Invalid token")
+			return
+		}
+
+		err = svc.Authenticate(r.Context(), claims.UserID, claims.SessionID, claims.Fingerprint)
+		if errors.Is(err, models.ErrUnauthorized) {
+			writeAuthError(w, r, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
 		if err != nil {
-			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			problem.WriteError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, withPrincipal(r, principal{userID: claims.UserID, sessionID: claims.SessionID}))
+	})
+}
+
+// Пропускает запросы сессий и токенов API с правом scope. Ставится после AuthMiddleware
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := r.Context().Value(principalKey).(principal)
+		if p.scopes != nil && !contains(p.scopes, scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`", error="insufficient_scope", scope="`+scope+`"`)
+			problem.Write(w, r, problem.New(http.StatusForbidden, "API token does not have the "+scope+" scope"))
 			return
 		}
 
-		token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
-			return []byte(config.Auth.Secret), nil
-		})
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+// Пропускает только сеансы: токеном API нельзя управлять токенами и выйти
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := r.Context().Value(principalKey).(principal)
+		if p.scopes != nil {
+			problem.Write(w, r, problem.New(http.StatusForbidden, "This endpoint is not available to API tokens"))
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Передает сервисам адрес клиента, который попадет в записи журнала аудита
+func ClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(service.WithClientIP(r.Context(), clientIP(r))))
+	})
+}
+
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey, p))
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Токен берется из заголовка Authorization: Bearer, а если заголовка нет - из cookie token,
+// которую ставит веб-интерфейс. Заголовок с другой схемой считается ошибкой,
+// чтобы запрос не прошел молча с токеном из cookie
+func requestToken(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+		token = strings.TrimSpace(token)
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", errMalformedAuthorization
+		}
+		return token, nil
+	}
+
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return "", nil
+	}
+
+	return cookie.Value, nil
+}
+
+// Ответ с заголовком WWW-Authenticate по RFC 6750. code пустой,
+// если клиент не передал токен вовсе
+func writeAuthError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	challenge := `Bearer realm="` + realm + `"`
+	if code != "" {
+		challenge += `, error="` + code + `", error_description="` + strings.ReplaceAll(detail, `"`, `'`) + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	problem.Write(w, r, problem.New(status, detail))
+}
+
+// id пользователя, прошедшего AuthMiddleware. Вне защищенных маршрутов - 0
+func UserID(ctx context.Context) int64 {
+	p, _ := ctx.Value(principalKey).(principal)
+	return p.userID
+}
+
+// id сеанса, к которому относится токен доступа. Для токенов API - 0
+func SessionID(ctx context.Context) int64 {
+	p, _ := ctx.Value(principalKey).(principal)
+	return p.sessionID
+}
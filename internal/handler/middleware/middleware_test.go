@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+// Сервисы с хранилищем в памяти и пользователь с открытым сеансом
+type testEnv struct {
+	cfg   config.Config
+	svc   *service.Service
+	user  models.User
+	token string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	cfg := config.Config{
+		Database: config.Database{Driver: repository.DriverMemory},
+		Auth:     config.Auth{Secret: "test-secret", TokenTTL: 15 * time.Minute, RefreshTTL: time.Hour},
+		Undo:     config.Undo{Window: time.Minute},
+	}
+
+	repo, err := repository.New(cfg.Database)
+	require.NoError(t, err)
+
+	env := &testEnv{cfg: cfg, svc: service.NewService(repo, cfg)}
+	env.user, err = env.svc.SignUp(context.Background(), "user", "secret-password")
+	require.NoError(t, err)
+	env.token = env.newToken(t, env.user, cfg.Auth.TokenTTL)
+
+	return env
+}
+
+// Токен доступа в новом сеансе пользователя
+func (env *testEnv) newToken(t *testing.T, user models.User, ttl time.Duration) string {
+	t.Helper()
+
+	session, _, err := env.svc.StartSession(context.Background(), user, true)
+	require.NoError(t, err)
+
+	token, err := NewToken(env.cfg.Auth.Secret, user.ID, session.ID, service.PasswordFingerprint(user), ttl)
+	require.NoError(t, err)
+	return token
+}
+
+// Обработчик за AuthMiddleware отвечает id пользователя из контекста
+func (env *testEnv) serve(r *http.Request, wrap ...func(http.Handler) http.Handler) *httptest.ResponseRecorder {
+	var next http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, UserID(r.Context()))
+	})
+	for _, fn := range wrap {
+		next = fn(next)
+	}
+
+	rec := httptest.NewRecorder()
+	AuthMiddleware(env.cfg, *env.svc, next).ServeHTTP(rec, r)
+	return rec
+}
+
+func TestAuthMiddlewareTokenSource(t *testing.T) {
+	env := newTestEnv(t)
+	userID := fmt.Sprint(env.user.ID)
+
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		status int
+	}{
+		{"bearer", "Bearer " + env.token, "", http.StatusOK},
+		{"scheme is case-insensitive", "bearer " + env.token, "", http.StatusOK},
+		{"cookie", "", env.token, http.StatusOK},
+		{"header wins over invalid cookie", "Bearer " + env.token, "invalid", http.StatusOK},
+		{"invalid header is not replaced by cookie", "Bearer invalid", env.token, http.StatusUnauthorized},
+		{"other scheme", "Basic dXNlcjpzZWNyZXQ=", env.token, http.StatusBadRequest},
+		{"empty bearer", "Bearer ", env.token, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "token", Value: tt.cookie})
+			}
+
+			rec := env.serve(req)
+			require.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.status == http.StatusOK {
+				assert.Equal(t, userID, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareChallenge(t *testing.T) {
+	env := newTestEnv(t)
+	expired := env.newToken(t, env.user, -time.Minute)
+
+	tests := []struct {
+		name      string
+		header    string
+		status    int
+		challenge string
+	}{
+		{"no token", "", http.StatusUnauthorized, `Bearer realm="scheduler"`},
+		{"malformed header", "Token abc", http.StatusBadRequest,
+			`Bearer realm="scheduler", error="invalid_request", error_description="Authorization header must be in the form 'Bearer <token>'"`},
+		{"invalid token", "Bearer invalid", http.StatusUnauthorized,
+			`Bearer realm="scheduler", error="invalid_token", error_description="Invalid token"`},
+		{"expired token", "Bearer " + expired, http.StatusUnauthorized,
+			`Bearer realm="scheduler", error="invalid_token", error_description="Token has expired"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			rec := env.serve(req)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.challenge, rec.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestAuthMiddlewareLogout(t *testing.T) {
+	env := newTestEnv(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+env.token)
+	rec := env.serve(req, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, env.svc.Logout(r.Context(), UserID(r.Context()), SessionID(r.Context())))
+			next.ServeHTTP(w, r)
+		})
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Токен завершенного сеанса больше не принимается
+	rec = env.serve(req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
+
+// Токен API с правами scopes
+func (env *testEnv) newAPIToken(t *testing.T, scopes ...string) models.CreateAPITokenResponse {
+	t.Helper()
+
+	token, err := env.svc.CreateAPIToken(context.Background(), env.user.ID, models.CreateAPITokenRequest{Name: "ci", Scopes: scopes})
+	require.NoError(t, err)
+	return token
+}
+
+func TestRequireScope(t *testing.T) {
+	env := newTestEnv(t)
+	readOnly := env.newAPIToken(t, models.ScopeTasksRead)
+
+	requireScope := func(scope string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler { return RequireScope(scope, next) }
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		wrap   func(http.Handler) http.Handler
+		status int
+	}{
+		{"token with scope", readOnly.Token, requireScope(models.ScopeTasksRead), http.StatusOK},
+		{"token without scope", readOnly.Token, requireScope(models.ScopeTasksWrite), http.StatusForbidden},
+		{"session has every scope", env.token, requireScope(models.ScopeTasksWrite), http.StatusOK},
+		{"token on session route", readOnly.Token, RequireSession, http.StatusForbidden},
+		{"session on session route", env.token, RequireSession, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			rec := env.serve(req, tt.wrap)
+			require.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.status == http.StatusOK {
+				assert.Equal(t, fmt.Sprint(env.user.ID), rec.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/task", nil)
+	req.Header.Set("Authorization", "Bearer "+readOnly.Token)
+	rec := env.serve(req, requireScope(models.ScopeTasksWrite))
+	assert.Equal(t, `Bearer realm="scheduler", error="insufficient_scope", scope="tasks:write"`, rec.Header().Get("WWW-Authenticate"))
+}
+
+func TestAuthMiddlewareAPIToken(t *testing.T) {
+	env := newTestEnv(t)
+	revoked := env.newAPIToken(t, models.ScopeTasksRead)
+	require.NoError(t, env.svc.RevokeAPIToken(context.Background(), env.user.ID, revoked.ID))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"revoked", revoked.Token},
+		{"unknown", "sch_unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			rec := env.serve(req)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/problem"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+// Больше адресов не отслеживается: при переполнении забываются те, что уже не заблокированы
+const maxTrackedAddresses = 10000
+
+// Через сколько повторить запрос, отклоненный из-за того, что лимит заняли
+// попытки, результат которых еще неизвестен
+const pendingRetryAfter = time.Second
+
+// Ограничивает неудачные попытки входа с одного IP и со всех IP вместе.
+// После maxAttempts неудач за window вход блокируется, и каждая следующая
+// блокировка подряд длится вдвое дольше предыдущей. Состояние хранится в памяти процесса
+type SignInLimiter struct {
+	maxAttempts       int
+	globalMaxAttempts int
+	window            time.Duration
+	lockout           time.Duration
+	maxLockout        time.Duration
+
+	mu     sync.Mutex
+	byIP   map[string]*attempts
+	global attempts
+	now    func() time.Time
+}
+
+// Неудачные попытки одного источника
+type attempts struct {
+	failures int
+	// Неудачи текущего окна по логинам: успешный вход снимает только неудачи своего логина
+	byLogin     map[string]int
+	windowStart time.Time
+	lastFailure time.Time
+	// Сколько блокировок было подряд, от этого зависит длительность следующей
+	lockouts    int
+	lockedUntil time.Time
+	// Попытки, которые проверяются прямо сейчас. Пока результат неизвестен, они занимают
+	// место в лимите, иначе параллельные запросы успели бы проверить больше паролей
+	pending int
+}
+
+func NewSignInLimiter(cfg config.Auth) *SignInLimiter {
+	return &SignInLimiter{
+		maxAttempts:       cfg.SignInMaxAttempts,
+		globalMaxAttempts: cfg.SignInGlobalMaxAttempts,
+		window:            cfg.SignInWindow,
+		lockout:           cfg.SignInLockout,
+		maxLockout:        cfg.SignInMaxLockout,
+		byIP:              map[string]*attempts{},
+		now:               time.Now,
+	}
+}
+
+// Пропускает запрос на вход, если ни IP клиента, ни вход в целом не заблокированы,
+// и учитывает результат: 401 - неудачная попытка, 2xx - успешный вход, после которого
+// забываются неудачи с этого IP для того же логина. Заблокированный запрос получает 429 с Retry-After
+func (l *SignInLimiter) Limit(next http.Handler) http.Handler {
+	return l.LimitBy(signInLogin)(next)
+}
+
+// То же, что Limit, но логин попытки определяет login. Пустой логин ни с чьим не совпадает,
+// и его успех не снимает чужих неудач
+func (l *SignInLimiter) LimitBy(login func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return l.limit(login, next)
+	}
+}
+
+func (l *SignInLimiter) limit(loginOf func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		login := loginOf(r)
+
+		wait := l.begin(ip)
+		if wait > 0 {
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			problem.Write(w, r, problem.New(http.StatusTooManyRequests,
+				"Too many failed sign-in attempts, try again in "+strconv.Itoa(seconds)+" seconds"))
+			return
+		}
+
+		// Попытка освобождается, даже если обработчик запаниковал
+		status := 0
+		defer func() {
+			l.finish(ip, login, status)
+		}()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		status = recorder.status
+	})
+}
+
+// Резервирует попытку входа и возвращает 0 или сколько ждать, если вход заблокирован
+// или лимит заняли попытки, которые еще проверяются
+func (l *SignInLimiter) begin(ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	a, ok := l.byIP[ip]
+	if !ok {
+		if len(l.byIP) >= maxTrackedAddresses {
+			l.prune(now)
+		}
+		a = &attempts{}
+		l.byIP[ip] = a
+	}
+
+	wait := l.global.lockedUntil.Sub(now)
+	if ipWait := a.lockedUntil.Sub(now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	if l.full(a, l.maxAttempts, now) || l.full(&l.global, l.globalMaxAttempts, now) {
+		return pendingRetryAfter
+	}
+
+	a.pending++
+	l.global.pending++
+	return 0
+}
+
+// Учитывает результат попытки, зарезервированной в begin. status 0 - результат неизвестен
+func (l *SignInLimiter) finish(ip, login string, status int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	a := l.byIP[ip]
+	a.pending--
+	l.global.pending--
+
+	switch {
+	case status == http.StatusUnauthorized:
+		l.record(a, login, l.maxAttempts, now)
+		l.record(&l.global, login, l.globalMaxAttempts, now)
+	case status >= http.StatusOK && status < http.StatusMultipleChoices:
+		// Серия блокировок IP не прерывается: иначе вход в свою учетную запись
+		// позволял бы продолжать подбор пароля к чужой
+		a.failures -= a.byLogin[login]
+		delete(a.byLogin, login)
+	}
+
+	if a.failures <= 0 && a.lockouts == 0 && a.pending == 0 {
+		delete(l.byIP, ip)
+	}
+}
+
+// Неудачи текущего окна вместе с проверяемыми попытками достигли limit. 0 - без ограничения
+func (l *SignInLimiter) full(a *attempts, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return false
+	}
+
+	failures := a.failures
+	if now.Sub(a.windowStart) > l.window {
+		failures = 0
+	}
+
+	return failures+a.pending >= limit
+}
+
+// Учитывает неудачу входа под login и при превышении limit блокирует источник. 0 - без ограничения
+func (l *SignInLimiter) record(a *attempts, login string, limit int, now time.Time) {
+	if limit <= 0 {
+		return
+	}
+
+	if now.Sub(a.windowStart) > l.window {
+		a.failures = 0
+		a.byLogin = nil
+		a.windowStart = now
+	}
+
+	// Серия блокировок прерывается, если попыток не было дольше окна
+	if now.Sub(a.lastFailure) > l.window+a.currentLockout(l) {
+		a.lockouts = 0
+	}
+
+	a.failures++
+	a.lastFailure = now
+	if a.byLogin == nil {
+		a.byLogin = map[string]int{}
+	}
+	a.byLogin[login]++
+
+	if a.failures < limit {
+		return
+	}
+
+	a.lockouts++
+	a.failures = 0
+	a.byLogin = nil
+	a.windowStart = now
+	a.lockedUntil = now.Add(a.currentLockout(l))
+}
+
+// Длительность текущей блокировки: lockout * 2^(lockouts-1), но не больше maxLockout
+func (a *attempts) currentLockout(l *SignInLimiter) time.Duration {
+	if a.lockouts == 0 {
+		return 0
+	}
+
+	d := l.lockout
+	for i := 1; i < a.lockouts && d < l.maxLockout; i++ {
+		d *= 2
+	}
+
+	if d > l.maxLockout {
+		return l.maxLockout
+	}
+	return d
+}
+
+// Забывает адреса, которые не заблокированы, давно не ошибались и сейчас не входят
+func (l *SignInLimiter) prune(now time.Time) {
+	for ip, a := range l.byIP {
+		if a.pending == 0 && now.After(a.lockedUntil) && now.Sub(a.lastFailure) > l.window {
+			delete(l.byIP, ip)
+		}
+	}
+}
+
+// Логин из тела запроса на вход, пустой - администратор. Тело читается целиком,
+// и обработчику передается его копия
+func signInLogin(r *http.Request) string {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req models.SignInRequest
+	json.Unmarshal(body, &req)
+	if req.Login == "" {
+		return service.AdminLogin
+	}
+	return req.Login
+}
+
+// IP клиента из адреса соединения. X-Forwarded-For не учитывается: его может подделать сам клиент
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
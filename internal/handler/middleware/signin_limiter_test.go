@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+const testSignInPassword = "secret-password"
+
+// Ограничитель с часами, которые двигает тест
+type testLimiter struct {
+	*SignInLimiter
+	clock   time.Time
+	handler http.Handler
+}
+
+func newTestLimiter(maxAttempts, globalMaxAttempts int) *testLimiter {
+	l := &testLimiter{
+		SignInLimiter: NewSignInLimiter(config.Auth{
+			SignInMaxAttempts:       maxAttempts,
+			SignInGlobalMaxAttempts: globalMaxAttempts,
+			SignInWindow:            15 * time.Minute,
+			SignInLockout:           time.Minute,
+			SignInMaxLockout:        4 * time.Minute,
+		}),
+		clock: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	l.now = func() time.Time { return l.clock }
+
+	// Вход удается с паролем testSignInPassword под любым логином
+	l.handler = l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req models.SignInRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Password != testSignInPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return l
+}
+
+func (l *testLimiter) signIn(ip, login, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/signin",
+		strings.NewReader(`{"login": "`+login+`", "password": "`+password+`"}`))
+	req.RemoteAddr = ip + ":40000"
+
+	rec := httptest.NewRecorder()
+	l.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// Неудачные попытки до блокировки
+func (l *testLimiter) fail(t *testing.T, ip, login string, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		rec := l.signIn(ip, login, "wrong-password")
+		require.Equal(t, http.StatusUnauthorized, rec.Code, "attempt %d", i+1)
+	}
+}
+
+func TestSignInLimiterLockout(t *testing.T) {
+	l := newTestLimiter(3, 0)
+	const ip = "192.0.2.1"
+
+	// Каждая следующая блокировка вдвое дольше, но не дольше SignInMaxLockout
+	for _, lockout := range []string{"60", "120", "240", "240"} {
+		l.fail(t, ip, "user", 3)
+
+		rec := l.signIn(ip, "user", testSignInPassword)
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, lockout, rec.Header().Get("Retry-After"))
+
+		l.clock = l.clock.Add(30 * time.Second)
+		rec = l.signIn(ip, "user", testSignInPassword)
+		require.Equal(t, http.StatusTooManyRequests, rec.Code, "блокировка еще не кончилась")
+
+		seconds, _ := time.ParseDuration(lockout + "s")
+		l.clock = l.clock.Add(seconds)
+	}
+
+	// Другие адреса не заблокированы
+	assert.Equal(t, http.StatusOK, l.signIn("192.0.2.2", "user", testSignInPassword).Code)
+
+	// После затишья дольше окна серия блокировок начинается заново
+	l.clock = l.clock.Add(time.Hour)
+	l.fail(t, ip, "user", 3)
+	assert.Equal(t, "60", l.signIn(ip, "user", testSignInPassword).Header().Get("Retry-After"))
+}
+
+func TestSignInLimiterGlobal(t *testing.T) {
+	l := newTestLimiter(0, 3)
+
+	l.fail(t, "192.0.2.1", "user", 2)
+	l.fail(t, "192.0.2.2", "user", 1)
+
+	rec := l.signIn("192.0.2.3", "user", testSignInPassword)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+}
+
+// Успешный вход снимает неудачи только своего логина
+func TestSignInLimiterSuccess(t *testing.T) {
+	l := newTestLimiter(3, 0)
+	const ip = "192.0.2.1"
+
+	l.fail(t, ip, "user", 2)
+	require.Equal(t, http.StatusOK, l.signIn(ip, "user", testSignInPassword).Code)
+	l.fail(t, ip, "user", 2)
+	require.Equal(t, http.StatusOK, l.signIn(ip, "user", testSignInPassword).Code)
+
+	// Вход в свою учетную запись не сбрасывает подбор пароля к чужой
+	l.fail(t, ip, "victim", 2)
+	require.Equal(t, http.StatusOK, l.signIn(ip, "attacker", testSignInPassword).Code)
+	l.fail(t, ip, "victim", 1)
+
+	assert.Equal(t, http.StatusTooManyRequests, l.signIn(ip, "victim", testSignInPassword).Code)
+
+	// Пустой логин - администратор
+	l.clock = l.clock.Add(time.Minute)
+	l.fail(t, ip, "", 2)
+	require.Equal(t, http.StatusOK, l.signIn(ip, "admin", testSignInPassword).Code)
+	l.fail(t, ip, "", 2)
+}
+
+// Параллельные попытки не проверяют больше паролей, чем позволяет лимит
+func TestSignInLimiterConcurrent(t *testing.T) {
+	const (
+		limit    = 5
+		attempts = 40
+		ip       = "192.0.2.1"
+	)
+
+	l := newTestLimiter(limit, 0)
+	release := make(chan struct{})
+	var checked int32
+
+	l.handler = l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&checked, 1)
+		<-release
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	codes := make(chan *httptest.ResponseRecorder, attempts)
+	for i := 0; i < attempts; i++ {
+		go func() {
+			codes <- l.signIn(ip, "user", "wrong-password")
+		}()
+	}
+
+	// Все, кроме limit попыток, отклоняются, не дожидаясь проверки остальных
+	for i := 0; i < attempts-limit; i++ {
+		select {
+		case rec := <-codes:
+			require.Equal(t, http.StatusTooManyRequests, rec.Code)
+			assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d attempts were rejected", i, attempts-limit)
+		}
+	}
+	assert.EqualValues(t, limit, atomic.LoadInt32(&checked))
+
+	close(release)
+	for i := 0; i < limit; i++ {
+		assert.Equal(t, http.StatusUnauthorized, (<-codes).Code)
+	}
+
+	rec := l.signIn(ip, "user", testSignInPassword)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Содержимое токена доступа: стандартные exp и iat, id пользователя,
+// id сеанса и отпечаток пароля пользователя на момент выдачи
+type Claims struct {
+	UserID      int64  `json:"uid"`
+	SessionID   int64  `json:"sid"`
+	Fingerprint string `json:"pwd"`
+	jwt.RegisteredClaims
+}
+
+// Выдает токен доступа в сеансе пользователя, действующий ttl
+func NewToken(secret string, userID, sessionID int64, fingerprint string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	claims := Claims{
+		UserID:      userID,
+		SessionID:   sessionID,
+		Fingerprint: fingerprint,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+func parseToken(secret, value string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(value, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
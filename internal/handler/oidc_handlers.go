@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+// Cookie с подписанным состоянием входа через провайдера. Нужна только
+// обработчику возврата, поэтому ограничена его путем
+const (
+	oidcFlowCookie     = "oidc_flow"
+	oidcFlowCookiePath = "/api/oidc"
+	// Вход, ожидающий кода двухфакторной аутентификации
+	oidcSecondFactorCookie = "oidc_2fa"
+	// Страница ввода кода, которая отправляет его на /api/oidc/2fa
+	oidcSecondFactorPage = "/2fa.html"
+)
+
+type OIDCHandler struct {
+	service service.Service
+	cfg     *config.Config
+}
+
+func NewOIDCHandler(service service.Service, cfg config.Config) *OIDCHandler {
+	return &OIDCHandler{service: service, cfg: &cfg}
+}
+
+// Перенаправляет браузер на страницу входа провайдера
+func (h *OIDCHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, flow, err := h.service.StartOIDCLogin(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    flow,
+		Path:     oidcFlowCookiePath,
+		MaxAge:   int(service.OIDCFlowTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax: cookie должна прийти при возврате браузера с сайта провайдера
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Возврат от провайдера: завершает вход, кладет токены в cookie, как это делает
+// страница входа веб-интерфейса, и открывает веб-интерфейс. Если у пользователя
+// включена двухфакторная аутентификация, сначала открывается страница ввода кода
+func (h *OIDCHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Пользователь отказался от входа или провайдер отклонил запрос
+	if e := query.Get("error"); e != "" {
+		writeJSONError(w, r, "oidc login failed: "+e+" "+query.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+
+	flow := ""
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err == nil {
+		flow = cookie.Value
+	}
+
+	// Состояние одноразовое: cookie удаляется при любом исходе
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Path:     oidcFlowCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	user, err := h.service.FinishOIDCLogin(r.Context(), flow, query.Get("state"), query.Get("code"))
+
+	// Если включена двухфакторная аутентификация, вход записывается в журнал после проверки кода
+	var status models.TwoFactorStatusResponse
+	if err == nil {
+		status, err = h.service.GetTwoFactorStatus(r.Context(), user.ID)
+	}
+	if err != nil || !status.Enabled {
+		err = h.service.AuditSignIn(r.Context(), user.Login, user.ID, service.SignInOIDC, err)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Провайдер не проверяет второй фактор, включенный у нас: сеанс начнется после ввода кода
+	if status.Enabled {
+		pending, err := h.service.StartOIDCSecondFactor(r.Context(), user)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcSecondFactorCookie,
+			Value:    pending,
+			Path:     oidcFlowCookiePath,
+			MaxAge:   int(service.OIDCFlowTTL / time.Second),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+
+		http.Redirect(w, r, oidcSecondFactorPage, http.StatusFound)
+		return
+	}
+
+	err = h.startWebSession(w, r, user, false)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// Второй шаг входа через провайдера: проверяет код двухфакторной аутентификации
+// и начинает сеанс. Токены, как и при возврате от провайдера, попадают только в cookie
+func (h *OIDCHandler) OIDCSecondFactor(w http.ResponseWriter, r *http.Request) {
+	req, ok := readCodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	pending := ""
+	cookie, err := r.Cookie(oidcSecondFactorCookie)
+	if err == nil {
+		pending = cookie.Value
+	}
+
+	// Неверный код можно ввести еще раз, пока cookie не истекла. После верного
+	// вход расходуется, и повтор той же cookie уже не начнет сеанс
+	user, err := h.service.GetOIDCSecondFactorUser(r.Context(), pending)
+	var verified bool
+	if err == nil {
+		verified, err = h.service.VerifySecondFactor(r.Context(), user.ID, req.Code)
+	}
+	if err == nil {
+		err = h.service.FinishOIDCSecondFactor(r.Context(), pending)
+	}
+	err = h.service.AuditSignIn(r.Context(), user.Login, user.ID, service.SignInOIDC, err)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcSecondFactorCookie,
+		Path:     oidcFlowCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	err = h.startWebSession(w, r, user, verified)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Логин пользователя, чей вход ожидает кода, для ограничителя попыток. Пустой,
+// если cookie нет или она недействительна
+func (h *OIDCHandler) SecondFactorLogin(r *http.Request) string {
+	cookie, err := r.Cookie(oidcSecondFactorCookie)
+	if err != nil {
+		return ""
+	}
+
+	user, err := h.service.GetOIDCSecondFactorUser(r.Context(), cookie.Value)
+	if err != nil {
+		return ""
+	}
+	return user.Login
+}
+
+// Начинает сеанс и кладет токены доступа и обновления в cookie веб-интерфейса
+func (h *OIDCHandler) startWebSession(w http.ResponseWriter, r *http.Request, user models.User, secondFactor bool) error {
+	session, refreshToken, err := h.service.StartSession(r.Context(), user, secondFactor)
+	if err != nil {
+		return err
+	}
+
+	token, err := middleware.NewToken(h.cfg.Auth.Secret, user.ID, session.ID, service.PasswordFingerprint(user), h.cfg.Auth.TokenTTL)
+	if err != nil {
+		return err
+	}
+
+	setSessionCookies(w, r, h.cfg, token, refreshToken)
+	return nil
+}
@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+const (
+	testOIDCClientID    = "scheduler"
+	testOIDCRedirectURL = "http://localhost:7540/api/oidc/callback"
+)
+
+// Провайдер OpenID Connect, который сразу выдает код для указанного пользователя
+type testProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]testGrant
+}
+
+type testGrant struct {
+	challenge string
+	nonce     string
+	subject   string
+	username  string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &testProvider{t: t, key: key, grants: map[string]testGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// Запоминает запрос на вход и возвращает код и state, с которыми провайдер вернул бы пользователя
+func (p *testProvider) authorize(authURL, subject, username string) (code, state string) {
+	u, err := url.Parse(authURL)
+	require.NoError(p.t, err)
+	q := u.Query()
+
+	code = fmt.Sprintf("code-%d", time.Now().UnixNano())
+
+	p.mu.Lock()
+	p.grants[code] = testGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), subject: subject, username: username}
+	p.mu.Unlock()
+
+	return code, q.Get("state")
+}
+
+func (p *testProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	grant, ok := p.grants[r.PostFormValue("code")]
+	delete(p.grants, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                p.server.URL,
+		"aud":                testOIDCClientID,
+		"sub":                grant.subject,
+		"nonce":              grant.nonce,
+		"preferred_username": grant.username,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "key-1"
+	idToken, err := token.SignedString(p.key)
+	require.NoError(p.t, err)
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+}
+
+func newOIDCTestServer(t *testing.T) (*testServer, *testProvider) {
+	provider := newTestProvider(t)
+
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.OIDC = config.OIDC{
+			Issuer:       provider.server.URL,
+			ClientID:     testOIDCClientID,
+			ClientSecret: "client-secret",
+			RedirectURL:  testOIDCRedirectURL,
+			Scopes:       "openid profile email",
+		}
+	})
+
+	return s, provider
+}
+
+// Запрос без токена администратора с cookie
+func (s *testServer) cookieRequest(method, path, body string, cookies ...*http.Cookie) testResponse {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for _, cookie := range cookies {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return testResponse{status: rec.Code, header: rec.Header(), body: rec.Body.Bytes()}
+}
+
+// Проходит вход через провайдера и возвращает ответ обработчика возврата.
+// state - подмена state из ответа провайдера, пустая - без подмены
+func (s *testServer) oidcLogin(t *testing.T, provider *testProvider, subject, username, state string) testResponse {
+	t.Helper()
+
+	resp := s.cookieRequest(http.MethodGet, "/api/oidc/login", "")
+	require.Equal(t, http.StatusFound, resp.status, string(resp.body))
+	flow := responseCookie(resp, oidcFlowCookie)
+	require.NotNil(t, flow)
+
+	code, providerState := provider.authorize(resp.header.Get("Location"), subject, username)
+	if state == "" {
+		state = providerState
+	}
+
+	query := url.Values{"code": {code}, "state": {state}}
+	return s.cookieRequest(http.MethodGet, "/api/oidc/callback?"+query.Encode(), "", flow)
+}
+
+func TestOIDCCallback(t *testing.T) {
+	s, provider := newOIDCTestServer(t)
+
+	resp := s.oidcLogin(t, provider, "sub-alice", "alice", "")
+	require.Equal(t, http.StatusFound, resp.status, string(resp.body))
+	assert.Equal(t, "/", resp.header.Get("Location"))
+	assert.Equal(t, -1, responseCookie(resp, oidcFlowCookie).MaxAge, "состояние входа одноразовое")
+
+	token := responseCookie(resp, "token")
+	require.NotNil(t, token)
+	assert.True(t, token.HttpOnly)
+	assert.Equal(t, http.StatusOK, s.cookieRequest(http.MethodGet, "/api/tasks", "", token).status)
+
+	// Токен обновления не теряется: веб-интерфейс продлевает сеанс через /api/refresh
+	refresh := responseCookie(resp, refreshCookie)
+	require.NotNil(t, refresh)
+	resp = s.cookieRequest(http.MethodPost, "/api/refresh", "", refresh)
+	assert.Equal(t, http.StatusOK, resp.status, string(resp.body))
+}
+
+func TestOIDCCallbackRejected(t *testing.T) {
+	s, provider := newOIDCTestServer(t)
+
+	resp := s.oidcLogin(t, provider, "sub-alice", "alice", "forged")
+	assert.Equal(t, http.StatusUnauthorized, resp.status)
+	assert.Nil(t, responseCookie(resp, "token"))
+	assert.Equal(t, -1, responseCookie(resp, oidcFlowCookie).MaxAge)
+
+	// Без cookie с состоянием вход не завершается
+	resp = s.cookieRequest(http.MethodGet, "/api/oidc/callback?code=code&state=state", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.status)
+
+	resp = s.cookieRequest(http.MethodGet, "/api/oidc/callback?error=access_denied", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.status)
+}
+
+// Данные токена доступа из cookie
+func tokenClaims(t *testing.T, cookie *http.Cookie) *middleware.Claims {
+	require.NotNil(t, cookie)
+
+	claims := &middleware.Claims{}
+	_, _, err := jwt.NewParser().ParseUnverified(cookie.Value, claims)
+	require.NoError(t, err)
+	return claims
+}
+
+// Код TOTP по RFC 6238 для ключа в base32
+func testTOTPCode(t *testing.T, secret string, at time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// Провайдер не знает о двухфакторной аутентификации, включенной у нас:
+// сеанс начинается только после ввода кода
+// Входит через провайдера под alice и включает TOTP в начатом сеансе.
+// Возвращает утверждения ее токена и коды восстановления
+func (s *testServer) enableOIDCTwoFactor(t *testing.T, provider *testProvider) (*middleware.Claims, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	resp := s.oidcLogin(t, provider, "sub-alice", "alice", "")
+	require.Equal(t, http.StatusFound, resp.status)
+	alice := tokenClaims(t, responseCookie(resp, "token"))
+
+	setup, err := s.svc.SetupTwoFactor(ctx, alice.UserID)
+	require.NoError(t, err)
+	recoveryCodes, err := s.svc.EnableTwoFactor(ctx, alice.UserID, alice.SessionID, testTOTPCode(t, setup.Secret, time.Now()))
+	require.NoError(t, err)
+
+	return alice, recoveryCodes
+}
+
+func TestOIDCCallbackTwoFactor(t *testing.T) {
+	s, provider := newOIDCTestServer(t)
+	ctx := context.Background()
+
+	alice, recoveryCodes := s.enableOIDCTwoFactor(t, provider)
+
+	resp := s.oidcLogin(t, provider, "sub-alice", "alice", "")
+	require.Equal(t, http.StatusFound, resp.status, string(resp.body))
+	assert.Equal(t, oidcSecondFactorPage, resp.header.Get("Location"))
+	assert.Nil(t, responseCookie(resp, "token"))
+	assert.Nil(t, responseCookie(resp, refreshCookie))
+	pending := responseCookie(resp, oidcSecondFactorCookie)
+	require.NotNil(t, pending)
+
+	resp = s.cookieRequest(http.MethodPost, "/api/oidc/2fa", `{"code": "000000"}`, pending)
+	assert.Equal(t, http.StatusUnauthorized, resp.status)
+	assert.Nil(t, responseCookie(resp, "token"))
+
+	resp = s.cookieRequest(http.MethodPost, "/api/oidc/2fa", `{"code": "`+recoveryCodes[0]+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.status, "код без cookie входа не принимается")
+
+	resp = s.cookieRequest(http.MethodPost, "/api/oidc/2fa", `{"code": "`+recoveryCodes[0]+`"}`, pending)
+	require.Equal(t, http.StatusNoContent, resp.status, string(resp.body))
+	assert.Equal(t, -1, responseCookie(resp, oidcSecondFactorCookie).MaxAge)
+	require.NotNil(t, responseCookie(resp, refreshCookie))
+
+	token := responseCookie(resp, "token")
+	require.NotNil(t, token)
+	assert.Equal(t, http.StatusOK, s.cookieRequest(http.MethodGet, "/api/tasks", "", token).status,
+		"сеанс начат с проверенным вторым фактором")
+
+	assert.Equal(t, alice.UserID, tokenClaims(t, token).UserID)
+
+	events, err := s.svc.GetAuditEvents(ctx, 1, models.AuditFilter{Action: models.AuditSignIn, UserID: alice.UserID})
+	require.NoError(t, err)
+	require.Len(t, events, 3, "первый вход, неверный код и вход с кодом")
+	assert.True(t, events[0].Success)
+	assert.False(t, events[1].Success)
+}
+
+func TestOIDCSecondFactorReplay(t *testing.T) {
+	s, provider := newOIDCTestServer(t)
+	_, recoveryCodes := s.enableOIDCTwoFactor(t, provider)
+
+	resp := s.oidcLogin(t, provider, "sub-alice", "alice", "")
+	require.Equal(t, http.StatusFound, resp.status, string(resp.body))
+	pending := responseCookie(resp, oidcSecondFactorCookie)
+	require.NotNil(t, pending)
+
+	resp = s.cookieRequest(http.MethodPost, "/api/oidc/2fa", `{"code": "`+recoveryCodes[0]+`"}`, pending)
+	require.Equal(t, http.StatusNoContent, resp.status, string(resp.body))
+
+	// Та же cookie с другим верным кодом не начинает второй сеанс
+	resp = s.cookieRequest(http.MethodPost, "/api/oidc/2fa", `{"code": "`+recoveryCodes[1]+`"}`, pending)
+	assert.Equal(t, http.StatusUnauthorized, resp.status)
+	assert.Nil(t, responseCookie(resp, "token"))
+	assert.Nil(t, responseCookie(resp, refreshCookie))
+}
+
+func TestOIDCSecondFactorKeepsAdminFailures(t *testing.T) {
+	s, provider := newOIDCTestServer(t)
+	_, recoveryCodes := s.enableOIDCTwoFactor(t, provider)
+	s.token = ""
+
+	// Лимит 5: три неудачи входа администратора
+	for i := 0; i < 3; i++ {
+		resp := s.request(t, http.MethodPost, "/api/signin", `{"password": "wrong"}`, nil)
+		require.Equal(t, http.StatusUnauthorized, resp.status)
+	}
+
+	resp := s.oidcLogin(t, provider, "sub-alice", "alice", "")
+	require.Equal(t, http.StatusFound, resp.status, string(resp.body))
+	pending := responseCookie(resp, oidcSecondFactorCookie)
+
+	resp = s.cookieRequest(http.MethodPost, "/api/oidc/2fa", `{"code": "`+recoveryCodes[0]+`"}`, pending)
+	require.Equal(t, http.StatusNoContent, resp.status, string(resp.body))
+
+	// Успешный вход alice не снял неудачи администратора: четвертая и пятая блокируют вход
+	resp = s.request(t, http.MethodPost, "/api/signin", `{"password": "wrong"}`, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.status)
+	resp = s.cookieRequest(http.MethodPost, "/api/oidc/2fa", `{"code": "000000"}`)
+	require.Equal(t, http.StatusUnauthorized, resp.status)
+
+	resp = s.request(t, http.MethodPost, "/api/signin", `{"password": "`+testPassword+`"}`, nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.status)
+}
@@ -0,0 +1,156 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+const ContentType = "application/problem+json; charset=UTF-8"
+
+// Нестандартный статус nginx: клиент закрыл соединение, не дождавшись ответа
+const StatusClientClosedRequest = 499
+
+// Значения поля type. Относительные ссылки допускаются RFC 7807
+const (
+	TypeValidation      = "/problems/validation"
+	TypeNotFound        = "/problems/not-found"
+	TypeConflict        = "/problems/conflict"
+	TypeUnauthorized    = "/problems/unauthorized"
+	TypeForbidden       = "/problems/forbidden"
+	TypePrecondition    = "/problems/precondition-failed"
+	TypeTimeout         = "/problems/timeout"
+	TypeTooManyRequests = "/problems/too-many-requests"
+	TypeDefault         = "about:blank"
+)
+
+// В режиме совместимости клиенты, которые не просят application/problem+json
+// в заголовке Accept, получают старый ответ {"error": "..."} - его читают
+// веб-интерфейс и старые клиенты. Включается один раз при старте
+var legacy = true
+
+func SetLegacy(enabled bool) {
+	legacy = enabled
+}
+
+func New(status int, detail string) models.Problem {
+	problemType := TypeDefault
+	switch status {
+	case http.StatusBadRequest:
+		problemType = TypeValidation
+	case http.StatusNotFound:
+		problemType = TypeNotFound
+	case http.StatusConflict:
+		problemType = TypeConflict
+	case http.StatusUnauthorized:
+		problemType = TypeUnauthorized
+	case http.StatusForbidden:
+		problemType = TypeForbidden
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		problemType = TypePrecondition
+	case http.StatusGatewayTimeout:
+		problemType = TypeTimeout
+	case http.StatusTooManyRequests:
+		problemType = TypeTooManyRequests
+	}
+
+	title := http.StatusText(status)
+	if status == StatusClientClosedRequest {
+		title = "Client Closed Request"
+	}
+
+	return models.Problem{
+		Type:   problemType,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Единственное место, где ошибки сервисов превращаются в HTTP статусы.
+// Текст внутренних ошибок (например, ошибок базы данных) клиенту не отдается,
+// он только пишется в лог
+func FromError(err error) models.Problem {
+	switch {
+	case errors.Is(err, models.ErrValidation):
+		p := New(http.StatusBadRequest, err.Error())
+		p.Errors = fieldErrors(err)
+		return p
+	case errors.Is(err, models.ErrNotFound):
+		return New(http.StatusNotFound, err.Error())
+	case errors.Is(err, models.ErrConflict):
+		return New(http.StatusConflict, err.Error())
+	case errors.Is(err, models.ErrPreconditionFailed):
+		return New(http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, models.ErrUnprocessable):
+		return New(http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, models.ErrUnauthorized):
+		return New(http.StatusUnauthorized, err.Error())
+	case errors.Is(err, models.ErrForbidden):
+		return New(http.StatusForbidden, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(http.StatusGatewayTimeout, "Request timed out")
+	case errors.Is(err, context.Canceled):
+		return New(StatusClientClosedRequest, "Request was canceled")
+	default:
+		log.Printf("internal error: %v", err)
+		return New(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func fieldErrors(err error) []models.FieldError {
+	var list models.ValidationErrors
+	if !errors.As(err, &list) {
+		var single *models.ValidationError
+		if !errors.As(err, &single) {
+			return nil
+		}
+		list = models.ValidationErrors{single}
+	}
+
+	var fields []models.FieldError
+	for _, e := range list {
+		if e.Field == "" {
+			continue
+		}
+		fields = append(fields, models.FieldError{Field: e.Field, Message: e.Error()})
+	}
+
+	return fields
+}
+
+func Write(w http.ResponseWriter, r *http.Request, p models.Problem) {
+	if legacy && !acceptsProblem(r) {
+		message := p.Detail
+		if message == "" {
+			message = p.Title
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(p.Status)
+		json.NewEncoder(w).Encode(models.ErrorResponse{Error: message})
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	Write(w, r, FromError(err))
+}
+
+func acceptsProblem(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.Contains(accept, "application/problem+json") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		// Пусто - клиент видит текст самой ошибки
+		detail string
+	}{
+		{models.NewValidationError("date", "invalid date", nil), http.StatusBadRequest, ""},
+		{fmt.Errorf("task with id 1 %w", models.ErrNotFound), http.StatusNotFound, ""},
+		{fmt.Errorf("already undone: %w", models.ErrConflict), http.StatusConflict, ""},
+		{fmt.Errorf("version mismatch: %w", models.ErrPreconditionFailed), http.StatusPreconditionFailed, ""},
+		{fmt.Errorf("key reused: %w", models.ErrUnprocessable), http.StatusUnprocessableEntity, ""},
+		{fmt.Errorf("bad password: %w", models.ErrUnauthorized), http.StatusUnauthorized, ""},
+		{fmt.Errorf("viewer: %w", models.ErrForbidden), http.StatusForbidden, ""},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "Request timed out"},
+		{fmt.Errorf("query: %w", context.Canceled), StatusClientClosedRequest, "Request was canceled"},
+	}
+
+	for _, tt := range tests {
+		detail := tt.detail
+		if detail == "" {
+			detail = tt.err.Error()
+		}
+
+		p := FromError(tt.err)
+		assert.Equal(t, tt.status, p.Status, tt.err.Error())
+		assert.Equal(t, detail, p.Detail)
+	}
+}
+
+// Текст внутренних ошибок остается в логе
+func TestFromErrorInternal(t *testing.T) {
+	p := FromError(errors.New("database is locked"))
+	assert.Equal(t, http.StatusInternalServerError, p.Status)
+	assert.Equal(t, "Internal server error", p.Detail)
+}
+
+func TestWrite(t *testing.T) {
+	err := models.ValidationErrors{
+		models.NewValidationError("title", "title is required", nil),
+		models.NewValidationError("date", "invalid date format", nil),
+	}
+
+	write := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/task", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		WriteError(w, r, err)
+		return w
+	}
+
+	w := write("application/problem+json")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+
+	var p models.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, TypeValidation, p.Type)
+	assert.Equal(t, "Bad Request", p.Title)
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, []models.FieldError{
+		{Field: "title", Message: "title is required"},
+		{Field: "date", Message: "invalid date format"},
+	}, p.Errors)
+
+	// Старые клиенты получают {"error": "..."}
+	w = write("")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error": "title is required; invalid date format"}`, w.Body.String())
+
+	SetLegacy(false)
+	defer SetLegacy(true)
+
+	w = write("")
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+}
@@ -5,26 +5,103 @@ import (
 
 	"github.com/Oxygenss/yandex_final_project/internal/config"
 	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/problem"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
 	"github.com/go-chi/chi"
 )
 
 func (h *Handler) InitRoutes(config config.Config) *chi.Mux {
+	problem.SetLegacy(config.Server.LegacyErrors)
+
 	router := chi.NewRouter()
+	router.Use(middleware.ClientIP)
 
-	router.Post("/api/signin", h.SignIn)
+	router.Post("/api/signup", h.SignUp)
+	// Один ограничитель на процесс: счетчики попыток общие для всех запросов
+	limiter := middleware.NewSignInLimiter(config.Auth)
+	router.With(limiter.Limit).Post("/api/signin", h.SignIn)
+	router.Post("/api/refresh", h.Refresh)
+	router.Get("/api/oidc/login", h.OIDCLogin)
+	router.Get("/api/oidc/callback", h.OIDCCallback)
+	// Код второго фактора подбирается так же, как пароль, поэтому попытки ограничены.
+	// Логин попытки берется из ожидающего входа, а не из тела запроса
+	router.With(limiter.LimitBy(h.SecondFactorLogin)).Post("/api/oidc/2fa", h.OIDCSecondFactor)
 	router.Get("/api/nextdate", h.NextDateHandler)
 
 	router.Group(func(r chi.Router) {
 		r.Use(func(next http.Handler) http.Handler {
-			return middleware.AuthMiddleware(config, next)
+			return middleware.AuthMiddleware(config, h.service, next)
+		})
+
+		// Токенам API маршруты доступны по правам, сессиям - все
+		r.Group(func(r chi.Router) {
+			r.Use(func(next http.Handler) http.Handler {
+				return middleware.RequireScope(models.ScopeTasksRead, next)
+			})
+
+			r.Get("/api/task", h.GetTaskByID)
+			r.Get("/api/task/revisions", h.GetRevisions)
+			r.Get("/api/tasks", h.GetTasks)
+
+			r.Get("/api/tasks/{id}", h.GetTaskByID)
+			r.Get("/api/tasks/{id}/revisions", h.GetRevisions)
 		})
 
-		r.Post("/api/task", h.AddTask)
-		r.Get("/api/task", h.GetTaskByID)
-		r.Put("/api/task", h.EditTask)
-		r.Delete("/api/task", h.DeleteTask)
-		r.Post("/api/task/done", h.DoneTask)
-		r.Get("/api/tasks", h.GetTasks)
+		r.Group(func(r chi.Router) {
+			r.Use(func(next http.Handler) http.Handler {
+				return middleware.RequireScope(models.ScopeTasksWrite, next)
+			})
+
+			r.Post("/api/task", h.Idempotent("add", h.AddTask))
+			r.Put("/api/task", h.EditTask)
+			r.Patch("/api/task", h.PatchTask)
+			r.Delete("/api/task", h.DeleteTask)
+			r.Post("/api/task/done", h.Idempotent("done", h.DoneTask))
+			r.Post("/api/task/rollback", h.RollbackTask)
+			r.Post("/api/tasks/bulk", h.BulkTasks)
+
+			r.Put("/api/tasks/{id}", h.EditTask)
+			r.Patch("/api/tasks/{id}", h.PatchTask)
+			r.Delete("/api/tasks/{id}", h.DeleteTask)
+			r.Post("/api/tasks/{id}/done", h.Idempotent("done", h.DoneTask))
+			r.Post("/api/tasks/{id}/rollback", h.RollbackTask)
+
+			r.Post("/api/undo", h.Undo)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireSession)
+
+			r.Post("/api/tokens", h.CreateAPIToken)
+			r.Get("/api/tokens", h.GetAPITokens)
+			r.Delete("/api/tokens/{id}", h.RevokeAPIToken)
+
+			r.Post("/api/logout", h.Logout)
+
+			r.Get("/api/2fa", h.GetTwoFactorStatus)
+			r.Post("/api/2fa/setup", h.SetupTwoFactor)
+			r.Post("/api/2fa/enable", h.EnableTwoFactor)
+			r.Post("/api/2fa/disable", h.DisableTwoFactor)
+			r.Post("/api/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+
+			// Управление списками только из сессии, задачи списков доступны и токенам API
+			r.Get("/api/lists", h.GetLists)
+			r.Post("/api/lists", h.CreateList)
+			r.Get("/api/lists/{id}", h.GetList)
+			r.Put("/api/lists/{id}", h.RenameList)
+			r.Delete("/api/lists/{id}", h.DeleteList)
+			r.Post("/api/lists/{id}/members", h.InviteMember)
+			r.Put("/api/lists/{id}/members/{user}", h.UpdateMemberRole)
+			r.Delete("/api/lists/{id}/members/{user}", h.RemoveMember)
+
+			r.Get("/api/invitations", h.GetInvitations)
+			r.Post("/api/invitations/{id}/accept", h.AcceptInvitation)
+			r.Delete("/api/invitations/{id}", h.DeclineInvitation)
+
+			// Журнал аудита доступен только администратору, это проверяет сервис
+			r.Get("/api/admin/audit", h.GetAuditEvents)
+			r.Get("/api/admin/audit/export", h.ExportAuditEvents)
+		})
 	})
 
 	webDir := "./web"
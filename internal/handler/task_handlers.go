@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/handler/problem"
 	"github.com/Oxygenss/yandex_final_project/internal/models"
 	"github.com/Oxygenss/yandex_final_project/internal/service"
-	"github.com/golang-jwt/jwt"
+	"github.com/go-chi/chi"
 )
 
 type TaskHandler struct {
@@ -21,149 +25,256 @@ func NewTaskHandler(service service.Service, cfg config.Config) *TaskHandler {
 	return &TaskHandler{service: service, cfg: &cfg}
 }
 
-func writeJSONError(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(models.ErrorResponse{Error: message})
+// id задачи берется из пути (/api/tasks/{id}), а если его там нет -
+// из параметра запроса (/api/task?id=), которым пользуется веб-интерфейс
+func taskID(r *http.Request) string {
+	if id := chi.URLParam(r, "id"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("id")
 }
 
-func (h *TaskHandler) SignIn(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusBadRequest)
-		return
+// id операции в журнале отмены отдается в заголовке,
+// чтобы не менять тело ответов, на которое рассчитывает веб-интерфейс
+func setOperationID(w http.ResponseWriter, opID int64) {
+	w.Header().Set("X-Operation-ID", strconv.FormatInt(opID, 10))
+}
+
+// Ожидаемая версия задачи из заголовка If-Match ("3" или *), 0 - проверка не нужна.
+// Если заголовок обязателен по конфигу, но не передан, отвечает 428
+func (h *TaskHandler) expectedVersion(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if h.cfg.Server.RequireIfMatch {
+			writeJSONError(w, r, "If-Match header is required", http.StatusPreconditionRequired)
+			return 0, false
+		}
+		return 0, true
 	}
 
-	var AuthRequest models.SignInRequest
-	err = json.Unmarshal(body, &AuthRequest)
-	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusBadRequest)
-		return
+	if header == "*" {
+		return 0, true
 	}
 
-	password := h.cfg.Auth.Password
-	if password == "" {
-		writeJSONError(w, "Authentication is not configured", http.StatusInternalServerError)
-		return
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) {
+		// Такой ETag сервер не выдает, значит, с текущей версией он не совпадет
+		writeJSONError(w, r, "If-Match does not match the current version of the task", http.StatusPreconditionFailed)
+		return 0, false
 	}
 
-	if password != AuthRequest.Password {
-		writeJSONError(w, "Incorrect password", http.StatusUnauthorized)
+	return version, true
+}
+
+func (h *TaskHandler) DoneTask(w http.ResponseWriter, r *http.Request) {
+	idStr := taskID(r)
+	if idStr == "" {
+		writeJSONError(w, r, "Identifier not specified", http.StatusBadRequest)
 		return
 	}
 
-	claims := jwt.MapClaims{
-		"expires": time.Now().Add(time.Hour * 24).Unix(),
+	version, ok := h.expectedVersion(w, r)
+	if !ok {
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signedToken, err := token.SignedString([]byte(h.cfg.Auth.Secret))
+	opID, err := h.service.DoneTask(r.Context(), middleware.UserID(r.Context()), idStr, version)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	setOperationID(w, opID)
+
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(models.SignInResponse{Token: signedToken})
+	json.NewEncoder(w).Encode(struct{}{})
 }
 
-func (h *TaskHandler) DoneTask(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("id")
+func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
+	idStr := taskID(r)
 	if idStr == "" {
-		writeJSONError(w, "Identifier not specified", http.StatusBadRequest)
+		writeJSONError(w, r, "Identifier not specified", http.StatusBadRequest)
+		return
+	}
+
+	version, ok := h.expectedVersion(w, r)
+	if !ok {
 		return
 	}
 
-	err := h.service.DoneTask(idStr)
+	opID, err := h.service.DeleteTask(r.Context(), middleware.UserID(r.Context()), idStr, version)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	setOperationID(w, opID)
+
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(struct{}{})
+
 }
 
-func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("id")
-	if idStr == "" {
-		writeJSONError(w, "Identifier not specified", http.StatusBadRequest)
+func (h *TaskHandler) EditTask(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err := h.service.DeleteTask(idStr)
+	var task models.Task
+	err = json.Unmarshal(body, &task)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if id := chi.URLParam(r, "id"); id != "" {
+		if task.ID != "" && task.ID != id {
+			writeJSONError(w, r, "Identifier in body does not match the URL", http.StatusBadRequest)
+			return
+		}
+		task.ID = id
+	}
+
+	version, ok := h.expectedVersion(w, r)
+	if !ok {
+		return
+	}
+	task.Version = version
+
+	opID, err := h.service.EditTask(r.Context(), middleware.UserID(r.Context()), task)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setOperationID(w, opID)
+
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(struct{}{})
-
 }
 
-func (h *TaskHandler) EditTask(w http.ResponseWriter, r *http.Request) {
+func (h *TaskHandler) PatchTask(w http.ResponseWriter, r *http.Request) {
+	idStr := taskID(r)
+	if idStr == "" {
+		writeJSONError(w, r, "Identifier not specified", http.StatusBadRequest)
+		return
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var task models.Task
-	err = json.Unmarshal(body, &task)
+	patch, err := decodeTaskPatch(body)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	err = h.service.EditTask(task)
+	version, ok := h.expectedVersion(w, r)
+	if !ok {
+		return
+	}
+
+	opID, err := h.service.PatchTask(r.Context(), middleware.UserID(r.Context()), idStr, version, patch)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	setOperationID(w, opID)
+
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(struct{}{})
 }
 
+// Разбирает тело JSON Merge Patch. null сбрасывает поле в пустую строку,
+// отсутствующие поля не меняются, id и неизвестные поля игнорируются
+func decodeTaskPatch(body []byte) (models.TaskPatch, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		return models.TaskPatch{}, models.NewValidationError("", "patch must be a JSON object", err)
+	}
+
+	var patch models.TaskPatch
+	targets := map[string]**string{
+		"date":    &patch.Date,
+		"title":   &patch.Title,
+		"comment": &patch.Comment,
+		"repeat":  &patch.Repeat,
+	}
+
+	for name, target := range targets {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+
+		value := ""
+		if string(raw) != "null" {
+			err = json.Unmarshal(raw, &value)
+			if err != nil {
+				return models.TaskPatch{}, models.NewValidationError(name, "field "+name+" must be a string or null", nil)
+			}
+		}
+		*target = &value
+	}
+
+	return patch, nil
+}
+
 func (h *TaskHandler) GetTaskByID(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("id")
+	idStr := taskID(r)
 	if idStr == "" {
-		writeJSONError(w, "Identifier not specified", http.StatusBadRequest)
+		writeJSONError(w, r, "Identifier not specified", http.StatusBadRequest)
 		return
 	}
 
-	task, err := h.service.GetTaskByID(idStr)
+	task, err := h.service.GetTaskByID(r.Context(), middleware.UserID(r.Context()), idStr)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(task.Version, 10)))
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(task)
 }
 
+// Без параметра list возвращаются личные задачи, с ним - задачи общего списка
 func (h *TaskHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
 	search := r.URL.Query().Get("search")
 
+	var listID int64
+	if list := r.URL.Query().Get("list"); list != "" {
+		id, err := strconv.ParseInt(list, 10, 64)
+		if err != nil || id <= 0 {
+			writeError(w, r, models.NewValidationError("list", "failed to parse list id", err))
+			return
+		}
+		listID = id
+	}
+
 	var tasks []models.Task
 	var err error
 
 	if search != "" {
-		tasks, err = h.service.SearchTasks(search)
+		tasks, err = h.service.SearchTasks(r.Context(), middleware.UserID(r.Context()), listID, search)
 	} else {
-		tasks, err = h.service.GetTasks()
+		tasks, err = h.service.GetTasks(r.Context(), middleware.UserID(r.Context()), listID)
 	}
 
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -175,28 +286,114 @@ func (h *TaskHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
 func (h *TaskHandler) AddTask(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	var task models.Task
 	err = json.Unmarshal(body, &task)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	id, err := h.service.AddTask(task)
+	id, opID, err := h.service.AddTask(r.Context(), middleware.UserID(r.Context()), task)
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	setOperationID(w, opID)
+
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(models.AddTaskResponse{ID: id})
 }
 
+func (h *TaskHandler) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	idStr := taskID(r)
+	if idStr == "" {
+		writeJSONError(w, r, "Identifier not specified", http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := h.service.GetRevisions(r.Context(), middleware.UserID(r.Context()), idStr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.GetRevisionsResponse{Revisions: revisions})
+}
+
+func (h *TaskHandler) RollbackTask(w http.ResponseWriter, r *http.Request) {
+	idStr := taskID(r)
+	if idStr == "" {
+		writeJSONError(w, r, "Identifier not specified", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := strconv.ParseInt(r.URL.Query().Get("revision"), 10, 64)
+	if err != nil {
+		writeJSONError(w, r, "Invalid revision number", http.StatusBadRequest)
+		return
+	}
+
+	opID, err := h.service.RollbackTask(r.Context(), middleware.UserID(r.Context()), idStr, revision)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setOperationID(w, opID)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+// Отвечает 200, если пакет зафиксирован (в режиме best_effort - даже при ошибках
+// отдельных операций). Если пакет в режиме atomic откатился, статус ответа
+// берется из ошибки операции, на которой он прервался
+func (h *TaskHandler) BulkTasks(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req models.BulkRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.service.BulkTasks(r.Context(), middleware.UserID(r.Context()), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	for i, result := range resp.Results {
+		if result.Err == nil {
+			continue
+		}
+		p := problem.FromError(result.Err)
+		resp.Results[i].Error = &p
+		if !resp.Committed {
+			status = p.Status
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
 func (h *TaskHandler) NextDateHandler(w http.ResponseWriter, r *http.Request) {
 	nowStr := r.URL.Query().Get("now")
 	dateStr := r.URL.Query().Get("date")
@@ -204,13 +401,13 @@ func (h *TaskHandler) NextDateHandler(w http.ResponseWriter, r *http.Request) {
 
 	now, err := time.Parse("20060102", nowStr)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, models.NewValidationError("now", "invalid now format. Expected format is YYYYMMDD", err))
 		return
 	}
 
 	next, err := h.service.NextDate(now, dateStr, repeat)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+)
+
+// Каждое изменение попадает в историю, а откат возвращает поля до ревизии
+// и сам становится новой ревизией
+func TestRevisionsAndRollback(t *testing.T) {
+	s := newTestServer(t)
+	id := s.addTask(t, `{"title": "Зарядка"}`)
+
+	resp := s.request(t, http.MethodPut, "/api/task", `{"id": "`+id+`", "title": "Пробежка"}`, nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	resp = s.request(t, http.MethodGet, "/api/task/revisions?id="+id, "", nil)
+	require.Equal(t, http.StatusOK, resp.status)
+	revisions := resp.json(t)["revisions"].([]interface{})
+	require.Len(t, revisions, 1)
+	changes := revisions[0].(map[string]interface{})["changes"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"old": "Зарядка", "new": "Пробежка"}, changes["title"])
+	assert.NotContains(t, changes, "comment")
+
+	resp = s.request(t, http.MethodPost, "/api/task/rollback?id="+id+"&revision=1", "", nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+	assert.NotEmpty(t, resp.header.Get("X-Operation-ID"))
+
+	resp = s.request(t, http.MethodGet, "/api/task?id="+id, "", nil)
+	assert.Equal(t, "Зарядка", resp.json(t)["title"])
+
+	resp = s.request(t, http.MethodGet, "/api/tasks/"+id+"/revisions", "", nil)
+	assert.Len(t, resp.json(t)["revisions"], 2)
+
+	resp = s.request(t, http.MethodPost, "/api/task/rollback?id="+id+"&revision=10", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.status)
+
+	resp = s.request(t, http.MethodPost, "/api/task/rollback?id="+id+"&revision=first", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.status)
+}
+
+// JSON Merge Patch меняет только переданные поля, null сбрасывает поле
+func TestPatchTask(t *testing.T) {
+	s := newTestServer(t)
+	id := s.addTask(t, `{"title": "Зарядка", "comment": "утром", "repeat": "d 1"}`)
+
+	resp := s.request(t, http.MethodPatch, "/api/task?id="+id, `{"title": "Пробежка", "comment": null}`, nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	task := s.request(t, http.MethodGet, "/api/task?id="+id, "", nil).json(t)
+	assert.Equal(t, "Пробежка", task["title"])
+	assert.Equal(t, "", task["comment"])
+	assert.Equal(t, "d 1", task["repeat"])
+
+	resp = s.request(t, http.MethodPatch, "/api/task?id="+id, `{"title": 5}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.status)
+
+	resp = s.request(t, http.MethodPatch, "/api/task?id="+id, `{"title": ""}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.status)
+
+	resp = s.request(t, http.MethodPatch, "/api/task?id="+id, `["title"]`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.status)
+
+	resp = s.request(t, http.MethodPatch, "/api/task?id=100", `{"title": "Пробежка"}`, nil)
+	assert.Equal(t, http.StatusNotFound, resp.status)
+}
+
+// Маршруты /api/tasks/{id} работают так же, как /api/task?id=
+func TestResourceRoutes(t *testing.T) {
+	s := newTestServer(t)
+	id := s.addTask(t, `{"title": "Зарядка"}`)
+
+	resp := s.request(t, http.MethodGet, "/api/tasks/"+id, "", nil)
+	require.Equal(t, http.StatusOK, resp.status)
+	assert.Equal(t, id, resp.json(t)["id"])
+
+	resp = s.request(t, http.MethodPut, "/api/tasks/"+id, `{"title": "Пробежка"}`, nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+	assert.Equal(t, "Пробежка", s.request(t, http.MethodGet, "/api/task?id="+id, "", nil).json(t)["title"])
+
+	resp = s.request(t, http.MethodPut, "/api/tasks/"+id, `{"id": "100", "title": "Пробежка"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.status)
+
+	resp = s.request(t, http.MethodPost, "/api/tasks/"+id+"/done", "", nil)
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	// Задача без повторения после выполнения удаляется
+	resp = s.request(t, http.MethodGet, "/api/tasks/"+id, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.status)
+
+	id = s.addTask(t, `{"title": "Зарядка"}`)
+	resp = s.request(t, http.MethodDelete, "/api/tasks/"+id, "", nil)
+	require.Equal(t, http.StatusOK, resp.status)
+	resp = s.request(t, http.MethodDelete, "/api/tasks/"+id, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.status)
+}
+
+// Откатившийся пакет получает статус ошибки, на которой прервался
+func TestBulkTasksStatus(t *testing.T) {
+	s := newTestServer(t)
+
+	resp := s.request(t, http.MethodPost, "/api/tasks/bulk",
+		`{"operations": [{"op": "create", "task": {"title": "Зарядка"}}, {"op": "done", "id": "100"}]}`, nil)
+	assert.Equal(t, http.StatusNotFound, resp.status)
+	assert.Equal(t, false, resp.json(t)["committed"])
+
+	resp = s.request(t, http.MethodPost, "/api/tasks/bulk",
+		`{"mode": "best_effort", "operations": [{"op": "create", "task": {"title": "Зарядка"}}, {"op": "done", "id": "100"}]}`, nil)
+	assert.Equal(t, http.StatusOK, resp.status)
+	results := resp.json(t)["results"].([]interface{})
+	require.Len(t, results, 2)
+	failed := results[1].(map[string]interface{})
+	assert.Equal(t, "failed", failed["status"])
+	assert.Equal(t, float64(http.StatusNotFound), failed["error"].(map[string]interface{})["status"])
+}
+
+func TestIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	id := s.addTask(t, `{"title": "Зарядка"}`)
+
+	resp := s.request(t, http.MethodGet, "/api/tasks/"+id, "", nil)
+	etag := resp.header.Get("ETag")
+	assert.Equal(t, `"1"`, etag)
+
+	resp = s.request(t, http.MethodPatch, "/api/tasks/"+id, `{"title": "Пробежка"}`, map[string]string{"If-Match": etag})
+	require.Equal(t, http.StatusOK, resp.status, string(resp.body))
+
+	// Задачу уже изменили, старый ETag не подходит
+	resp = s.request(t, http.MethodPatch, "/api/tasks/"+id, `{"title": "Прогулка"}`, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.status)
+	resp = s.request(t, http.MethodDelete, "/api/tasks/"+id, "", map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.status)
+	resp = s.request(t, http.MethodDelete, "/api/tasks/"+id, "", map[string]string{"If-Match": "W/2"})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.status)
+
+	resp = s.request(t, http.MethodGet, "/api/tasks/"+id, "", nil)
+	assert.Equal(t, "Пробежка", resp.json(t)["title"])
+	assert.Equal(t, `"2"`, resp.header.Get("ETag"))
+
+	resp = s.request(t, http.MethodPost, "/api/tasks/"+id+"/done", "", map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusOK, resp.status)
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Server.RequireIfMatch = true
+	})
+	id := s.addTask(t, `{"title": "Зарядка"}`)
+
+	resp := s.request(t, http.MethodDelete, "/api/tasks/"+id, "", nil)
+	assert.Equal(t, http.StatusPreconditionRequired, resp.status)
+
+	resp = s.request(t, http.MethodDelete, "/api/tasks/"+id, "", map[string]string{"If-Match": `"1"`})
+	assert.Equal(t, http.StatusOK, resp.status)
+}
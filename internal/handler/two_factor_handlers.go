@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/Oxygenss/yandex_final_project/internal/handler/middleware"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/service"
+)
+
+type TwoFactorHandler struct {
+	service service.Service
+}
+
+func NewTwoFactorHandler(service service.Service) *TwoFactorHandler {
+	return &TwoFactorHandler{service: service}
+}
+
+func (h *TwoFactorHandler) GetTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.service.GetTwoFactorStatus(r.Context(), middleware.UserID(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Выдает новый ключ TOTP. Двухфакторная аутентификация включается
+// только после подтверждения кодом через /api/2fa/enable
+func (h *TwoFactorHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.service.SetupTwoFactor(r.Context(), middleware.UserID(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *TwoFactorHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	req, ok := readCodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.service.EnableTwoFactor(r.Context(), middleware.UserID(r.Context()), middleware.SessionID(r.Context()), req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *TwoFactorHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	req, ok := readCodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.service.DisableTwoFactor(r.Context(), middleware.UserID(r.Context()), req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	req, ok := readCodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(r.Context(), middleware.UserID(r.Context()), req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func readCodeRequest(w http.ResponseWriter, r *http.Request) (models.TwoFactorCodeRequest, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return models.TwoFactorCodeRequest{}, false
+	}
+
+	var req models.TwoFactorCodeRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+		return models.TwoFactorCodeRequest{}, false
+	}
+
+	if req.Code == "" {
+		writeError(w, r, models.NewValidationError("code", "code is required", nil))
+		return models.TwoFactorCodeRequest{}, false
+	}
+
+	return req, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// Ошибки, общие для сервисов и репозиториев. Конкретные ошибки оборачивают
+// их через %w, а обработчики по ним выбирают HTTP статус
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrValidation = errors.New("validation failed")
+	ErrConflict   = errors.New("conflict")
+	// Версия задачи не совпала с ожидаемой (If-Match)
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// Запрос корректен синтаксически, но не может быть выполнен
+	ErrUnprocessable = errors.New("unprocessable")
+	// Неверные учетные данные
+	ErrUnauthorized = errors.New("unauthorized")
+	// Пользователь известен, но его прав недостаточно
+	ErrForbidden = errors.New("forbidden")
+)
+
+// ValidationError - некорректное значение конкретного поля запроса
+type ValidationError struct {
+	Field   string
+	Message string
+	Err     error
+}
+
+func NewValidationError(field, message string, err error) *ValidationError {
+	return &ValidationError{Field: field, Message: message, Err: err}
+}
+
+func (e *ValidationError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ValidationErrors - несколько некорректных полей в одном запросе
+type ValidationErrors []*ValidationError
+
+// Возвращает nil, если ошибок нет
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrValidation
+}
@@ -1,11 +1,31 @@
 package models
 
+import "time"
+
+// Version увеличивается при каждом изменении задачи. Клиенту она
+// отдается только в заголовке ETag, а приходит в заголовке If-Match.
+// ListID - общий список, в котором лежит задача, 0 - личная задача.
+// CreatedBy и ModifiedBy - логины автора и последнего редактора, их заполняет хранилище
 type Task struct {
-	ID      string `json:"id"`
-	Date    string `json:"date"`
-	Title   string `json:"title"`
-	Comment string `json:"comment"`
-	Repeat  string `json:"repeat"`
+	ID           string `json:"id"`
+	Date         string `json:"date"`
+	Title        string `json:"title"`
+	Comment      string `json:"comment"`
+	Repeat       string `json:"repeat"`
+	ListID       int64  `json:"list_id,omitempty,string"`
+	CreatedBy    string `json:"created_by,omitempty"`
+	ModifiedBy   string `json:"modified_by,omitempty"`
+	CreatedByID  int64  `json:"-"`
+	ModifiedByID int64  `json:"-"`
+	Version      int64  `json:"-"`
+}
+
+// TaskPatch - частичное изменение задачи: nil означает, что поле не меняется
+type TaskPatch struct {
+	Date    *string
+	Title   *string
+	Comment *string
+	Repeat  *string
 }
 
 type GetTasksResponse struct {
@@ -16,14 +36,363 @@ type AddTaskResponse struct {
 	ID int64 `json:"id"`
 }
 
+// Login можно не указывать: тогда вход выполняется в учетную запись
+// администратора, пароль которой задается в конфиге (AUTH_PASSWORD)
+// Code нужен, если у пользователя включена двухфакторная аутентификация:
+// код из приложения-аутентификатора или один из кодов восстановления
 type SignInRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type SignUpRequest struct {
+	Login    string `json:"login"`
 	Password string `json:"password"`
 }
 
+type User struct {
+	ID           int64
+	Login        string
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// Token - токен доступа, RefreshToken - токен для получения новой пары через /api/refresh,
+// ExpiresIn - через сколько секунд истекает токен доступа
 type SignInResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Session - сеанс, начатый входом. Токены обновления сеанса образуют одно семейство:
+// повторное использование уже замененного токена отзывает весь сеанс.
+// Fingerprint - отпечаток пароля пользователя на момент входа,
+// SecondFactor - при входе был проверен код двухфакторной аутентификации
+type Session struct {
+	ID           int64
+	UserID       int64
+	Fingerprint  string
+	CreatedAt    time.Time
+	Revoked      bool
+	SecondFactor bool
+}
+
+// RefreshToken - токен обновления. Как и у токенов API, хранится только хеш значения
+type RefreshToken struct {
+	Hash      string
+	SessionID int64
+	UserID    int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// Identity - учетная запись у провайдера OpenID Connect (issuer и sub из ID-токена),
+// закрепленная за локальным пользователем
+type Identity struct {
+	Issuer    string
+	Subject   string
+	UserID    int64
+	CreatedAt time.Time
+}
+
+// TwoFactor - настройки TOTP (RFC 6238) пользователя. Пока Enabled false, подключение
+// не завершено и код при входе не требуется. LastStep - последний принятый
+// 30-секундный интервал: код из него и более ранних повторно не принимается
+type TwoFactor struct {
+	UserID    int64
+	Secret    string
+	Enabled   bool
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+// Secret - ключ в base32 для ручного ввода, URI - адрес otpauth://,
+// который приложения-аутентификаторы считывают из QR-кода
+type TwoFactorSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// Коды восстановления показываются один раз, хранятся только их хеши
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorStatusResponse struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// Права персональных токенов API. Сессия, полученная через вход, имеет все права
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+)
+
+// APIToken - персональный токен для автоматизаций. Значение токена
+// показывается один раз при создании, а хранится только его хеш.
+// ExpiresAt nil - бессрочный токен
+type APIToken struct {
+	ID        string     `json:"id"`
+	UserID    int64      `json:"-"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Hash      string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateAPITokenResponse struct {
 	Token string `json:"token"`
+	APIToken
+}
+
+type GetAPITokensResponse struct {
+	Tokens []APIToken `json:"tokens"`
+}
+
+// Роли участников общего списка: viewer читает задачи, editor еще и изменяет их,
+// owner еще и управляет списком и его участниками
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// List - общий список задач. Role и Pending относятся к пользователю, для которого
+// список получен: его роль и то, что он еще не принял приглашение
+type List struct {
+	ID        int64     `json:"id,string"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"`
+	Pending   bool      `json:"pending,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListMember - участник общего списка. Пока Pending true, это приглашение:
+// список и его задачи станут доступны пользователю, когда он его примет
+type ListMember struct {
+	ListID    int64     `json:"-"`
+	UserID    int64     `json:"user_id,string"`
+	Login     string    `json:"login"`
+	Role      string    `json:"role"`
+	Pending   bool      `json:"pending,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ListRequest struct {
+	Name string `json:"name"`
+}
+
+// Приглашение в список по логину пользователя
+type InviteRequest struct {
+	Login string `json:"login"`
+	Role  string `json:"role"`
+}
+
+type MemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type GetListsResponse struct {
+	Lists []List `json:"lists"`
+}
+
+type GetListResponse struct {
+	List
+	Members []ListMember `json:"members"`
+}
+
+// Действия, которые попадают в журнал аудита
+const (
+	AuditSignIn       = "signin"
+	AuditTokenCreate  = "token.create"
+	AuditTokenRefresh = "token.refresh"
+	AuditTokenRevoke  = "token.revoke"
+	AuditLogout       = "session.logout"
+	AuditTwoFactorOff = "2fa.disable"
+	AuditTaskCreate   = "task.create"
+	AuditTaskUpdate   = "task.update"
+	AuditTaskDelete   = "task.delete"
+	AuditTaskDone     = "task.done"
+	AuditTaskUndo     = "task.undo"
+)
+
+// AuditEvent - запись журнала аудита. UserID 0 - пользователь неизвестен, например при
+// входе с несуществующим логином, тогда Login - логин, с которым пытались войти.
+// Target - id задачи, токена или сеанса, к которому относится действие
+type AuditEvent struct {
+	ID        int64     `json:"id,string"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"user_id,omitempty,string"`
+	Login     string    `json:"login,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	Success   bool      `json:"success"`
+	Details   string    `json:"details,omitempty"`
+}
+
+// AuditFilter отбирает записи журнала аудита. Пустые поля не ограничивают выборку.
+// Записи отдаются от новых к старым, BeforeID - продолжить после записи с этим id
+type AuditFilter struct {
+	UserID   int64
+	Action   string
+	IP       string
+	Success  *bool
+	Since    time.Time
+	Until    time.Time
+	BeforeID int64
+	Limit    int
+}
+
+type GetAuditEventsResponse struct {
+	Events []AuditEvent `json:"events"`
 }
 
+// Старый формат ответа с ошибкой, оставлен для режима совместимости
 type ErrorResponse struct {
 	Error string `json:"error"`
-}
\ No newline at end of file
+}
+
+// Problem - ответ с ошибкой в формате RFC 7807 (application/problem+json)
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Виды операций, которые записываются в журнал отмены
+const (
+	OperationAdd    = "add"
+	OperationEdit   = "edit"
+	OperationDelete = "delete"
+	OperationDone   = "done"
+)
+
+// Operation - запись журнала отмены. Snapshot хранит состояние задачи
+// до выполнения операции (для add - созданную задачу), Version - версию задачи
+// после операции: отмена не затирает изменения, сделанные позже.
+// Version 0 - задачи после операции нет или запись сделана до появления версии
+type Operation struct {
+	ID        int64
+	Kind      string
+	TaskID    string
+	Snapshot  Task
+	Version   int64
+	CreatedAt time.Time
+	Undone    bool
+}
+
+// Revision - запись истории изменений задачи через EditTask:
+// Before - поля до изменения, After - после
+type Revision struct {
+	TaskID    string
+	Revision  int64
+	Before    Task
+	After     Task
+	CreatedAt time.Time
+}
+
+type FieldChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type RevisionDiff struct {
+	Revision  int64                  `json:"revision"`
+	CreatedAt string                 `json:"created_at"`
+	Changes   map[string]FieldChange `json:"changes"`
+}
+
+type GetRevisionsResponse struct {
+	Revisions []RevisionDiff `json:"revisions"`
+}
+
+// Режимы выполнения пакетных операций
+const (
+	BulkAtomic     = "atomic"
+	BulkBestEffort = "best_effort"
+)
+
+// Виды пакетных операций
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+	BulkDone   = "done"
+	BulkMove   = "move"
+)
+
+// Итог выполнения отдельной операции пакета
+const (
+	BulkStatusOK         = "ok"
+	BulkStatusFailed     = "failed"
+	BulkStatusRolledBack = "rolled_back"
+	BulkStatusSkipped    = "skipped"
+)
+
+type BulkRequest struct {
+	Mode       string          `json:"mode"`
+	Operations []BulkOperation `json:"operations"`
+}
+
+// BulkOperation - одна операция пакета. Task нужна для create и update,
+// ID - для delete, done и move, Days - на сколько дней сдвинуть дату для move
+type BulkOperation struct {
+	Op   string `json:"op"`
+	ID   string `json:"id,omitempty"`
+	Task *Task  `json:"task,omitempty"`
+	Days int    `json:"days,omitempty"`
+}
+
+type BulkResult struct {
+	Index       int      `json:"index"`
+	Op          string   `json:"op"`
+	ID          string   `json:"id,omitempty"`
+	OperationID int64    `json:"operation_id,omitempty"`
+	Status      string   `json:"status"`
+	Error       *Problem `json:"error,omitempty"`
+	Err         error    `json:"-"`
+}
+
+type BulkResponse struct {
+	Committed bool         `json:"committed"`
+	Results   []BulkResult `json:"results"`
+}
+
+// IdempotencyRecord - сохраненный результат запроса с заголовком Idempotency-Key.
+// Status 0 означает, что запрос с этим ключом еще выполняется
+type IdempotencyRecord struct {
+	Key         string
+	Scope       string
+	RequestHash string
+	Status      int
+	Headers     map[string]string
+	Body        []byte
+	CreatedAt   time.Time
+}
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddAPIToken(ctx context.Context, userID int64, token models.APIToken) (int64, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	for _, existing := range st.apiTokens {
+		if existing.Hash == token.Hash {
+			return 0, fmt.Errorf("failed to insert api token: %w", models.ErrConflict)
+		}
+	}
+
+	st.lastAPITokenID++
+	token.ID = strconv.FormatInt(st.lastAPITokenID, 10)
+	token.UserID = userID
+	token.Scopes = append([]string(nil), token.Scopes...)
+	token.CreatedAt = truncate(token.CreatedAt)
+	if token.ExpiresAt != nil {
+		expiresAt := truncate(*token.ExpiresAt)
+		token.ExpiresAt = &expiresAt
+	}
+	st.apiTokens[st.lastAPITokenID] = token
+
+	return st.lastAPITokenID, nil
+}
+
+func (r *Repository) GetAPITokens(ctx context.Context, userID int64) ([]models.APIToken, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ids := make([]int64, 0, len(st.apiTokens))
+	for id, token := range st.apiTokens {
+		if token.UserID == userID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	tokens := make([]models.APIToken, 0, len(ids))
+	for _, id := range ids {
+		tokens = append(tokens, copyAPIToken(st.apiTokens[id]))
+	}
+
+	return tokens, nil
+}
+
+func (r *Repository) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.APIToken{}, err
+	}
+	defer unlock()
+
+	for _, token := range st.apiTokens {
+		if token.Hash == hash {
+			return copyAPIToken(token), nil
+		}
+	}
+
+	return models.APIToken{}, fmt.Errorf("api token %w", models.ErrNotFound)
+}
+
+func (r *Repository) DeleteAPIToken(ctx context.Context, userID int64, id string) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tokenID, err := strconv.ParseInt(id, 10, 64)
+	token, ok := st.apiTokens[tokenID]
+	if err != nil || !ok || token.UserID != userID {
+		return fmt.Errorf("api token with id %s %w", id, models.ErrNotFound)
+	}
+
+	delete(st.apiTokens, tokenID)
+
+	return nil
+}
+
+// Вызывающий код не должен менять сохраненные права через общий срез
+func copyAPIToken(token models.APIToken) models.APIToken {
+	token.Scopes = append([]string(nil), token.Scopes...)
+	return token
+}
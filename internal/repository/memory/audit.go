@@ -0,0 +1,61 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddAuditEvent(ctx context.Context, event models.AuditEvent) (int64, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	st.lastAuditEventID++
+	event.ID = st.lastAuditEventID
+	event.CreatedAt = truncate(event.CreatedAt)
+	st.auditEvents = append(st.auditEvents, event)
+
+	return event.ID, nil
+}
+
+func (r *Repository) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	since := truncate(filter.Since)
+	until := truncate(filter.Until)
+
+	// События добавляются по возрастанию id, поэтому обход с конца дает порядок от новых к старым
+	events := []models.AuditEvent{}
+	for i := len(st.auditEvents) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+
+		event := st.auditEvents[i]
+		switch {
+		case filter.UserID != 0 && event.UserID != filter.UserID,
+			filter.Action != "" && event.Action != filter.Action,
+			filter.IP != "" && event.IP != filter.IP,
+			filter.Success != nil && event.Success != *filter.Success,
+			!filter.Since.IsZero() && event.CreatedAt.Before(since),
+			!filter.Until.IsZero() && !event.CreatedAt.Before(until),
+			filter.BeforeID != 0 && event.ID >= filter.BeforeID:
+			continue
+		}
+
+		// Как и COALESCE в sqlite, логин пользователя важнее сохраненного в записи
+		if user, ok := st.users[event.UserID]; ok {
+			event.Login = user.Login
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
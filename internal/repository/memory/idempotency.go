@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) ReserveIdempotencyKey(ctx context.Context, userID int64, rec models.IdempotencyRecord, staleBefore time.Time) (models.IdempotencyRecord, bool, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+	defer unlock()
+
+	key := idempotencyKey{userID: userID, key: rec.Key, scope: rec.Scope}
+	existing, ok := st.idempotency[key]
+	abandoned := ok && existing.Status == 0 && existing.RequestHash == rec.RequestHash &&
+		existing.CreatedAt.Unix() < staleBefore.Unix()
+	if ok && !abandoned {
+		return copyRecord(existing), false, nil
+	}
+
+	rec.Status = 0
+	rec.Headers = map[string]string{}
+	rec.Body = nil
+	rec.CreatedAt = truncate(rec.CreatedAt)
+	st.idempotency[key] = rec
+
+	return copyRecord(rec), true, nil
+}
+
+func (r *Repository) CompleteIdempotencyKey(ctx context.Context, userID int64, rec models.IdempotencyRecord) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := idempotencyKey{userID: userID, key: rec.Key, scope: rec.Scope}
+	existing, ok := st.idempotency[key]
+	if !ok {
+		return nil
+	}
+
+	existing.Status = rec.Status
+	existing.Headers = rec.Headers
+	existing.Body = rec.Body
+	st.idempotency[key] = copyRecord(existing)
+
+	return nil
+}
+
+func (r *Repository) DeleteIdempotencyKey(ctx context.Context, userID int64, key, scope string) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(st.idempotency, idempotencyKey{userID: userID, key: key, scope: scope})
+
+	return nil
+}
+
+func (r *Repository) DeleteIdempotencyKeysBefore(ctx context.Context, before time.Time) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for key, rec := range st.idempotency {
+		if rec.CreatedAt.Unix() < before.Unix() {
+			delete(st.idempotency, key)
+		}
+	}
+
+	return nil
+}
+
+// Заголовки и тело копируются, чтобы вызывающий код не мог изменить сохраненную запись
+func copyRecord(rec models.IdempotencyRecord) models.IdempotencyRecord {
+	headers := make(map[string]string, len(rec.Headers))
+	for name, value := range rec.Headers {
+		headers[name] = value
+	}
+	rec.Headers = headers
+
+	if rec.Body != nil {
+		rec.Body = append([]byte(nil), rec.Body...)
+	}
+
+	return rec
+}
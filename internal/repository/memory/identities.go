@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) GetUserByIdentity(ctx context.Context, issuer, subject string) (models.User, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer unlock()
+
+	identity, ok := st.identities[identityKey{issuer: issuer, subject: subject}]
+	if !ok {
+		return models.User{}, fmt.Errorf("user with identity %s %w", subject, models.ErrNotFound)
+	}
+
+	user, ok := st.users[identity.UserID]
+	if !ok {
+		return models.User{}, fmt.Errorf("user with identity %s %w", subject, models.ErrNotFound)
+	}
+
+	return user, nil
+}
+
+func (r *Repository) AddIdentity(ctx context.Context, userID int64, identity models.Identity) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := identityKey{issuer: identity.Issuer, subject: identity.Subject}
+	if _, ok := st.identities[key]; ok {
+		return fmt.Errorf("identity %s is already linked: %w", identity.Subject, models.ErrConflict)
+	}
+
+	identity.UserID = userID
+	identity.CreatedAt = truncate(identity.CreatedAt)
+	st.identities[key] = identity
+
+	return nil
+}
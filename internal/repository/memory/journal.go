@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddOperation(ctx context.Context, userID int64, op models.Operation) (int64, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	st.lastOperationID++
+	op.ID = st.lastOperationID
+	op.Snapshot.Version = 0
+	op.CreatedAt = truncate(op.CreatedAt)
+	op.Undone = false
+	st.operations[op.ID] = ownedOperation{userID: userID, op: op}
+
+	return op.ID, nil
+}
+
+func (r *Repository) GetOperation(ctx context.Context, userID int64, id string) (models.Operation, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.Operation{}, err
+	}
+	defer unlock()
+
+	opID, err := strconv.ParseInt(id, 10, 64)
+	stored, ok := st.operations[opID]
+	if err != nil || !ok || stored.userID != userID {
+		return models.Operation{}, fmt.Errorf("operation with id %s %w", id, models.ErrNotFound)
+	}
+
+	return stored.op, nil
+}
+
+func (r *Repository) MarkOperationUndone(ctx context.Context, userID int64, id string) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	opID, err := strconv.ParseInt(id, 10, 64)
+	stored, ok := st.operations[opID]
+	if err != nil || !ok || stored.userID != userID || stored.op.Undone {
+		return fmt.Errorf("operation with id %s is already undone: %w", id, models.ErrConflict)
+	}
+
+	stored.op.Undone = true
+	st.operations[opID] = stored
+
+	return nil
+}
+
+func (r *Repository) DeleteOperationsBefore(ctx context.Context, before time.Time) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for id, stored := range st.operations {
+		if stored.op.CreatedAt.Unix() < before.Unix() {
+			delete(st.operations, id)
+		}
+	}
+
+	return nil
+}
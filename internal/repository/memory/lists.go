@@ -0,0 +1,211 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddList(ctx context.Context, list models.List) (int64, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	st.lastListID++
+	st.lists[st.lastListID] = models.List{
+		ID:        st.lastListID,
+		Name:      list.Name,
+		CreatedAt: truncate(list.CreatedAt),
+	}
+
+	return st.lastListID, nil
+}
+
+func (r *Repository) GetList(ctx context.Context, id int64) (models.List, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.List{}, err
+	}
+	defer unlock()
+
+	list, ok := st.lists[id]
+	if !ok {
+		return models.List{}, fmt.Errorf("list with id %d %w", id, models.ErrNotFound)
+	}
+
+	return list, nil
+}
+
+func (r *Repository) GetLists(ctx context.Context, userID int64) ([]models.List, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	lists := []models.List{}
+	for key, member := range st.listMembers {
+		if key.userID != userID {
+			continue
+		}
+
+		list := st.lists[key.listID]
+		list.Role = member.Role
+		list.Pending = member.Pending
+		lists = append(lists, list)
+	}
+
+	sort.Slice(lists, func(i, j int) bool { return lists[i].ID < lists[j].ID })
+
+	return lists, nil
+}
+
+func (r *Repository) RenameList(ctx context.Context, id int64, name string) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	list, ok := st.lists[id]
+	if !ok {
+		return fmt.Errorf("list with id %d %w", id, models.ErrNotFound)
+	}
+
+	list.Name = name
+	st.lists[id] = list
+
+	return nil
+}
+
+func (r *Repository) DeleteList(ctx context.Context, id int64) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, ok := st.lists[id]; !ok {
+		return fmt.Errorf("list with id %d %w", id, models.ErrNotFound)
+	}
+
+	for taskID, stored := range st.tasks {
+		if stored.task.ListID == id {
+			delete(st.tasks, taskID)
+		}
+	}
+	for key := range st.listMembers {
+		if key.listID == id {
+			delete(st.listMembers, key)
+		}
+	}
+	delete(st.lists, id)
+
+	return nil
+}
+
+func (r *Repository) AddListMember(ctx context.Context, member models.ListMember) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := listMemberKey{listID: member.ListID, userID: member.UserID}
+	if _, exists := st.listMembers[key]; exists {
+		return fmt.Errorf("user %d is already a member of list %d: %w", member.UserID, member.ListID, models.ErrConflict)
+	}
+
+	member.Login = ""
+	member.CreatedAt = truncate(member.CreatedAt)
+	st.listMembers[key] = member
+
+	return nil
+}
+
+func (r *Repository) GetListMember(ctx context.Context, listID, userID int64) (models.ListMember, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.ListMember{}, err
+	}
+	defer unlock()
+
+	member, ok := st.listMembers[listMemberKey{listID: listID, userID: userID}]
+	if !ok {
+		return models.ListMember{}, fmt.Errorf("member %d of list %d %w", userID, listID, models.ErrNotFound)
+	}
+
+	return withLogin(st, member), nil
+}
+
+func (r *Repository) GetListMembers(ctx context.Context, listID int64) ([]models.ListMember, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	members := []models.ListMember{}
+	for key, member := range st.listMembers {
+		if key.listID == listID {
+			members = append(members, withLogin(st, member))
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.UserID < b.UserID
+	})
+
+	return members, nil
+}
+
+func (r *Repository) UpdateListMember(ctx context.Context, member models.ListMember) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := listMemberKey{listID: member.ListID, userID: member.UserID}
+	current, ok := st.listMembers[key]
+	if !ok {
+		return fmt.Errorf("member %d of list %d %w", member.UserID, member.ListID, models.ErrNotFound)
+	}
+
+	current.Role = member.Role
+	current.Pending = member.Pending
+	st.listMembers[key] = current
+
+	return nil
+}
+
+func (r *Repository) DeleteListMember(ctx context.Context, listID, userID int64) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := listMemberKey{listID: listID, userID: userID}
+	if _, ok := st.listMembers[key]; !ok {
+		return fmt.Errorf("member %d of list %d %w", userID, listID, models.ErrNotFound)
+	}
+
+	delete(st.listMembers, key)
+
+	return nil
+}
+
+// Как и JOIN с users в sqlite, добавляет к участнику его логин
+func withLogin(st *state, member models.ListMember) models.ListMember {
+	member.Login = st.users[member.UserID].Login
+	return member
+}
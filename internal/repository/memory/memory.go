@@ -0,0 +1,206 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+// Данные хранилища. Копируется целиком при начале транзакции и точки сохранения,
+// чтобы откат мог вернуть прежнее состояние
+type state struct {
+	users      map[int64]models.User
+	lastUserID int64
+
+	identities map[identityKey]models.Identity
+
+	tasks      map[int64]ownedTask
+	lastTaskID int64
+
+	operations      map[int64]ownedOperation
+	lastOperationID int64
+
+	revisions map[revisionsKey][]models.Revision
+
+	idempotency map[idempotencyKey]models.IdempotencyRecord
+
+	apiTokens      map[int64]models.APIToken
+	lastAPITokenID int64
+
+	sessions      map[int64]models.Session
+	lastSessionID int64
+
+	refreshTokens map[string]models.RefreshToken
+
+	lists       map[int64]models.List
+	lastListID  int64
+	listMembers map[listMemberKey]models.ListMember
+
+	// Журнал аудита только пополняется, поэтому копия при клонировании не нужна:
+	// append в транзакции не затрагивает видимую часть исходного среза
+	auditEvents      []models.AuditEvent
+	lastAuditEventID int64
+
+	twoFactor map[int64]models.TwoFactor
+	// Значение - код уже использован
+	recoveryCodes map[recoveryCodeKey]bool
+}
+
+// Задачи и записи журнала хранятся вместе с id владельца. Владелец задачи - ее автор,
+// modifiedBy - пользователь, последним изменивший задачу
+type ownedTask struct {
+	userID     int64
+	modifiedBy int64
+	task       models.Task
+}
+
+type ownedOperation struct {
+	userID int64
+	op     models.Operation
+}
+
+type revisionsKey struct {
+	userID int64
+	taskID string
+}
+
+type idempotencyKey struct {
+	userID     int64
+	key, scope string
+}
+
+type identityKey struct {
+	issuer, subject string
+}
+
+type listMemberKey struct {
+	listID, userID int64
+}
+
+type recoveryCodeKey struct {
+	userID int64
+	hash   string
+}
+
+// Как и миграции базы, создает учетную запись администратора с id 1 без пароля
+func newState() *state {
+	return &state{
+		users:         map[int64]models.User{1: {ID: 1, Login: "admin"}},
+		lastUserID:    1,
+		identities:    map[identityKey]models.Identity{},
+		tasks:         map[int64]ownedTask{},
+		operations:    map[int64]ownedOperation{},
+		revisions:     map[revisionsKey][]models.Revision{},
+		idempotency:   map[idempotencyKey]models.IdempotencyRecord{},
+		apiTokens:     map[int64]models.APIToken{},
+		sessions:      map[int64]models.Session{},
+		refreshTokens: map[string]models.RefreshToken{},
+		lists:         map[int64]models.List{},
+		listMembers:   map[listMemberKey]models.ListMember{},
+		twoFactor:     map[int64]models.TwoFactor{},
+		recoveryCodes: map[recoveryCodeKey]bool{},
+	}
+}
+
+func (s *state) clone() *state {
+	c := &state{
+		users:            make(map[int64]models.User, len(s.users)),
+		lastUserID:       s.lastUserID,
+		identities:       make(map[identityKey]models.Identity, len(s.identities)),
+		tasks:            make(map[int64]ownedTask, len(s.tasks)),
+		lastTaskID:       s.lastTaskID,
+		operations:       make(map[int64]ownedOperation, len(s.operations)),
+		lastOperationID:  s.lastOperationID,
+		revisions:        make(map[revisionsKey][]models.Revision, len(s.revisions)),
+		idempotency:      make(map[idempotencyKey]models.IdempotencyRecord, len(s.idempotency)),
+		apiTokens:        make(map[int64]models.APIToken, len(s.apiTokens)),
+		lastAPITokenID:   s.lastAPITokenID,
+		sessions:         make(map[int64]models.Session, len(s.sessions)),
+		lastSessionID:    s.lastSessionID,
+		refreshTokens:    make(map[string]models.RefreshToken, len(s.refreshTokens)),
+		lists:            make(map[int64]models.List, len(s.lists)),
+		lastListID:       s.lastListID,
+		listMembers:      make(map[listMemberKey]models.ListMember, len(s.listMembers)),
+		auditEvents:      s.auditEvents[:len(s.auditEvents):len(s.auditEvents)],
+		lastAuditEventID: s.lastAuditEventID,
+		twoFactor:        make(map[int64]models.TwoFactor, len(s.twoFactor)),
+		recoveryCodes:    make(map[recoveryCodeKey]bool, len(s.recoveryCodes)),
+	}
+
+	for id, user := range s.users {
+		c.users[id] = user
+	}
+	for key, identity := range s.identities {
+		c.identities[key] = identity
+	}
+	for id, task := range s.tasks {
+		c.tasks[id] = task
+	}
+	for id, op := range s.operations {
+		c.operations[id] = op
+	}
+	for key, revisions := range s.revisions {
+		c.revisions[key] = append([]models.Revision(nil), revisions...)
+	}
+	for key, rec := range s.idempotency {
+		c.idempotency[key] = rec
+	}
+	for id, token := range s.apiTokens {
+		c.apiTokens[id] = token
+	}
+	for id, session := range s.sessions {
+		c.sessions[id] = session
+	}
+	for hash, token := range s.refreshTokens {
+		c.refreshTokens[hash] = token
+	}
+	for id, list := range s.lists {
+		c.lists[id] = list
+	}
+	for key, member := range s.listMembers {
+		c.listMembers[key] = member
+	}
+	for id, tf := range s.twoFactor {
+		c.twoFactor[id] = tf
+	}
+	for key, used := range s.recoveryCodes {
+		c.recoveryCodes[key] = used
+	}
+
+	return c
+}
+
+type store struct {
+	mu    sync.Mutex
+	state *state
+}
+
+// Хранилище в памяти с тем же поведением, что и у sqlite: те же ошибки,
+// тот же порядок результатов поиска, транзакции с откатом и точками сохранения.
+// Данные теряются при остановке приложения, поэтому оно подходит только для тестов и демонстраций
+type Repository struct {
+	store *store
+	// Глубина вложенности транзакций: 0 - вне транзакции,
+	// больше 1 - внутри точки сохранения
+	depth int
+}
+
+func New() *Repository {
+	return &Repository{store: &store{state: newState()}}
+}
+
+// Вне транзакции каждая операция берет блокировку хранилища сама,
+// внутри транзакции блокировку уже держит InTx
+func (r *Repository) lock(ctx context.Context) (*state, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if r.depth > 0 {
+		return r.store.state, func() {}, nil
+	}
+
+	r.store.mu.Lock()
+	return r.store.state, r.store.mu.Unlock, nil
+}
@@ -0,0 +1,227 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddTask(ctx context.Context, userID int64, task models.Task) (int64, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	st.lastTaskID++
+	task.ID = strconv.FormatInt(st.lastTaskID, 10)
+	task.Version = 1
+	st.tasks[st.lastTaskID] = ownedTask{userID: userID, modifiedBy: userID, task: task}
+
+	return st.lastTaskID, nil
+}
+
+// Восстанавливает удаленную задачу с ее прежним id
+func (r *Repository) RestoreTask(ctx context.Context, userID int64, task models.Task) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	id, ok := parseID(task.ID)
+	if !ok {
+		return models.NewValidationError("id", "failed to parse id", nil)
+	}
+
+	if _, exists := st.tasks[id]; exists {
+		return fmt.Errorf("task with id %s already exists: %w", task.ID, models.ErrConflict)
+	}
+
+	author := userID
+	if task.CreatedByID != 0 {
+		author = task.CreatedByID
+	}
+
+	task.ID = strconv.FormatInt(id, 10)
+	task.Version = 1
+	st.tasks[id] = ownedTask{userID: author, modifiedBy: userID, task: task}
+	if id > st.lastTaskID {
+		st.lastTaskID = id
+	}
+
+	return nil
+}
+
+func (r *Repository) GetTaskByID(ctx context.Context, userID int64, id string) (models.Task, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.Task{}, err
+	}
+	defer unlock()
+
+	return getTask(st, userID, id)
+}
+
+// Недоступная пользователю задача для него не существует
+func getTask(st *state, userID int64, id string) (models.Task, error) {
+	taskID, ok := parseID(id)
+	if !ok {
+		return models.Task{}, fmt.Errorf("task with id %s %w", id, models.ErrNotFound)
+	}
+
+	stored, ok := st.tasks[taskID]
+	if !ok || !visible(st, userID, stored) {
+		return models.Task{}, fmt.Errorf("task with id %s %w", id, models.ErrNotFound)
+	}
+
+	return withAuthors(st, stored), nil
+}
+
+// Личная задача пользователя или задача списка, приглашение в который он принял
+func visible(st *state, userID int64, stored ownedTask) bool {
+	if stored.task.ListID == 0 {
+		return stored.userID == userID
+	}
+
+	member, ok := st.listMembers[listMemberKey{listID: stored.task.ListID, userID: userID}]
+	return ok && !member.Pending
+}
+
+// Как и JOIN с users в sqlite, добавляет к задаче логины автора и последнего редактора
+func withAuthors(st *state, stored ownedTask) models.Task {
+	task := stored.task
+	task.CreatedByID = stored.userID
+	task.CreatedBy = st.users[stored.userID].Login
+	task.ModifiedByID = stored.modifiedBy
+	task.ModifiedBy = st.users[stored.modifiedBy].Login
+	return task
+}
+
+func (r *Repository) GetTasks(ctx context.Context, userID, listID int64) ([]models.Task, error) {
+	return r.findTasks(ctx, userID, listID, func(models.Task) bool { return true }, false)
+}
+
+func (r *Repository) SearchTasksByString(ctx context.Context, userID, listID int64, search string) ([]models.Task, error) {
+	search = strings.ToLower(search)
+
+	return r.findTasks(ctx, userID, listID, func(task models.Task) bool {
+		return strings.Contains(strings.ToLower(task.Title), search) ||
+			strings.Contains(strings.ToLower(task.Comment), search)
+	}, true)
+}
+
+func (r *Repository) SearchTasksByDate(ctx context.Context, userID, listID int64, date string) ([]models.Task, error) {
+	return r.findTasks(ctx, userID, listID, func(task models.Task) bool { return task.Date == date }, false)
+}
+
+// Возвращает подходящие задачи списка listID (0 - личные задачи пользователя)
+// в порядке id, а если byDate - в порядке даты
+func (r *Repository) findTasks(ctx context.Context, userID, listID int64, match func(models.Task) bool, byDate bool) ([]models.Task, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ids := make([]int64, 0, len(st.tasks))
+	for id, stored := range st.tasks {
+		if stored.task.ListID == listID && visible(st, userID, stored) && match(stored.task) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := st.tasks[ids[i]].task, st.tasks[ids[j]].task
+		if byDate && a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		return ids[i] < ids[j]
+	})
+
+	tasks := make([]models.Task, 0, len(ids))
+	for _, id := range ids {
+		tasks = append(tasks, withAuthors(st, st.tasks[id]))
+	}
+
+	return tasks, nil
+}
+
+// Если task.Version не 0, задача изменяется, только если ее версия совпадает
+func (r *Repository) EditTask(ctx context.Context, userID int64, task models.Task) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := getTask(st, userID, task.ID)
+	if err != nil {
+		return err
+	}
+
+	err = checkVersion(current, task.Version)
+	if err != nil {
+		return err
+	}
+
+	id, _ := parseID(current.ID)
+	stored := st.tasks[id]
+
+	stored.task.Date = task.Date
+	stored.task.Title = task.Title
+	stored.task.Comment = task.Comment
+	stored.task.Repeat = task.Repeat
+	stored.task.Version++
+	stored.modifiedBy = userID
+	st.tasks[id] = stored
+
+	return nil
+}
+
+// Если version не 0, задача удаляется, только если ее версия совпадает
+func (r *Repository) DeleteByID(ctx context.Context, userID int64, id string, version int64) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := getTask(st, userID, id)
+	if err != nil {
+		return err
+	}
+
+	err = checkVersion(current, version)
+	if err != nil {
+		return err
+	}
+
+	taskID, _ := parseID(id)
+	delete(st.tasks, taskID)
+
+	return nil
+}
+
+func checkVersion(task models.Task, version int64) error {
+	if version != 0 && task.Version != version {
+		return fmt.Errorf("task with id %s has version %d, expected %d: %w",
+			task.ID, task.Version, version, models.ErrPreconditionFailed)
+	}
+	return nil
+}
+
+func parseID(id string) (int64, bool) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	return n, err == nil
+}
+
+// SQLite хранит время с точностью до секунды
+func truncate(t time.Time) time.Time {
+	return time.Unix(t.Unix(), 0)
+}
@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+// Номер ревизии считается отдельно для каждой задачи, начиная с 1
+func (r *Repository) AddRevision(ctx context.Context, userID int64, rev models.Revision) (int64, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	key := revisionsKey{userID: userID, taskID: rev.TaskID}
+	revisions := st.revisions[key]
+
+	rev.Revision = int64(len(revisions)) + 1
+	rev.Before.Version = 0
+	rev.After.Version = 0
+	rev.CreatedAt = truncate(rev.CreatedAt)
+	st.revisions[key] = append(revisions, rev)
+
+	return rev.Revision, nil
+}
+
+func (r *Repository) GetRevisions(ctx context.Context, userID int64, taskID string) ([]models.Revision, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return append([]models.Revision{}, st.revisions[revisionsKey{userID: userID, taskID: taskID}]...), nil
+}
+
+func (r *Repository) GetRevision(ctx context.Context, userID int64, taskID string, revision int64) (models.Revision, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.Revision{}, err
+	}
+	defer unlock()
+
+	revisions := st.revisions[revisionsKey{userID: userID, taskID: taskID}]
+	if revision < 1 || revision > int64(len(revisions)) {
+		return models.Revision{}, fmt.Errorf("revision %d of task %s %w", revision, taskID, models.ErrNotFound)
+	}
+
+	return revisions[revision-1], nil
+}
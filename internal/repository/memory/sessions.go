@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddSession(ctx context.Context, userID int64, session models.Session) (int64, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	st.lastSessionID++
+	session.ID = st.lastSessionID
+	session.UserID = userID
+	session.CreatedAt = truncate(session.CreatedAt)
+	session.Revoked = false
+	st.sessions[session.ID] = session
+
+	return session.ID, nil
+}
+
+func (r *Repository) GetSession(ctx context.Context, userID int64, id int64) (models.Session, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.Session{}, err
+	}
+	defer unlock()
+
+	session, ok := st.sessions[id]
+	if !ok || session.UserID != userID {
+		return models.Session{}, fmt.Errorf("session with id %d %w", id, models.ErrNotFound)
+	}
+
+	return session, nil
+}
+
+func (r *Repository) RevokeSession(ctx context.Context, userID int64, id int64) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	session, ok := st.sessions[id]
+	if !ok || session.UserID != userID {
+		return fmt.Errorf("session with id %d %w", id, models.ErrNotFound)
+	}
+
+	session.Revoked = true
+	st.sessions[id] = session
+
+	return nil
+}
+
+func (r *Repository) AddRefreshToken(ctx context.Context, userID int64, token models.RefreshToken) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, ok := st.refreshTokens[token.Hash]; ok {
+		return fmt.Errorf("failed to insert refresh token: %w", models.ErrConflict)
+	}
+
+	token.UserID = userID
+	token.CreatedAt = truncate(token.CreatedAt)
+	token.ExpiresAt = truncate(token.ExpiresAt)
+	token.Used = false
+	st.refreshTokens[token.Hash] = token
+
+	return nil
+}
+
+func (r *Repository) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+	defer unlock()
+
+	token, ok := st.refreshTokens[hash]
+	if !ok {
+		return models.RefreshToken{}, fmt.Errorf("refresh token %w", models.ErrNotFound)
+	}
+
+	return token, nil
+}
+
+func (r *Repository) UseRefreshToken(ctx context.Context, hash string) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	token, ok := st.refreshTokens[hash]
+	if !ok || token.Used {
+		return fmt.Errorf("refresh token is already used: %w", models.ErrConflict)
+	}
+
+	token.Used = true
+	st.refreshTokens[hash] = token
+
+	return nil
+}
+
+func (r *Repository) DeleteRefreshTokensBefore(ctx context.Context, before time.Time) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for hash, token := range st.refreshTokens {
+		if token.ExpiresAt.Unix() < before.Unix() {
+			delete(st.refreshTokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) CompleteSecondFactor(ctx context.Context, userID int64, id int64) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	session, ok := st.sessions[id]
+	if !ok || session.UserID != userID {
+		return fmt.Errorf("session with id %d %w", id, models.ErrNotFound)
+	}
+
+	session.SecondFactor = true
+	st.sessions[id] = session
+
+	return nil
+}
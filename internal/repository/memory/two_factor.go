@@ -0,0 +1,127 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) SaveTwoFactor(ctx context.Context, userID int64, tf models.TwoFactor) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tf.UserID = userID
+	tf.CreatedAt = truncate(tf.CreatedAt)
+	st.twoFactor[userID] = tf
+
+	return nil
+}
+
+func (r *Repository) GetTwoFactor(ctx context.Context, userID int64) (models.TwoFactor, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.TwoFactor{}, err
+	}
+	defer unlock()
+
+	tf, ok := st.twoFactor[userID]
+	if !ok {
+		return models.TwoFactor{}, fmt.Errorf("two-factor settings %w", models.ErrNotFound)
+	}
+
+	return tf, nil
+}
+
+func (r *Repository) DeleteTwoFactor(ctx context.Context, userID int64) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(st.twoFactor, userID)
+
+	return nil
+}
+
+func (r *Repository) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tf, ok := st.twoFactor[userID]
+	if !ok || tf.LastStep >= step {
+		return fmt.Errorf("two-factor code is already used: %w", models.ErrConflict)
+	}
+
+	tf.LastStep = step
+	st.twoFactor[userID] = tf
+
+	return nil
+}
+
+func (r *Repository) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string, createdAt time.Time) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for key := range st.recoveryCodes {
+		if key.userID == userID {
+			delete(st.recoveryCodes, key)
+		}
+	}
+
+	for _, hash := range hashes {
+		key := recoveryCodeKey{userID: userID, hash: hash}
+		if _, ok := st.recoveryCodes[key]; ok {
+			return fmt.Errorf("failed to insert recovery code: %w", models.ErrConflict)
+		}
+		st.recoveryCodes[key] = false
+	}
+
+	return nil
+}
+
+func (r *Repository) UseRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := recoveryCodeKey{userID: userID, hash: hash}
+	used, ok := st.recoveryCodes[key]
+	if !ok || used {
+		return fmt.Errorf("recovery code %w", models.ErrNotFound)
+	}
+
+	st.recoveryCodes[key] = true
+
+	return nil
+}
+
+func (r *Repository) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	count := 0
+	for key, used := range st.recoveryCodes {
+		if key.userID == userID && !used {
+			count++
+		}
+	}
+
+	return count, nil
+}
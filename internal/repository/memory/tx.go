@@ -0,0 +1,36 @@
+package memory
+
+import "context"
+
+// Выполняет fn в транзакции: если fn вернула ошибку или запаниковала, хранилище
+// возвращается к состоянию до ее начала. Транзакции выполняются по очереди,
+// вложенный вызов работает как точка сохранения
+func (r *Repository) InTx(ctx context.Context, fn func(tx *Repository) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if r.depth == 0 {
+		r.store.mu.Lock()
+		defer r.store.mu.Unlock()
+	}
+
+	snapshot := r.store.state.clone()
+	defer func() {
+		if p := recover(); p != nil {
+			r.store.state = snapshot
+			panic(p)
+		}
+	}()
+
+	err = fn(&Repository{store: r.store, depth: r.depth + 1})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		r.store.state = snapshot
+		return err
+	}
+
+	return nil
+}
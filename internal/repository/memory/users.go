@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddUser(ctx context.Context, user models.User) (int64, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	for _, existing := range st.users {
+		if existing.Login == user.Login {
+			return 0, fmt.Errorf("user with login %s already exists: %w", user.Login, models.ErrConflict)
+		}
+	}
+
+	st.lastUserID++
+	user.ID = st.lastUserID
+	user.CreatedAt = truncate(user.CreatedAt)
+	st.users[user.ID] = user
+
+	return user.ID, nil
+}
+
+func (r *Repository) GetUserByID(ctx context.Context, id int64) (models.User, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer unlock()
+
+	user, ok := st.users[id]
+	if !ok {
+		return models.User{}, fmt.Errorf("user with id %d %w", id, models.ErrNotFound)
+	}
+
+	return user, nil
+}
+
+func (r *Repository) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer unlock()
+
+	for _, user := range st.users {
+		if user.Login == login {
+			return user, nil
+		}
+	}
+
+	return models.User{}, fmt.Errorf("user with login %s %w", login, models.ErrNotFound)
+}
+
+func (r *Repository) UpdateUserPassword(ctx context.Context, id int64, passwordHash string) error {
+	st, unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	user, ok := st.users[id]
+	if !ok {
+		return fmt.Errorf("user with id %d %w", id, models.ErrNotFound)
+	}
+
+	user.PasswordHash = passwordHash
+	st.users[id] = user
+
+	return nil
+}
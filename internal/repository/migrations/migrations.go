@@ -2,44 +2,165 @@ package migrations
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"time"
 )
 
-func Migrations(db *sql.DB, pathDB string) error {
+// База создана более новой версией приложения: ее схему эта версия не знает
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
 
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS scheduler (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		date INTEGER NOT NULL,
-		title TEXT NOT NULL,
-		comment TEXT,
-		repeat VARCHAR(128)
-	);
-	`
+// Миграция схемы. Версии идут подряд начиная с 1, каждая миграция
+// применяется или откатывается в своей транзакции вместе с записью в schema_migrations
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// Миграции одной СУБД. У каждого драйвера своя схема и своя нумерация версий
+type Set struct {
+	migrations []Migration
+	// Запросы к schema_migrations с плейсхолдерами драйвера
+	insertVersionSQL string
+	deleteVersionSQL string
+}
+
+const createSchemaMigrationsSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at BIGINT NOT NULL
+);
+`
+
+// Последняя версия схемы, которую знает приложение
+func (s Set) Latest() int {
+	return s.migrations[len(s.migrations)-1].Version
+}
 
-	createIndexSQL := `
-	CREATE INDEX IF NOT EXISTS idx_scheduler_date ON scheduler (date);
-	`
+// Текущая версия схемы базы, 0 - миграции еще не применялись
+func Version(db *sql.DB) (int, error) {
+	_, err := db.Exec(createSchemaMigrationsSQL)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при создании таблицы миграций: %w", err)
+	}
+
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при чтении версии схемы: %w", err)
+	}
 
-	_, err := os.Stat(pathDB)
-	dbExists := !os.IsNotExist(err)
+	return version, nil
+}
+
+// Приводит схему к последней версии. Вызывается при старте приложения
+// и отказывается работать с базой, схема которой новее приложения
+func (s Set) Migrate(db *sql.DB) error {
+	return s.Up(db, s.Latest())
+}
 
-	if !dbExists {
-		_, err = db.Exec(createTableSQL)
+// Применяет миграции до версии target включительно
+func (s Set) Up(db *sql.DB, target int) error {
+	current, err := Version(db)
+	if err != nil {
+		return err
+	}
+
+	if current > s.Latest() {
+		return fmt.Errorf("schema version %d, latest known %d: %w", current, s.Latest(), ErrSchemaTooNew)
+	}
+
+	if target > s.Latest() {
+		return fmt.Errorf("unknown schema version %d, latest known %d", target, s.Latest())
+	}
+
+	for _, m := range s.migrations {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+
+		err = apply(db, m.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(s.insertVersionSQL, m.Version, m.Name, time.Now().Unix())
+			return err
+		})
 		if err != nil {
-			return fmt.Errorf("ошибка при создании таблицы: %w", err)
+			return fmt.Errorf("ошибка при применении миграции %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		log.Printf("Применена миграция %d: %s", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// Откатывает миграции, пока версия схемы не станет равна target
+func (s Set) Down(db *sql.DB, target int) error {
+	current, err := Version(db)
+	if err != nil {
+		return err
+	}
+
+	if current > s.Latest() {
+		return fmt.Errorf("schema version %d, latest known %d: %w", current, s.Latest(), ErrSchemaTooNew)
+	}
+
+	if target < 0 {
+		return fmt.Errorf("invalid schema version %d", target)
+	}
+
+	for i := len(s.migrations) - 1; i >= 0; i-- {
+		m := s.migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
 		}
 
-		_, err = db.Exec(createIndexSQL)
+		err = apply(db, m.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(s.deleteVersionSQL, m.Version)
+			return err
+		})
 		if err != nil {
-			return fmt.Errorf("ошибка при создании индекса: %w", err)
+			return fmt.Errorf("ошибка при откате миграции %d (%s): %w", m.Version, m.Name, err)
 		}
 
-		log.Println("База данных была создана и успешно инициализирована.")
-	} else {
-		log.Println("База данных уже существует. Подключение выполнено.")
+		log.Printf("Откачена миграция %d: %s", m.Version, m.Name)
 	}
+
 	return nil
 }
+
+func apply(db *sql.DB, migrate, record func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = migrate(tx)
+	if err != nil {
+		return err
+	}
+
+	err = record(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Выполняет запросы по очереди
+func execAll(queries ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, query := range queries {
+			_, err := tx.Exec(query)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,264 @@
+package migrations
+
+// Миграции PostgreSQL. Схема та же, что у SQLite, но первая миграция собирает
+// сразу всю схему, появившуюся до версионирования: в PostgreSQL таких баз нет
+var Postgres = Set{
+	insertVersionSQL: `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+	deleteVersionSQL: `DELETE FROM schema_migrations WHERE version = $1`,
+	migrations:       postgresMigrations,
+}
+
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create schema",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS scheduler (
+			id BIGSERIAL PRIMARY KEY,
+			date VARCHAR(8) NOT NULL,
+			title TEXT NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			repeat VARCHAR(128) NOT NULL DEFAULT '',
+			version BIGINT NOT NULL DEFAULT 1
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_scheduler_date ON scheduler (date);`,
+			`
+		CREATE TABLE IF NOT EXISTS undo_journal (
+			id BIGSERIAL PRIMARY KEY,
+			kind VARCHAR(16) NOT NULL,
+			task_id BIGINT NOT NULL,
+			snapshot TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			undone BOOLEAN NOT NULL DEFAULT FALSE
+		);`,
+			`
+		CREATE TABLE IF NOT EXISTS task_revisions (
+			id BIGSERIAL PRIMARY KEY,
+			task_id BIGINT NOT NULL,
+			revision BIGINT NOT NULL,
+			before TEXT NOT NULL,
+			after TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			UNIQUE (task_id, revision)
+		);`,
+			`
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key VARCHAR(255) NOT NULL,
+			scope VARCHAR(64) NOT NULL,
+			request_hash VARCHAR(64) NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			headers TEXT NOT NULL DEFAULT '{}',
+			body BYTEA,
+			created_at BIGINT NOT NULL,
+			PRIMARY KEY (key, scope)
+		);`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS idempotency_keys;`,
+			`DROP TABLE IF EXISTS task_revisions;`,
+			`DROP TABLE IF EXISTS undo_journal;`,
+			`DROP TABLE IF EXISTS scheduler;`,
+		),
+	},
+	{
+		Version: 2,
+		Name:    "create users and add owner to tasks",
+		// Существующие задачи достаются администратору с id 1, его пароль
+		// задается в конфиге и сохраняется при старте приложения
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS users (
+			id BIGSERIAL PRIMARY KEY,
+			login VARCHAR(64) NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			created_at BIGINT NOT NULL
+		);`,
+			`INSERT INTO users (id, login, password_hash, created_at) VALUES (1, 'admin', '', 0);`,
+			`SELECT setval(pg_get_serial_sequence('users', 'id'), 1);`,
+			`ALTER TABLE scheduler ADD COLUMN user_id BIGINT NOT NULL DEFAULT 1;`,
+			`CREATE INDEX idx_scheduler_user_date ON scheduler (user_id, date);`,
+			`ALTER TABLE undo_journal ADD COLUMN user_id BIGINT NOT NULL DEFAULT 1;`,
+			`ALTER TABLE task_revisions ADD COLUMN user_id BIGINT NOT NULL DEFAULT 1;`,
+			`ALTER TABLE idempotency_keys ADD COLUMN user_id BIGINT NOT NULL DEFAULT 1;`,
+			`ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;`,
+			`ALTER TABLE idempotency_keys ADD PRIMARY KEY (user_id, key, scope);`,
+		),
+		Down: execAll(
+			`DELETE FROM idempotency_keys WHERE user_id <> 1;`,
+			`ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;`,
+			`ALTER TABLE idempotency_keys ADD PRIMARY KEY (key, scope);`,
+			`ALTER TABLE idempotency_keys DROP COLUMN user_id;`,
+			`ALTER TABLE task_revisions DROP COLUMN user_id;`,
+			`ALTER TABLE undo_journal DROP COLUMN user_id;`,
+			`DROP INDEX IF EXISTS idx_scheduler_user_date;`,
+			`ALTER TABLE scheduler DROP COLUMN user_id;`,
+			`DROP TABLE IF EXISTS users;`,
+		),
+	},
+	{
+		Version: 3,
+		Name:    "create api_tokens",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			scopes TEXT NOT NULL,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			created_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL DEFAULT 0
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens (user_id);`,
+		),
+		Down: execAll(`DROP TABLE IF EXISTS api_tokens;`),
+	},
+	{
+		Version: 4,
+		Name:    "create sessions and refresh_tokens",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT NOT NULL,
+			fingerprint VARCHAR(64) NOT NULL,
+			created_at BIGINT NOT NULL,
+			revoked BOOLEAN NOT NULL DEFAULT FALSE
+		);`,
+			`
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			session_id BIGINT NOT NULL,
+			user_id BIGINT NOT NULL,
+			created_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			used BOOLEAN NOT NULL DEFAULT FALSE
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires ON refresh_tokens (expires_at);`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS refresh_tokens;`,
+			`DROP TABLE IF EXISTS sessions;`,
+		),
+	},
+	{
+		Version: 5,
+		Name:    "create two_factor and recovery_codes",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS two_factor (
+			user_id BIGINT PRIMARY KEY,
+			secret VARCHAR(64) NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			last_step BIGINT NOT NULL DEFAULT 0,
+			created_at BIGINT NOT NULL
+		);`,
+			`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			user_id BIGINT NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			used BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at BIGINT NOT NULL,
+			PRIMARY KEY (user_id, code_hash)
+		);`,
+			`ALTER TABLE sessions ADD COLUMN second_factor BOOLEAN NOT NULL DEFAULT FALSE;`,
+		),
+		Down: execAll(
+			`ALTER TABLE sessions DROP COLUMN second_factor;`,
+			`DROP TABLE IF EXISTS recovery_codes;`,
+			`DROP TABLE IF EXISTS two_factor;`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "create identities",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS identities (
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			user_id BIGINT NOT NULL,
+			created_at BIGINT NOT NULL,
+			PRIMARY KEY (issuer, subject)
+		);`,
+		),
+		Down: execAll(`DROP TABLE IF EXISTS identities;`),
+	},
+	{
+		Version: 7,
+		Name:    "create lists and list_members",
+		// Существующие задачи остаются личными, их последним редактором считается владелец
+		Up: execAll(
+			`ALTER TABLE scheduler ADD COLUMN list_id BIGINT NOT NULL DEFAULT 0;`,
+			`ALTER TABLE scheduler ADD COLUMN modified_by BIGINT NOT NULL DEFAULT 0;`,
+			`UPDATE scheduler SET modified_by = user_id;`,
+			`CREATE INDEX IF NOT EXISTS idx_scheduler_list_date ON scheduler (list_id, date);`,
+			`
+		CREATE TABLE IF NOT EXISTS lists (
+			id BIGSERIAL PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			created_at BIGINT NOT NULL
+		);`,
+			`
+		CREATE TABLE IF NOT EXISTS list_members (
+			list_id BIGINT NOT NULL,
+			user_id BIGINT NOT NULL,
+			role VARCHAR(16) NOT NULL,
+			pending BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at BIGINT NOT NULL,
+			PRIMARY KEY (list_id, user_id)
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_list_members_user ON list_members (user_id);`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS list_members;`,
+			`DROP TABLE IF EXISTS lists;`,
+			`DROP INDEX IF EXISTS idx_scheduler_list_date;`,
+			`ALTER TABLE scheduler DROP COLUMN modified_by;`,
+			`ALTER TABLE scheduler DROP COLUMN list_id;`,
+		),
+	},
+	{
+		Version: 8,
+		Name:    "create audit_log",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			created_at BIGINT NOT NULL,
+			user_id BIGINT NOT NULL DEFAULT 0,
+			login VARCHAR(255) NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			action VARCHAR(32) NOT NULL,
+			target VARCHAR(64) NOT NULL DEFAULT '',
+			success BOOLEAN NOT NULL DEFAULT TRUE,
+			details TEXT NOT NULL DEFAULT ''
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log (user_id);`,
+		),
+		Down: execAll(`DROP TABLE IF EXISTS audit_log;`),
+	},
+	{
+		Version: 9,
+		Name:    "make audit_log append-only",
+		// Записи журнала нельзя изменить или удалить даже в обход приложения.
+		// TRUNCATE строковые триггеры не вызывает, им журнал очищается в тестах
+		Up: execAll(`
+		CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_log is append-only';
+		END;
+		$$ LANGUAGE plpgsql;`,
+			`DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;`,
+			`
+		CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+		FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();`,
+		),
+		Down: execAll(
+			`DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;`,
+			`DROP FUNCTION IF EXISTS audit_log_append_only();`,
+		),
+	},
+	{
+		Version: 10,
+		Name:    "add undo_journal.version",
+		// Версия задачи после операции. У прежних записей 0: их отмена версию не проверяет
+		Up:   execAll(`ALTER TABLE undo_journal ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;`),
+		Down: execAll(`ALTER TABLE undo_journal DROP COLUMN IF EXISTS version;`),
+	},
+}
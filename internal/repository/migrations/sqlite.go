@@ -0,0 +1,320 @@
+package migrations
+
+import "database/sql"
+
+// Миграции SQLite по порядку. Новые добавляются только в конец.
+// Первые миграции повторяют схему, которую раньше создавало приложение без
+// учета версий, поэтому написаны так, чтобы не падать на уже существующих таблицах
+var SQLite = Set{
+	insertVersionSQL: `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+	deleteVersionSQL: `DELETE FROM schema_migrations WHERE version = ?`,
+	migrations:       sqliteMigrations,
+}
+
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create scheduler",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS scheduler (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			date INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			comment TEXT,
+			repeat VARCHAR(128)
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_scheduler_date ON scheduler (date);`,
+		),
+		Down: execAll(`DROP TABLE IF EXISTS scheduler;`),
+	},
+	{
+		Version: 2,
+		Name:    "create undo_journal",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS undo_journal (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind VARCHAR(16) NOT NULL,
+			task_id INTEGER NOT NULL,
+			snapshot TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			undone INTEGER NOT NULL DEFAULT 0
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS undo_journal;`),
+	},
+	{
+		Version: 3,
+		Name:    "create task_revisions",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS task_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			task_id INTEGER NOT NULL,
+			revision INTEGER NOT NULL,
+			before TEXT NOT NULL,
+			after TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			UNIQUE (task_id, revision)
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS task_revisions;`),
+	},
+	{
+		Version: 4,
+		Name:    "create idempotency_keys",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key VARCHAR(255) NOT NULL,
+			scope VARCHAR(64) NOT NULL,
+			request_hash VARCHAR(64) NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			headers TEXT NOT NULL DEFAULT '{}',
+			body BLOB,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (key, scope)
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS idempotency_keys;`),
+	},
+	{
+		Version: 5,
+		Name:    "add scheduler.version",
+		Up: func(tx *sql.Tx) error {
+			exists, err := sqliteColumnExists(tx, "scheduler", "version")
+			if err != nil || exists {
+				return err
+			}
+			return execAll(`ALTER TABLE scheduler ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`)(tx)
+		},
+		Down: execAll(`ALTER TABLE scheduler DROP COLUMN version;`),
+	},
+	{
+		Version: 6,
+		Name:    "create users",
+		// Пароль администратора задается в конфиге и сохраняется при старте приложения
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			login VARCHAR(64) NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		);`,
+			`INSERT INTO users (id, login, password_hash, created_at) VALUES (1, 'admin', '', 0);`,
+		),
+		Down: execAll(`DROP TABLE IF EXISTS users;`),
+	},
+	{
+		Version: 7,
+		Name:    "add owner to tasks",
+		// Существующие задачи достаются администратору. Сохраненные ответы
+		// идемпотентных запросов не переносятся: они живут не дольше суток
+		Up: execAll(
+			`ALTER TABLE scheduler ADD COLUMN user_id INTEGER NOT NULL DEFAULT 1;`,
+			`CREATE INDEX idx_scheduler_user_date ON scheduler (user_id, date);`,
+			`ALTER TABLE undo_journal ADD COLUMN user_id INTEGER NOT NULL DEFAULT 1;`,
+			`ALTER TABLE task_revisions ADD COLUMN user_id INTEGER NOT NULL DEFAULT 1;`,
+			`DROP TABLE idempotency_keys;`,
+			`
+		CREATE TABLE idempotency_keys (
+			user_id INTEGER NOT NULL,
+			key VARCHAR(255) NOT NULL,
+			scope VARCHAR(64) NOT NULL,
+			request_hash VARCHAR(64) NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			headers TEXT NOT NULL DEFAULT '{}',
+			body BLOB,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (user_id, key, scope)
+		);`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_scheduler_user_date;`,
+			`ALTER TABLE scheduler DROP COLUMN user_id;`,
+			`ALTER TABLE undo_journal DROP COLUMN user_id;`,
+			`ALTER TABLE task_revisions DROP COLUMN user_id;`,
+			`DROP TABLE idempotency_keys;`,
+			`
+		CREATE TABLE idempotency_keys (
+			key VARCHAR(255) NOT NULL,
+			scope VARCHAR(64) NOT NULL,
+			request_hash VARCHAR(64) NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			headers TEXT NOT NULL DEFAULT '{}',
+			body BLOB,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (key, scope)
+		);`,
+		),
+	},
+	{
+		Version: 8,
+		Name:    "create api_tokens",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			scopes TEXT NOT NULL,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL DEFAULT 0
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens (user_id);`,
+		),
+		Down: execAll(`DROP TABLE IF EXISTS api_tokens;`),
+	},
+	{
+		Version: 9,
+		Name:    "create sessions and refresh_tokens",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			fingerprint VARCHAR(64) NOT NULL,
+			created_at INTEGER NOT NULL,
+			revoked INTEGER NOT NULL DEFAULT 0
+		);`,
+			`
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			session_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			used INTEGER NOT NULL DEFAULT 0
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires ON refresh_tokens (expires_at);`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS refresh_tokens;`,
+			`DROP TABLE IF EXISTS sessions;`,
+		),
+	},
+	{
+		Version: 10,
+		Name:    "create two_factor and recovery_codes",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS two_factor (
+			user_id INTEGER PRIMARY KEY,
+			secret VARCHAR(64) NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 0,
+			last_step INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL
+		);`,
+			`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			user_id INTEGER NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			used INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (user_id, code_hash)
+		);`,
+			`ALTER TABLE sessions ADD COLUMN second_factor INTEGER NOT NULL DEFAULT 0;`,
+		),
+		Down: execAll(
+			`ALTER TABLE sessions DROP COLUMN second_factor;`,
+			`DROP TABLE IF EXISTS recovery_codes;`,
+			`DROP TABLE IF EXISTS two_factor;`,
+		),
+	},
+	{
+		Version: 11,
+		Name:    "create identities",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS identities (
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (issuer, subject)
+		);`,
+		),
+		Down: execAll(`DROP TABLE IF EXISTS identities;`),
+	},
+	{
+		Version: 12,
+		Name:    "create lists and list_members",
+		// Существующие задачи остаются личными, их последним редактором считается владелец
+		Up: execAll(
+			`ALTER TABLE scheduler ADD COLUMN list_id INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE scheduler ADD COLUMN modified_by INTEGER NOT NULL DEFAULT 0;`,
+			`UPDATE scheduler SET modified_by = user_id;`,
+			`CREATE INDEX IF NOT EXISTS idx_scheduler_list_date ON scheduler (list_id, date);`,
+			`
+		CREATE TABLE IF NOT EXISTS lists (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name VARCHAR(100) NOT NULL,
+			created_at INTEGER NOT NULL
+		);`,
+			`
+		CREATE TABLE IF NOT EXISTS list_members (
+			list_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role VARCHAR(16) NOT NULL,
+			pending INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (list_id, user_id)
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_list_members_user ON list_members (user_id);`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS list_members;`,
+			`DROP TABLE IF EXISTS lists;`,
+			`DROP INDEX IF EXISTS idx_scheduler_list_date;`,
+			`ALTER TABLE scheduler DROP COLUMN modified_by;`,
+			`ALTER TABLE scheduler DROP COLUMN list_id;`,
+		),
+	},
+	{
+		Version: 13,
+		Name:    "create audit_log",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at INTEGER NOT NULL,
+			user_id INTEGER NOT NULL DEFAULT 0,
+			login VARCHAR(255) NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			action VARCHAR(32) NOT NULL,
+			target VARCHAR(64) NOT NULL DEFAULT '',
+			success INTEGER NOT NULL DEFAULT 1,
+			details TEXT NOT NULL DEFAULT ''
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log (user_id);`,
+		),
+		Down: execAll(`DROP TABLE IF EXISTS audit_log;`),
+	},
+	{
+		Version: 14,
+		Name:    "make audit_log append-only",
+		// Записи журнала нельзя изменить или удалить даже в обход приложения
+		Up: execAll(`
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END;`,
+			`
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END;`,
+		),
+		Down: execAll(
+			`DROP TRIGGER IF EXISTS audit_log_no_delete;`,
+			`DROP TRIGGER IF EXISTS audit_log_no_update;`,
+		),
+	},
+	{
+		Version: 15,
+		Name:    "add undo_journal.version",
+		// Версия задачи после операции. У прежних записей 0: их отмена версию не проверяет
+		Up:   execAll(`ALTER TABLE undo_journal ADD COLUMN version INTEGER NOT NULL DEFAULT 0;`),
+		Down: execAll(`ALTER TABLE undo_journal DROP COLUMN version;`),
+	},
+}
+
+func sqliteColumnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddAPIToken(ctx context.Context, userID int64, token models.APIToken) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO api_tokens (user_id, name, scopes, token_hash, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, query, userID, token.Name, strings.Join(token.Scopes, " "),
+		token.Hash, token.CreatedAt.Unix(), expiresAtUnix(token.ExpiresAt)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert api token: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *Repository) GetAPITokens(ctx context.Context, userID int64) ([]models.APIToken, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, user_id, name, scopes, token_hash, created_at, expires_at FROM api_tokens
+	WHERE user_id = $1 ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	return tokens, nil
+}
+
+func (r *Repository) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, user_id, name, scopes, token_hash, created_at, expires_at FROM api_tokens
+	WHERE token_hash = $1`
+
+	token, err := scanAPIToken(r.db.QueryRowContext(ctx, query, hash))
+	if err == sql.ErrNoRows {
+		return models.APIToken{}, fmt.Errorf("api token %w", models.ErrNotFound)
+	}
+
+	return token, err
+}
+
+func (r *Repository) DeleteAPIToken(ctx context.Context, userID int64, id string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tokenID, ok := parseID(id)
+	if !ok {
+		return fmt.Errorf("api token with id %s %w", id, models.ErrNotFound)
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete api token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("api token with id %s %w", id, models.ErrNotFound)
+	}
+
+	return nil
+}
+
+// Бессрочный токен хранится с expires_at = 0
+func expiresAtUnix(expiresAt *time.Time) int64 {
+	if expiresAt == nil {
+		return 0
+	}
+	return expiresAt.Unix()
+}
+
+func scanAPIToken(row scanner) (models.APIToken, error) {
+	var token models.APIToken
+	var id int64
+	var scopes string
+	var createdAt, expiresAt int64
+
+	err := row.Scan(&id, &token.UserID, &token.Name, &scopes, &token.Hash, &createdAt, &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.APIToken{}, err
+		}
+		return models.APIToken{}, fmt.Errorf("error scanning row: %w", err)
+	}
+
+	token.ID = strconv.FormatInt(id, 10)
+	token.Scopes = strings.Fields(scopes)
+	token.CreatedAt = time.Unix(createdAt, 0)
+	if expiresAt != 0 {
+		t := time.Unix(expiresAt, 0)
+		token.ExpiresAt = &t
+	}
+
+	return token, nil
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddAuditEvent(ctx context.Context, event models.AuditEvent) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO audit_log (created_at, user_id, login, ip, action, target, success, details)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, query, event.CreatedAt.Unix(), event.UserID, event.Login, event.IP,
+		event.Action, event.Target, event.Success, event.Details).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert audit event: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *Repository) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var conditions []string
+	var args []interface{}
+
+	// Добавляет аргумент запроса и возвращает его плейсхолдер
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.UserID != 0 {
+		conditions = append(conditions, "audit_log.user_id = "+param(filter.UserID))
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "audit_log.action = "+param(filter.Action))
+	}
+	if filter.IP != "" {
+		conditions = append(conditions, "audit_log.ip = "+param(filter.IP))
+	}
+	if filter.Success != nil {
+		conditions = append(conditions, "audit_log.success = "+param(*filter.Success))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "audit_log.created_at >= "+param(filter.Since.Unix()))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "audit_log.created_at < "+param(filter.Until.Unix()))
+	}
+	if filter.BeforeID != 0 {
+		conditions = append(conditions, "audit_log.id < "+param(filter.BeforeID))
+	}
+
+	query := `SELECT audit_log.id, audit_log.created_at, audit_log.user_id, COALESCE(users.login, audit_log.login),
+	audit_log.ip, audit_log.action, audit_log.target, audit_log.success, audit_log.details
+	FROM audit_log LEFT JOIN users ON users.id = audit_log.user_id`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY audit_log.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + param(filter.Limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		var createdAt int64
+
+		err := rows.Scan(&event.ID, &createdAt, &event.UserID, &event.Login, &event.IP,
+			&event.Action, &event.Target, &event.Success, &event.Details)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+
+		event.CreatedAt = time.Unix(createdAt, 0)
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	return events, nil
+}
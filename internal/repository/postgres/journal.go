@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddOperation(ctx context.Context, userID int64, op models.Operation) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	snapshot, err := json.Marshal(op.Snapshot)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode task snapshot: %w", err)
+	}
+
+	query := `INSERT INTO undo_journal (user_id, kind, task_id, snapshot, version, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+
+	var id int64
+	err = r.db.QueryRowContext(ctx, query, userID, op.Kind, op.TaskID, string(snapshot), op.Version, op.CreatedAt.Unix()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert operation: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *Repository) GetOperation(ctx context.Context, userID int64, id string) (models.Operation, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	opID, ok := parseID(id)
+	if !ok {
+		return models.Operation{}, fmt.Errorf("operation with id %s %w", id, models.ErrNotFound)
+	}
+
+	query := "SELECT id, kind, task_id, snapshot, version, created_at, undone FROM undo_journal WHERE id = $1 AND user_id = $2"
+
+	var op models.Operation
+	var snapshot string
+	var createdAt int64
+
+	res := r.db.QueryRowContext(ctx, query, opID, userID)
+	err := res.Scan(&op.ID, &op.Kind, &op.TaskID, &snapshot, &op.Version, &createdAt, &op.Undone)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Operation{}, fmt.Errorf("operation with id %s %w", id, models.ErrNotFound)
+		}
+		return models.Operation{}, fmt.Errorf("error executing query: %w", err)
+	}
+
+	err = json.Unmarshal([]byte(snapshot), &op.Snapshot)
+	if err != nil {
+		return models.Operation{}, fmt.Errorf("failed to decode task snapshot: %w", err)
+	}
+	op.CreatedAt = time.Unix(createdAt, 0)
+
+	return op, nil
+}
+
+func (r *Repository) MarkOperationUndone(ctx context.Context, userID int64, id string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE undo_journal SET undone = TRUE WHERE id = $1 AND user_id = $2 AND NOT undone`
+
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to mark operation as undone: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("operation with id %s is already undone: %w", id, models.ErrConflict)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteOperationsBefore(ctx context.Context, before time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM undo_journal WHERE created_at < $1`
+
+	_, err := r.db.ExecContext(ctx, query, before.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to delete expired operations: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddList(ctx context.Context, list models.List) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, `INSERT INTO lists (name, created_at) VALUES ($1, $2) RETURNING id`, list.Name, list.CreatedAt.Unix()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert list: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *Repository) GetList(ctx context.Context, id int64) (models.List, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var list models.List
+	var createdAt int64
+
+	err := r.db.QueryRowContext(ctx, `SELECT id, name, created_at FROM lists WHERE id = $1`, id).
+		Scan(&list.ID, &list.Name, &createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.List{}, fmt.Errorf("list with id %d %w", id, models.ErrNotFound)
+		}
+		return models.List{}, fmt.Errorf("error executing query: %w", err)
+	}
+
+	list.CreatedAt = time.Unix(createdAt, 0)
+
+	return list, nil
+}
+
+func (r *Repository) GetLists(ctx context.Context, userID int64) ([]models.List, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT lists.id, lists.name, list_members.role, list_members.pending, lists.created_at
+	FROM list_members JOIN lists ON lists.id = list_members.list_id
+	WHERE list_members.user_id = $1 ORDER BY lists.id`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lists: %w", err)
+	}
+	defer rows.Close()
+
+	lists := []models.List{}
+	for rows.Next() {
+		var list models.List
+		var createdAt int64
+
+		err := rows.Scan(&list.ID, &list.Name, &list.Role, &list.Pending, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+
+		list.CreatedAt = time.Unix(createdAt, 0)
+		lists = append(lists, list)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	return lists, nil
+}
+
+func (r *Repository) RenameList(ctx context.Context, id int64, name string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE lists SET name = $1 WHERE id = $2`, name, id)
+	if err != nil {
+		return fmt.Errorf("failed to rename list: %w", err)
+	}
+
+	return expectAffected(result, fmt.Errorf("list with id %d %w", id, models.ErrNotFound))
+}
+
+// Задачи и участники удаляются отдельными запросами, поэтому вызывать стоит в транзакции
+func (r *Repository) DeleteList(ctx context.Context, id int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM scheduler WHERE list_id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete list tasks: %w", err)
+	}
+
+	_, err = r.db.ExecContext(ctx, `DELETE FROM list_members WHERE list_id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete list members: %w", err)
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM lists WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete list: %w", err)
+	}
+
+	return expectAffected(result, fmt.Errorf("list with id %d %w", id, models.ErrNotFound))
+}
+
+func (r *Repository) AddListMember(ctx context.Context, member models.ListMember) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO list_members (list_id, user_id, role, pending, created_at) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := r.db.ExecContext(ctx, query, member.ListID, member.UserID, member.Role, member.Pending, member.CreatedAt.Unix())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("user %d is already a member of list %d: %w", member.UserID, member.ListID, models.ErrConflict)
+		}
+		return fmt.Errorf("failed to insert list member: %w", err)
+	}
+
+	return nil
+}
+
+const selectListMember = `SELECT list_members.list_id, list_members.user_id, COALESCE(users.login, ''),
+	list_members.role, list_members.pending, list_members.created_at
+	FROM list_members LEFT JOIN users ON users.id = list_members.user_id`
+
+func (r *Repository) GetListMember(ctx context.Context, listID, userID int64) (models.ListMember, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := selectListMember + ` WHERE list_members.list_id = $1 AND list_members.user_id = $2`
+
+	member, err := scanListMember(r.db.QueryRowContext(ctx, query, listID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.ListMember{}, fmt.Errorf("member %d of list %d %w", userID, listID, models.ErrNotFound)
+		}
+		return models.ListMember{}, fmt.Errorf("error executing query: %w", err)
+	}
+
+	return member, nil
+}
+
+func (r *Repository) GetListMembers(ctx context.Context, listID int64) ([]models.ListMember, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := selectListMember + ` WHERE list_members.list_id = $1 ORDER BY list_members.created_at, list_members.user_id`
+
+	rows, err := r.db.QueryContext(ctx, query, listID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get list members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []models.ListMember{}
+	for rows.Next() {
+		member, err := scanListMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	return members, nil
+}
+
+func (r *Repository) UpdateListMember(ctx context.Context, member models.ListMember) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE list_members SET role = $1, pending = $2 WHERE list_id = $3 AND user_id = $4`
+
+	result, err := r.db.ExecContext(ctx, query, member.Role, member.Pending, member.ListID, member.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update list member: %w", err)
+	}
+
+	return expectAffected(result, fmt.Errorf("member %d of list %d %w", member.UserID, member.ListID, models.ErrNotFound))
+}
+
+func (r *Repository) DeleteListMember(ctx context.Context, listID, userID int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM list_members WHERE list_id = $1 AND user_id = $2`, listID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete list member: %w", err)
+	}
+
+	return expectAffected(result, fmt.Errorf("member %d of list %d %w", userID, listID, models.ErrNotFound))
+}
+
+func scanListMember(row scanner) (models.ListMember, error) {
+	var member models.ListMember
+	var createdAt int64
+
+	err := row.Scan(&member.ListID, &member.UserID, &member.Login, &member.Role, &member.Pending, &createdAt)
+	if err != nil {
+		return models.ListMember{}, err
+	}
+
+	member.CreatedAt = time.Unix(createdAt, 0)
+
+	return member, nil
+}
+
+// Возвращает notFound, если запрос не затронул ни одной строки
+func expectAffected(result sql.Result, notFound error) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return notFound
+	}
+
+	return nil
+}
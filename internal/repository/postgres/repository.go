@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+// Общие методы *sql.DB и *sql.Tx, чтобы одни и те же запросы
+// выполнялись как в транзакции, так и без нее
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type Repository struct {
+	db   querier
+	conn *sql.DB
+	// Глубина вложенности транзакций: 0 - вне транзакции,
+	// больше 1 - внутри точки сохранения
+	depth int
+	// Ограничение времени одного обращения к базе, 0 - без ограничения
+	timeout time.Duration
+}
+
+func New(db *sql.DB, timeout time.Duration) *Repository {
+	return &Repository{db: db, conn: db, timeout: timeout}
+}
+
+// Контекст запроса с ограничением времени. Каждый метод отменяет его при выходе,
+// поэтому все строки результата читаются до возврата из метода
+func (r *Repository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// Задача вместе с логинами автора и последнего редактора
+const selectTask = `SELECT scheduler.id, scheduler.date, scheduler.title, scheduler.comment, scheduler.repeat,
+	scheduler.list_id, scheduler.user_id, COALESCE(creator.login, ''), scheduler.modified_by, COALESCE(editor.login, ''),
+	scheduler.version
+	FROM scheduler
+	LEFT JOIN users creator ON creator.id = scheduler.user_id
+	LEFT JOIN users editor ON editor.id = scheduler.modified_by`
+
+// Условие доступности задачи пользователю: личная задача или задача списка, приглашение
+// в который он принял. param - номер параметра с id пользователя
+func visibleTask(param int) string {
+	p := "$" + strconv.Itoa(param)
+	return `(scheduler.list_id = 0 AND scheduler.user_id = ` + p + ` OR scheduler.list_id IN
+	(SELECT list_id FROM list_members WHERE user_id = ` + p + ` AND NOT pending))`
+}
+
+func (r *Repository) AddTask(ctx context.Context, userID int64, task models.Task) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO scheduler (user_id, list_id, modified_by, date, title, comment, repeat)
+	VALUES ($1, $2, $1, $3, $4, $5, $6) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, query, userID, task.ListID, task.Date, task.Title, task.Comment, task.Repeat).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert task: %w", err)
+	}
+
+	return id, nil
+}
+
+// Восстанавливает удаленную задачу с ее прежним id
+func (r *Repository) RestoreTask(ctx context.Context, userID int64, task models.Task) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	id, ok := parseID(task.ID)
+	if !ok {
+		return models.NewValidationError("id", "failed to parse id", nil)
+	}
+
+	query := `INSERT INTO scheduler (id, user_id, list_id, modified_by, date, title, comment, repeat)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := r.db.ExecContext(ctx, query, id, taskAuthor(userID, task), task.ListID, userID, task.Date, task.Title, task.Comment, task.Repeat)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("task with id %s already exists: %w", task.ID, models.ErrConflict)
+		}
+		return fmt.Errorf("failed to restore task with id %s: %w", task.ID, err)
+	}
+
+	return nil
+}
+
+// Автор восстанавливаемой задачи. Если он не указан, автором становится userID
+func taskAuthor(userID int64, task models.Task) int64 {
+	if task.CreatedByID != 0 {
+		return task.CreatedByID
+	}
+	return userID
+}
+
+// Внутри транзакции строка задачи блокируется до ее конца (FOR UPDATE), поэтому
+// параллельные read-modify-write одной задачи выполняются по очереди
+func (r *Repository) GetTaskByID(ctx context.Context, userID int64, id string) (models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	taskID, ok := parseID(id)
+	if !ok {
+		return models.Task{}, fmt.Errorf("task with id %s %w", id, models.ErrNotFound)
+	}
+
+	query := selectTask + " WHERE scheduler.id = $1 AND " + visibleTask(2)
+	if r.depth > 0 {
+		// Строки пользователей из LEFT JOIN блокировать нельзя и не нужно
+		query += " FOR UPDATE OF scheduler"
+	}
+
+	task, err := scanTask(r.db.QueryRowContext(ctx, query, taskID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Task{}, fmt.Errorf("task with id %s %w", id, models.ErrNotFound)
+		}
+		return models.Task{}, fmt.Errorf("error executing query: %w", err)
+	}
+
+	return task, nil
+}
+
+func (r *Repository) GetTasks(ctx context.Context, userID, listID int64) ([]models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := selectTask + " WHERE scheduler.list_id = $1 AND " + visibleTask(2) + " ORDER BY scheduler.id"
+
+	rows, err := r.db.QueryContext(ctx, query, listID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tasks: %w", err)
+	}
+
+	return scanTasks(rows)
+}
+
+func (r *Repository) SearchTasksByString(ctx context.Context, userID, listID int64, search string) ([]models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := selectTask + " WHERE scheduler.list_id = $1 AND " + visibleTask(2) + `
+	AND (strpos(lower(scheduler.title), $3) > 0 OR strpos(lower(scheduler.comment), $3) > 0)
+	ORDER BY scheduler.date, scheduler.id`
+
+	// lower меняет регистр по правилам локали базы: для кириллицы нужна локаль UTF-8
+	rows, err := r.db.QueryContext(ctx, query, listID, userID, strings.ToLower(search))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
+	}
+
+	return scanTasks(rows)
+}
+
+func (r *Repository) SearchTasksByDate(ctx context.Context, userID, listID int64, date string) ([]models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := selectTask + " WHERE scheduler.list_id = $1 AND " + visibleTask(2) + " AND scheduler.date = $3 ORDER BY scheduler.id"
+
+	rows, err := r.db.QueryContext(ctx, query, listID, userID, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
+	}
+
+	return scanTasks(rows)
+}
+
+func scanTask(row scanner) (models.Task, error) {
+	var task models.Task
+
+	err := row.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat, &task.ListID,
+		&task.CreatedByID, &task.CreatedBy, &task.ModifiedByID, &task.ModifiedBy, &task.Version)
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	return task, nil
+}
+
+func scanTasks(rows *sql.Rows) ([]models.Task, error) {
+	defer rows.Close()
+
+	tasks := []models.Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// Если task.Version не 0, задача изменяется, только если ее версия совпадает
+func (r *Repository) EditTask(ctx context.Context, userID int64, task models.Task) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	id, ok := parseID(task.ID)
+	if !ok {
+		return fmt.Errorf("task with id %s %w", task.ID, models.ErrNotFound)
+	}
+
+	query := `UPDATE scheduler SET date = $1, title = $2, comment = $3, repeat = $4, modified_by = $6, version = version + 1
+	WHERE scheduler.id = $5 AND ` + visibleTask(6) + ` AND ($7 = 0 OR version = $7)`
+
+	result, err := r.db.ExecContext(ctx, query, task.Date, task.Title, task.Comment, task.Repeat, id, userID, task.Version)
+	if err != nil {
+		return fmt.Errorf("failed to edit task with id %s: %w", task.ID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return r.versionMismatch(ctx, userID, task.ID, task.Version)
+	}
+
+	return nil
+}
+
+// Если version не 0, задача удаляется, только если ее версия совпадает
+func (r *Repository) DeleteByID(ctx context.Context, userID int64, id string, version int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	taskID, ok := parseID(id)
+	if !ok {
+		return fmt.Errorf("task with id %s %w", id, models.ErrNotFound)
+	}
+
+	query := `DELETE FROM scheduler WHERE scheduler.id = $1 AND ` + visibleTask(2) + ` AND ($3 = 0 OR version = $3)`
+
+	result, err := r.db.ExecContext(ctx, query, taskID, userID, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return r.versionMismatch(ctx, userID, id, version)
+	}
+
+	return nil
+}
+
+// Объясняет, почему условный UPDATE или DELETE не затронул ни одной строки:
+// задачи нет или ее версия уже изменилась
+func (r *Repository) versionMismatch(ctx context.Context, userID int64, id string, version int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	task, err := r.GetTaskByID(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("task with id %s has version %d, expected %d: %w",
+		id, task.Version, version, models.ErrPreconditionFailed)
+}
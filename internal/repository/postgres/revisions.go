@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+// Номер ревизии считается отдельно для каждой задачи, начиная с 1
+func (r *Repository) AddRevision(ctx context.Context, userID int64, rev models.Revision) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	before, err := json.Marshal(rev.Before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode task snapshot: %w", err)
+	}
+
+	after, err := json.Marshal(rev.After)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode task snapshot: %w", err)
+	}
+
+	query := `INSERT INTO task_revisions (user_id, task_id, revision, before, after, created_at)
+	VALUES ($1, $2, (SELECT COALESCE(MAX(revision), 0) + 1 FROM task_revisions WHERE task_id = $2), $3, $4, $5)
+	RETURNING revision`
+
+	var revision int64
+	err = r.db.QueryRowContext(ctx, query, userID, rev.TaskID, string(before), string(after), rev.CreatedAt.Unix()).Scan(&revision)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert revision: %w", err)
+	}
+
+	return revision, nil
+}
+
+func (r *Repository) GetRevisions(ctx context.Context, userID int64, taskID string) ([]models.Revision, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if _, ok := parseID(taskID); !ok {
+		return []models.Revision{}, nil
+	}
+
+	query := `SELECT task_id, revision, before, after, created_at FROM task_revisions
+	WHERE task_id = $1 AND user_id = $2 ORDER BY revision`
+
+	rows, err := r.db.QueryContext(ctx, query, taskID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []models.Revision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	if revisions == nil {
+		revisions = []models.Revision{}
+	}
+
+	return revisions, nil
+}
+
+func (r *Repository) GetRevision(ctx context.Context, userID int64, taskID string, revision int64) (models.Revision, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if _, ok := parseID(taskID); !ok {
+		return models.Revision{}, fmt.Errorf("revision %d of task %s %w", revision, taskID, models.ErrNotFound)
+	}
+
+	query := `SELECT task_id, revision, before, after, created_at FROM task_revisions
+	WHERE task_id = $1 AND user_id = $2 AND revision = $3`
+
+	rev, err := scanRevision(r.db.QueryRowContext(ctx, query, taskID, userID, revision))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Revision{}, fmt.Errorf("revision %d of task %s %w", revision, taskID, models.ErrNotFound)
+		}
+		return models.Revision{}, err
+	}
+
+	return rev, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRevision(row scanner) (models.Revision, error) {
+	var rev models.Revision
+	var before, after string
+	var createdAt int64
+
+	err := row.Scan(&rev.TaskID, &rev.Revision, &before, &after, &createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Revision{}, err
+		}
+		return models.Revision{}, fmt.Errorf("error scanning row: %w", err)
+	}
+
+	err = json.Unmarshal([]byte(before), &rev.Before)
+	if err != nil {
+		return models.Revision{}, fmt.Errorf("failed to decode task snapshot: %w", err)
+	}
+
+	err = json.Unmarshal([]byte(after), &rev.After)
+	if err != nil {
+		return models.Revision{}, fmt.Errorf("failed to decode task snapshot: %w", err)
+	}
+	rev.CreatedAt = time.Unix(createdAt, 0)
+
+	return rev, nil
+}
@@ -0,0 +1,160 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddSession(ctx context.Context, userID int64, session models.Session) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO sessions (user_id, fingerprint, created_at, second_factor) VALUES ($1, $2, $3, $4) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRowContext(ctx, query, userID, session.Fingerprint, session.CreatedAt.Unix(), session.SecondFactor).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert session: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *Repository) GetSession(ctx context.Context, userID int64, id int64) (models.Session, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, user_id, fingerprint, created_at, revoked, second_factor FROM sessions WHERE id = $1 AND user_id = $2`
+
+	var session models.Session
+	var createdAt int64
+
+	err := r.db.QueryRowContext(ctx, query, id, userID).Scan(&session.ID, &session.UserID, &session.Fingerprint, &createdAt, &session.Revoked, &session.SecondFactor)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Session{}, fmt.Errorf("session with id %d %w", id, models.ErrNotFound)
+		}
+		return models.Session{}, fmt.Errorf("error executing query: %w", err)
+	}
+	session.CreatedAt = time.Unix(createdAt, 0)
+
+	return session, nil
+}
+
+func (r *Repository) RevokeSession(ctx context.Context, userID int64, id int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE sessions SET revoked = TRUE WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("session with id %d %w", id, models.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *Repository) AddRefreshToken(ctx context.Context, userID int64, token models.RefreshToken) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO refresh_tokens (token_hash, session_id, user_id, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := r.db.ExecContext(ctx, query, token.Hash, token.SessionID, userID, token.CreatedAt.Unix(), token.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT token_hash, session_id, user_id, created_at, expires_at, used FROM refresh_tokens
+	WHERE token_hash = $1`
+
+	var token models.RefreshToken
+	var createdAt, expiresAt int64
+
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(&token.Hash, &token.SessionID, &token.UserID, &createdAt, &expiresAt, &token.Used)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.RefreshToken{}, fmt.Errorf("refresh token %w", models.ErrNotFound)
+		}
+		return models.RefreshToken{}, fmt.Errorf("error executing query: %w", err)
+	}
+	token.CreatedAt = time.Unix(createdAt, 0)
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return token, nil
+}
+
+// Условный UPDATE не дает двум параллельным запросам обменять один токен дважды
+func (r *Repository) UseRefreshToken(ctx context.Context, hash string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE refresh_tokens SET used = TRUE WHERE token_hash = $1 AND NOT used`, hash)
+	if err != nil {
+		return fmt.Errorf("failed to mark refresh token as used: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("refresh token is already used: %w", models.ErrConflict)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteRefreshTokensBefore(ctx context.Context, before time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < $1`, before.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) CompleteSecondFactor(ctx context.Context, userID int64, id int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE sessions SET second_factor = TRUE WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("session with id %d %w", id, models.ErrNotFound)
+	}
+
+	return nil
+}
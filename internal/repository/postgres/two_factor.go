@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) SaveTwoFactor(ctx context.Context, userID int64, tf models.TwoFactor) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO two_factor (user_id, secret, enabled, last_step, created_at) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, enabled = excluded.enabled,
+		last_step = excluded.last_step, created_at = excluded.created_at`
+
+	_, err := r.db.ExecContext(ctx, query, userID, tf.Secret, tf.Enabled, tf.LastStep, tf.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save two-factor settings: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetTwoFactor(ctx context.Context, userID int64) (models.TwoFactor, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT user_id, secret, enabled, last_step, created_at FROM two_factor WHERE user_id = $1`
+
+	var tf models.TwoFactor
+	var createdAt int64
+
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&tf.UserID, &tf.Secret, &tf.Enabled, &tf.LastStep, &createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.TwoFactor{}, fmt.Errorf("two-factor settings %w", models.ErrNotFound)
+		}
+		return models.TwoFactor{}, fmt.Errorf("error executing query: %w", err)
+	}
+	tf.CreatedAt = time.Unix(createdAt, 0)
+
+	return tf, nil
+}
+
+func (r *Repository) DeleteTwoFactor(ctx context.Context, userID int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM two_factor WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete two-factor settings: %w", err)
+	}
+
+	return nil
+}
+
+// Условный UPDATE не дает принять один код дважды параллельными запросами
+func (r *Repository) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE two_factor SET last_step = $1 WHERE user_id = $2 AND last_step < $3`, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to update two-factor settings: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("two-factor code is already used: %w", models.ErrConflict)
+	}
+
+	return nil
+}
+
+func (r *Repository) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string, createdAt time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, hash := range hashes {
+		_, err = r.db.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)`,
+			userID, hash, createdAt.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) UseRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE recovery_codes SET used = TRUE WHERE user_id = $1 AND code_hash = $2 AND used = FALSE`, userID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to mark recovery code as used: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("recovery code %w", models.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *Repository) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used = FALSE`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error executing query: %w", err)
+	}
+
+	return count, nil
+}
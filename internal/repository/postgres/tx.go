@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Выполняет fn в транзакции: если fn вернула ошибку, все изменения откатываются.
+// Вызов внутри уже открытой транзакции создает точку сохранения (SAVEPOINT),
+// и ошибка откатывает только изменения, сделанные внутри нее
+func (r *Repository) InTx(ctx context.Context, fn func(tx *Repository) error) error {
+	if r.depth > 0 {
+		return r.inSavepoint(ctx, fn)
+	}
+
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	err = runTx(tx.Rollback, func() error {
+		return fn(&Repository{db: tx, conn: r.conn, depth: 1, timeout: r.timeout})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) inSavepoint(ctx context.Context, fn func(tx *Repository) error) error {
+	name := fmt.Sprintf("sp_%d", r.depth)
+
+	_, err := r.db.ExecContext(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+
+	rollback := func() error {
+		_, err := r.db.ExecContext(ctx, "ROLLBACK TO "+name)
+		if err != nil {
+			return err
+		}
+		_, err = r.db.ExecContext(ctx, "RELEASE "+name)
+		return err
+	}
+
+	err = runTx(rollback, func() error {
+		return fn(&Repository{db: r.db, conn: r.conn, depth: r.depth + 1, timeout: r.timeout})
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, "RELEASE "+name)
+	if err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+
+	return nil
+}
+
+// Вызывает fn и откатывает изменения, если она вернула ошибку или запаниковала
+func runTx(rollback func() error, fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn()
+	if err != nil {
+		if rbErr := rollback(); rbErr != nil && rbErr != sql.ErrTxDone {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	return nil
+}
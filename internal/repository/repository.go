@@ -1,33 +1,249 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
 	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository/memory"
 	"github.com/Oxygenss/yandex_final_project/internal/repository/migrations"
+	"github.com/Oxygenss/yandex_final_project/internal/repository/postgres"
 	"github.com/Oxygenss/yandex_final_project/internal/repository/sqlite"
 )
 
+// Пользователю доступны его личные задачи и задачи списков, приглашение в которые он принял.
+// Роль в списке здесь не проверяется, это делают сервисы. userID в AddTask становится
+// автором задачи, в EditTask и RestoreTask - ее последним редактором
+type Task interface {
+	AddTask(ctx context.Context, userID int64, task models.Task) (int64, error)
+	// Восстанавливает задачу с прежними id, списком и автором
+	RestoreTask(ctx context.Context, userID int64, task models.Task) error
+	GetTaskByID(ctx context.Context, userID int64, id string) (models.Task, error)
+	// listID 0 - личные задачи пользователя, иначе задачи списка
+	GetTasks(ctx context.Context, userID, listID int64) ([]models.Task, error)
+	// Задачи, в заголовке или комментарии которых есть подстрока search без учета регистра
+	// (в том числе не латинского), в порядке даты. % и _ в search - обычные символы
+	SearchTasksByString(ctx context.Context, userID, listID int64, search string) ([]models.Task, error)
+	SearchTasksByDate(ctx context.Context, userID, listID int64, date string) ([]models.Task, error)
+	EditTask(ctx context.Context, userID int64, task models.Task) error
+	DeleteByID(ctx context.Context, userID int64, id string, version int64) error
+}
+
+type List interface {
+	AddList(ctx context.Context, list models.List) (int64, error)
+	GetList(ctx context.Context, id int64) (models.List, error)
+	// Списки, в которых пользователь участвует или куда приглашен, с его ролью
+	GetLists(ctx context.Context, userID int64) ([]models.List, error)
+	RenameList(ctx context.Context, id int64, name string) error
+	// Удаляет список вместе с его задачами и участниками
+	DeleteList(ctx context.Context, id int64) error
+	// Пользователь состоит в списке один раз: повторное добавление возвращает ErrConflict
+	AddListMember(ctx context.Context, member models.ListMember) error
+	GetListMember(ctx context.Context, listID, userID int64) (models.ListMember, error)
+	// Участники и приглашенные в порядке добавления
+	GetListMembers(ctx context.Context, listID int64) ([]models.ListMember, error)
+	// Меняет роль участника и отметку о непринятом приглашении
+	UpdateListMember(ctx context.Context, member models.ListMember) error
+	DeleteListMember(ctx context.Context, listID, userID int64) error
+}
+
+type Journal interface {
+	AddOperation(ctx context.Context, userID int64, op models.Operation) (int64, error)
+	GetOperation(ctx context.Context, userID int64, id string) (models.Operation, error)
+	MarkOperationUndone(ctx context.Context, userID int64, id string) error
+	DeleteOperationsBefore(ctx context.Context, before time.Time) error
+}
+
+type Revision interface {
+	AddRevision(ctx context.Context, userID int64, rev models.Revision) (int64, error)
+	GetRevisions(ctx context.Context, userID int64, taskID string) ([]models.Revision, error)
+	GetRevision(ctx context.Context, userID int64, taskID string, revision int64) (models.Revision, error)
+}
+
+type Idempotency interface {
+	// Сохраняет новый ключ. Если ключ уже есть, возвращает существующую запись и false.
+	// Незавершенная запись того же запроса, созданная раньше staleBefore, считается брошенной:
+	// ключ переходит к новому запросу, как если бы его не было
+	ReserveIdempotencyKey(ctx context.Context, userID int64, rec models.IdempotencyRecord, staleBefore time.Time) (models.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, userID int64, rec models.IdempotencyRecord) error
+	DeleteIdempotencyKey(ctx context.Context, userID int64, key, scope string) error
+	DeleteIdempotencyKeysBefore(ctx context.Context, before time.Time) error
+}
+
+type User interface {
+	// Логин уникален: повторная регистрация возвращает ErrConflict
+	AddUser(ctx context.Context, user models.User) (int64, error)
+	GetUserByID(ctx context.Context, id int64) (models.User, error)
+	GetUserByLogin(ctx context.Context, login string) (models.User, error)
+	UpdateUserPassword(ctx context.Context, id int64, passwordHash string) error
+}
+
+type Identity interface {
+	// Пользователь, за которым закреплена учетная запись провайдера
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (models.User, error)
+	// Учетная запись провайдера закрепляется за одним пользователем: повторная привязка возвращает ErrConflict
+	AddIdentity(ctx context.Context, userID int64, identity models.Identity) error
+}
+
+type APIToken interface {
+	AddAPIToken(ctx context.Context, userID int64, token models.APIToken) (int64, error)
+	GetAPITokens(ctx context.Context, userID int64) ([]models.APIToken, error)
+	// Ищет токен среди токенов всех пользователей, чтобы узнать его владельца
+	GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error)
+	DeleteAPIToken(ctx context.Context, userID int64, id string) error
+}
+
+type Session interface {
+	AddSession(ctx context.Context, userID int64, session models.Session) (int64, error)
+	GetSession(ctx context.Context, userID int64, id int64) (models.Session, error)
+	// Отзыв уже отозванного сеанса не считается ошибкой
+	RevokeSession(ctx context.Context, userID int64, id int64) error
+	AddRefreshToken(ctx context.Context, userID int64, token models.RefreshToken) error
+	// Ищет токен среди токенов всех пользователей, чтобы узнать его сеанс
+	GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error)
+	// Помечает токен использованным. Если он уже использован, возвращает ErrConflict
+	UseRefreshToken(ctx context.Context, hash string) error
+	DeleteRefreshTokensBefore(ctx context.Context, before time.Time) error
+	// Отмечает, что в сеансе проверен второй фактор
+	CompleteSecondFactor(ctx context.Context, userID int64, id int64) error
+}
+
+type TwoFactor interface {
+	// Сохраняет настройки пользователя, заменяя прежние
+	SaveTwoFactor(ctx context.Context, userID int64, tf models.TwoFactor) error
+	GetTwoFactor(ctx context.Context, userID int64) (models.TwoFactor, error)
+	DeleteTwoFactor(ctx context.Context, userID int64) error
+	// Запоминает принятый интервал TOTP. Если он не позже последнего принятого, возвращает ErrConflict
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	// Заменяет все коды восстановления пользователя новыми
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string, createdAt time.Time) error
+	// Помечает код использованным. Неизвестный или уже использованный код - ErrNotFound
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) error
+	// Количество неиспользованных кодов
+	CountRecoveryCodes(ctx context.Context, userID int64) (int, error)
+}
+
+// Журнал аудита только пополняется: изменить или удалить запись нельзя
+type Audit interface {
+	AddAuditEvent(ctx context.Context, event models.AuditEvent) (int64, error)
+	// Записи от новых к старым, не больше filter.Limit (0 - без ограничения).
+	// Login берется у пользователя UserID, а если его нет - из самой записи
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+}
+
+// Задачи, журнал, история, ключи идемпотентности, токены, сеансы и настройки входа принадлежат
+// пользователю userID: записи других пользователей для него не существуют. Исключение -
+// задачи общих списков, доступные всем их участникам
 type Repository interface {
-	AddTask(task models.Task) (int64, error)
-	GetTaskByID(id string) (models.Task, error)
-	GetTasks() ([]models.Task, error)
-	SearchTasksByString(search string) ([]models.Task, error)
-	SearchTasksByDate(date string) ([]models.Task, error)
-	EditTask(task models.Task) error
-	DeleteByID(id string) error
+	User
+	Identity
+	APIToken
+	Session
+	TwoFactor
+	Task
+	List
+	Journal
+	Revision
+	Idempotency
+	Audit
+	// Выполняет fn в транзакции, вложенный вызов создает точку сохранения
+	InTx(ctx context.Context, fn func(tx Repository) error) error
 }
 
-func New(pathDB string) (Repository, error) {
-	db, err := sqlite.NewSQLiteDB(pathDB)
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+// Путь к базе SQLite, вместо которой используется хранилище в памяти
+const MemoryPath = ":memory:"
+
+// Открывает базу выбранного в конфиге драйвера и приводит ее схему к последней версии.
+// Для драйвера memory и пути :memory: возвращает пустое хранилище в памяти
+func New(cfg config.Database) (Repository, error) {
+	if isMemory(cfg) {
+		return memoryRepository{memory.New()}, nil
+	}
+
+	db, set, err := Open(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	err = migrations.Migrations(db, pathDB)
+	err = set.Migrate(db)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	repo := sqlite.New(db)
+	switch cfg.Driver {
+	case DriverPostgres:
+		return postgresRepository{postgres.New(db, cfg.QueryTimeout)}, nil
+	default:
+		return sqliteRepository{sqlite.New(db, cfg.QueryTimeout)}, nil
+	}
+}
+
+// Открывает базу выбранного драйвера без миграций и возвращает ее вместе с миграциями этого драйвера
+func Open(cfg config.Database) (*sql.DB, migrations.Set, error) {
+	if isMemory(cfg) {
+		return nil, migrations.Set{}, fmt.Errorf("in-memory storage has no database schema")
+	}
+
+	switch cfg.Driver {
+	case DriverSQLite, "":
+		if cfg.Path == "" {
+			return nil, migrations.Set{}, fmt.Errorf("database path is required for driver %s", DriverSQLite)
+		}
+		db, err := sqlite.NewSQLiteDB(cfg.Path)
+		return db, migrations.SQLite, err
+	case DriverPostgres:
+		if cfg.DSN == "" {
+			return nil, migrations.Set{}, fmt.Errorf("database dsn is required for driver %s", DriverPostgres)
+		}
+		db, err := postgres.NewPostgresDB(cfg.DSN)
+		return db, migrations.Postgres, err
+	default:
+		return nil, migrations.Set{}, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+}
+
+func isMemory(cfg config.Database) bool {
+	return cfg.Driver == DriverMemory || (cfg.Driver == DriverSQLite || cfg.Driver == "") && cfg.Path == MemoryPath
+}
+
+// sqlite, postgres и memory не могут импортировать этот пакет, поэтому их InTx принимает
+// функцию от своего *Repository, а здесь она приводится к Repository
+type sqliteRepository struct {
+	*sqlite.Repository
+}
+
+func (r sqliteRepository) InTx(ctx context.Context, fn func(tx Repository) error) error {
+	return r.Repository.InTx(ctx, func(tx *sqlite.Repository) error {
+		return fn(sqliteRepository{tx})
+	})
+}
+
+type postgresRepository struct {
+	*postgres.Repository
+}
+
+func (r postgresRepository) InTx(ctx context.Context, fn func(tx Repository) error) error {
+	return r.Repository.InTx(ctx, func(tx *postgres.Repository) error {
+		return fn(postgresRepository{tx})
+	})
+}
+
+type memoryRepository struct {
+	*memory.Repository
+}
 
-	return repo, nil
+func (r memoryRepository) InTx(ctx context.Context, fn func(tx Repository) error) error {
+	return r.Repository.InTx(ctx, func(tx *memory.Repository) error {
+		return fn(memoryRepository{tx})
+	})
 }
@@ -0,0 +1,1053 @@
+// Общий набор тестов поведения repository.Repository. Каждая реализация
+// репозитория должна проходить его целиком
+package repotest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+// Владелец задач в тестах - администратор, которого создает миграция схемы
+const userID int64 = 1
+
+// Возвращает пустой репозиторий. Вызывается для каждого подтеста
+type Factory func(t *testing.T) repository.Repository
+
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo repository.Repository)
+	}{
+		{"AddAndGetTask", testAddAndGetTask},
+		{"GetTaskNotFound", testGetTaskNotFound},
+		{"GetTasksEmpty", testGetTasksEmpty},
+		{"GetTasksOrder", testGetTasksOrder},
+		{"SearchTasksByString", testSearchTasksByString},
+		{"SearchTasksByDate", testSearchTasksByDate},
+		{"EditTask", testEditTask},
+		{"EditTaskVersionMismatch", testEditTaskVersionMismatch},
+		{"DeleteTask", testDeleteTask},
+		{"RestoreTask", testRestoreTask},
+		{"TxRollback", testTxRollback},
+		{"SavepointRollback", testSavepointRollback},
+		{"Journal", testJournal},
+		{"Revisions", testRevisions},
+		{"Idempotency", testIdempotency},
+		{"Users", testUsers},
+		{"UserIsolation", testUserIsolation},
+		{"Identities", testIdentities},
+		{"APITokens", testAPITokens},
+		{"Sessions", testSessions},
+		{"TwoFactor", testTwoFactor},
+		{"Lists", testLists},
+		{"ListTasks", testListTasks},
+		{"Audit", testAudit},
+		{"ConcurrentAdd", testConcurrentAdd},
+		{"ConcurrentReadModifyWrite", testConcurrentReadModifyWrite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func addTask(t *testing.T, repo repository.Repository, task models.Task) models.Task {
+	t.Helper()
+
+	id, err := repo.AddTask(context.Background(), userID, task)
+	require.NoError(t, err)
+
+	saved, err := repo.GetTaskByID(context.Background(), userID, idString(id))
+	require.NoError(t, err)
+
+	return saved
+}
+
+func testAddAndGetTask(t *testing.T, repo repository.Repository) {
+	task := addTask(t, repo, models.Task{Date: "20240101", Title: "Title", Comment: "Comment", Repeat: "d 5"})
+
+	assert.NotEmpty(t, task.ID)
+	assert.Equal(t, "20240101", task.Date)
+	assert.Equal(t, "Title", task.Title)
+	assert.Equal(t, "Comment", task.Comment)
+	assert.Equal(t, "d 5", task.Repeat)
+	assert.Equal(t, int64(1), task.Version)
+}
+
+func testGetTaskNotFound(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	_, err := repo.GetTaskByID(ctx, userID, "100500")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = repo.GetTaskByID(ctx, userID, "abc")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func testGetTasksEmpty(t *testing.T, repo repository.Repository) {
+	tasks, err := repo.GetTasks(context.Background(), userID, 0)
+	require.NoError(t, err)
+	assert.NotNil(t, tasks)
+	assert.Empty(t, tasks)
+}
+
+func testGetTasksOrder(t *testing.T, repo repository.Repository) {
+	third := addTask(t, repo, models.Task{Date: "20240301", Title: "Third"})
+	first := addTask(t, repo, models.Task{Date: "20240101", Title: "First"})
+	second := addTask(t, repo, models.Task{Date: "20240201", Title: "Second"})
+
+	tasks, err := repo.GetTasks(context.Background(), userID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{third, first, second}, tasks, "задачи возвращаются в порядке добавления")
+}
+
+func testSearchTasksByString(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	late := addTask(t, repo, models.Task{Date: "20240301", Title: "Pool", Comment: "with coach", Repeat: "d 7"})
+	early := addTask(t, repo, models.Task{Date: "20240101", Title: "Call", Comment: "ask about the POOL"})
+	sameDate := addTask(t, repo, models.Task{Date: "20240101", Title: "Pool again"})
+	addTask(t, repo, models.Task{Date: "20240201", Title: "Movie", Comment: "popcorn"})
+
+	tasks, err := repo.SearchTasksByString(ctx, userID, 0, "pool")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{early, sameDate, late}, tasks,
+		"поиск идет по заголовку и комментарию без учета регистра, результаты по дате, затем по id")
+
+	tasks, err = repo.SearchTasksByString(ctx, userID, 0, "coach")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{late}, tasks)
+
+	tasks, err = repo.SearchTasksByString(ctx, userID, 0, "nothing")
+	require.NoError(t, err)
+	assert.NotNil(t, tasks)
+	assert.Empty(t, tasks)
+
+	cyrillic := addTask(t, repo, models.Task{Date: "20240401", Title: "Задача", Comment: "Купить 100% сок"})
+
+	tasks, err = repo.SearchTasksByString(ctx, userID, 0, "задача")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{cyrillic}, tasks, "регистр не учитывается и для кириллицы")
+
+	tasks, err = repo.SearchTasksByString(ctx, userID, 0, "СОК")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{cyrillic}, tasks)
+
+	tasks, err = repo.SearchTasksByString(ctx, userID, 0, "0%")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{cyrillic}, tasks, "% ищется как обычный символ")
+
+	tasks, err = repo.SearchTasksByString(ctx, userID, 0, "p_ol")
+	require.NoError(t, err)
+	assert.Empty(t, tasks, "_ ищется как обычный символ")
+}
+
+func testSearchTasksByDate(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	first := addTask(t, repo, models.Task{Date: "20240115", Title: "First", Comment: "Comment", Repeat: "y"})
+	addTask(t, repo, models.Task{Date: "20240116", Title: "Other"})
+	second := addTask(t, repo, models.Task{Date: "20240115", Title: "Second"})
+
+	tasks, err := repo.SearchTasksByDate(ctx, userID, 0, "20240115")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{first, second}, tasks)
+
+	tasks, err = repo.SearchTasksByDate(ctx, userID, 0, "20240117")
+	require.NoError(t, err)
+	assert.NotNil(t, tasks)
+	assert.Empty(t, tasks)
+}
+
+func testEditTask(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	task := addTask(t, repo, models.Task{Date: "20240101", Title: "Title"})
+
+	task.Title = "New title"
+	task.Date = "20240202"
+	require.NoError(t, repo.EditTask(ctx, userID, task))
+
+	saved, err := repo.GetTaskByID(ctx, userID, task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "New title", saved.Title)
+	assert.Equal(t, "20240202", saved.Date)
+	assert.Equal(t, task.Version+1, saved.Version)
+
+	// Версия 0 - изменение без проверки версии
+	saved.Version = 0
+	saved.Comment = "Comment"
+	require.NoError(t, repo.EditTask(ctx, userID, saved))
+
+	missing := models.Task{ID: "100500", Date: "20240101", Title: "Title"}
+	assert.ErrorIs(t, repo.EditTask(ctx, userID, missing), models.ErrNotFound)
+}
+
+func testEditTaskVersionMismatch(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	task := addTask(t, repo, models.Task{Date: "20240101", Title: "Title"})
+
+	stale := task
+	task.Title = "First"
+	require.NoError(t, repo.EditTask(ctx, userID, task))
+
+	stale.Title = "Second"
+	assert.ErrorIs(t, repo.EditTask(ctx, userID, stale), models.ErrPreconditionFailed)
+	assert.ErrorIs(t, repo.DeleteByID(ctx, userID, stale.ID, stale.Version), models.ErrPreconditionFailed)
+
+	saved, err := repo.GetTaskByID(ctx, userID, task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "First", saved.Title)
+}
+
+func testDeleteTask(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	task := addTask(t, repo, models.Task{Date: "20240101", Title: "Title"})
+
+	require.NoError(t, repo.DeleteByID(ctx, userID, task.ID, task.Version))
+
+	_, err := repo.GetTaskByID(ctx, userID, task.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	assert.ErrorIs(t, repo.DeleteByID(ctx, userID, task.ID, 0), models.ErrNotFound)
+}
+
+func testRestoreTask(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	task := addTask(t, repo, models.Task{Date: "20240101", Title: "Title", Repeat: "y"})
+
+	assert.ErrorIs(t, repo.RestoreTask(ctx, userID, task), models.ErrConflict)
+
+	require.NoError(t, repo.DeleteByID(ctx, userID, task.ID, 0))
+	require.NoError(t, repo.RestoreTask(ctx, userID, task))
+
+	restored, err := repo.GetTaskByID(ctx, userID, task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task.Title, restored.Title)
+	assert.Equal(t, task.Repeat, restored.Repeat)
+}
+
+var errRollback = errors.New("rollback")
+
+func testTxRollback(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	var id int64
+	err := repo.InTx(ctx, func(tx repository.Repository) error {
+		var err error
+		id, err = tx.AddTask(ctx, userID, models.Task{Date: "20240101", Title: "Title"})
+		require.NoError(t, err)
+
+		_, err = tx.GetTaskByID(ctx, userID, idString(id))
+		require.NoError(t, err)
+
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	_, err = repo.GetTaskByID(ctx, userID, idString(id))
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	err = repo.InTx(ctx, func(tx repository.Repository) error {
+		id, err = tx.AddTask(ctx, userID, models.Task{Date: "20240101", Title: "Title"})
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = repo.GetTaskByID(ctx, userID, idString(id))
+	assert.NoError(t, err)
+}
+
+func testSavepointRollback(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	var kept, dropped int64
+	err := repo.InTx(ctx, func(tx repository.Repository) error {
+		var err error
+		kept, err = tx.AddTask(ctx, userID, models.Task{Date: "20240101", Title: "Kept"})
+		require.NoError(t, err)
+
+		err = tx.InTx(ctx, func(sp repository.Repository) error {
+			dropped, err = sp.AddTask(ctx, userID, models.Task{Date: "20240101", Title: "Dropped"})
+			require.NoError(t, err)
+			return errRollback
+		})
+		assert.ErrorIs(t, err, errRollback)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = repo.GetTaskByID(ctx, userID, idString(kept))
+	assert.NoError(t, err)
+
+	_, err = repo.GetTaskByID(ctx, userID, idString(dropped))
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func testJournal(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	task := addTask(t, repo, models.Task{Date: "20240101", Title: "Title"})
+	now := time.Now().Truncate(time.Second)
+
+	opID, err := repo.AddOperation(ctx, userID, models.Operation{
+		Kind:      models.OperationEdit,
+		TaskID:    task.ID,
+		Snapshot:  task,
+		Version:   task.Version + 1,
+		CreatedAt: now,
+	})
+	require.NoError(t, err)
+
+	op, err := repo.GetOperation(ctx, userID, idString(opID))
+	require.NoError(t, err)
+	assert.Equal(t, models.OperationEdit, op.Kind)
+	assert.Equal(t, task.ID, op.TaskID)
+	// Версия хранится отдельно от снимка: по ней отмена проверяет, что задачу не меняли после операции
+	assert.Equal(t, task.Version+1, op.Version)
+	assert.Equal(t, task.Title, op.Snapshot.Title)
+	assert.Equal(t, task.Date, op.Snapshot.Date)
+	assert.True(t, now.Equal(op.CreatedAt))
+	assert.False(t, op.Undone)
+
+	require.NoError(t, repo.MarkOperationUndone(ctx, userID, idString(opID)))
+	assert.ErrorIs(t, repo.MarkOperationUndone(ctx, userID, idString(opID)), models.ErrConflict)
+
+	op, err = repo.GetOperation(ctx, userID, idString(opID))
+	require.NoError(t, err)
+	assert.True(t, op.Undone)
+
+	require.NoError(t, repo.DeleteOperationsBefore(ctx, now.Add(time.Second)))
+	_, err = repo.GetOperation(ctx, userID, idString(opID))
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = repo.GetOperation(ctx, userID, "abc")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func testRevisions(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	first := addTask(t, repo, models.Task{Date: "20240101", Title: "First"})
+	second := addTask(t, repo, models.Task{Date: "20240101", Title: "Second"})
+
+	for i := 1; i <= 3; i++ {
+		after := first
+		after.Title = first.Title + " edited"
+
+		revision, err := repo.AddRevision(ctx, userID, models.Revision{
+			TaskID:    first.ID,
+			Before:    first,
+			After:     after,
+			CreatedAt: time.Now(),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(i), revision)
+	}
+
+	revision, err := repo.AddRevision(ctx, userID, models.Revision{TaskID: second.ID, Before: second, After: second, CreatedAt: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), revision, "ревизии нумеруются отдельно для каждой задачи")
+
+	revisions, err := repo.GetRevisions(ctx, userID, first.ID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	for i, rev := range revisions {
+		assert.Equal(t, int64(i+1), rev.Revision)
+		assert.Equal(t, first.Title, rev.Before.Title)
+		assert.Equal(t, first.Title+" edited", rev.After.Title)
+	}
+
+	rev, err := repo.GetRevision(ctx, userID, first.ID, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rev.Revision)
+	assert.Equal(t, first.ID, rev.TaskID)
+
+	_, err = repo.GetRevision(ctx, userID, first.ID, 10)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	revisions, err = repo.GetRevisions(ctx, userID, "100500")
+	require.NoError(t, err)
+	assert.Empty(t, revisions)
+}
+
+func testIdempotency(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	now := time.Now()
+	rec := models.IdempotencyRecord{Key: "key", Scope: "add", RequestHash: "hash", CreatedAt: now}
+
+	_, created, err := repo.ReserveIdempotencyKey(ctx, userID, rec, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	existing, created, err := repo.ReserveIdempotencyKey(ctx, userID, models.IdempotencyRecord{
+		Key: "key", Scope: "add", RequestHash: "other", CreatedAt: now,
+	}, time.Time{})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "hash", existing.RequestHash)
+	assert.Equal(t, 0, existing.Status)
+
+	// Тот же ключ в другой области - отдельная запись
+	_, created, err = repo.ReserveIdempotencyKey(ctx, userID, models.IdempotencyRecord{
+		Key: "key", Scope: "done", RequestHash: "hash", CreatedAt: now,
+	}, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	rec.Status = 201
+	rec.Headers = map[string]string{"Content-Type": "application/json"}
+	rec.Body = []byte(`{"id":"1"}`)
+	require.NoError(t, repo.CompleteIdempotencyKey(ctx, userID, rec))
+
+	existing, _, err = repo.ReserveIdempotencyKey(ctx, userID, rec, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 201, existing.Status)
+	assert.Equal(t, rec.Headers, existing.Headers)
+	assert.Equal(t, rec.Body, existing.Body)
+
+	require.NoError(t, repo.DeleteIdempotencyKey(ctx, userID, "key", "add"))
+	_, created, err = repo.ReserveIdempotencyKey(ctx, userID, rec, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	require.NoError(t, repo.DeleteIdempotencyKeysBefore(ctx, now.Add(time.Hour)))
+	_, created, err = repo.ReserveIdempotencyKey(ctx, userID, rec, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	// Брошенную незавершенную запись забирает только тот же запрос
+	stale := now.Add(time.Minute)
+	retry := rec
+	retry.CreatedAt = stale
+	retry.RequestHash = "other"
+	existing, created, err = repo.ReserveIdempotencyKey(ctx, userID, retry, stale)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "hash", existing.RequestHash)
+
+	retry.RequestHash = rec.RequestHash
+	_, created, err = repo.ReserveIdempotencyKey(ctx, userID, retry, now)
+	require.NoError(t, err)
+	assert.False(t, created, "запись еще не устарела")
+
+	_, created, err = repo.ReserveIdempotencyKey(ctx, userID, retry, stale)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	_, created, err = repo.ReserveIdempotencyKey(ctx, userID, retry, stale)
+	require.NoError(t, err)
+	assert.False(t, created, "забранная запись начинает новую аренду")
+
+	// Завершенная запись не устаревает до конца окна
+	retry.Status = 201
+	require.NoError(t, repo.CompleteIdempotencyKey(ctx, userID, retry))
+	existing, created, err = repo.ReserveIdempotencyKey(ctx, userID, retry, stale.Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, 201, existing.Status)
+}
+
+func testUsers(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	admin, err := repo.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", admin.Login)
+
+	now := time.Now().Truncate(time.Second)
+	id, err := repo.AddUser(ctx, models.User{Login: "alice", PasswordHash: "hash", CreatedAt: now})
+	require.NoError(t, err)
+	assert.NotEqual(t, userID, id)
+
+	_, err = repo.AddUser(ctx, models.User{Login: "alice", PasswordHash: "other", CreatedAt: now})
+	assert.ErrorIs(t, err, models.ErrConflict)
+
+	user, err := repo.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, "hash", user.PasswordHash)
+	assert.True(t, now.Equal(user.CreatedAt))
+
+	require.NoError(t, repo.UpdateUserPassword(ctx, id, "new hash"))
+	user, err = repo.GetUserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "new hash", user.PasswordHash)
+
+	_, err = repo.GetUserByLogin(ctx, "bob")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = repo.GetUserByID(ctx, 100500)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.ErrorIs(t, repo.UpdateUserPassword(ctx, 100500, "hash"), models.ErrNotFound)
+}
+
+// Пользователь не видит и не может изменить чужие задачи, операции, ревизии и ключи
+func testUserIsolation(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	task := addTask(t, repo, models.Task{Date: "20240101", Title: "Secret", Comment: "secret"})
+
+	other, err := repo.AddUser(ctx, models.User{Login: "other", PasswordHash: "hash", CreatedAt: time.Now()})
+	require.NoError(t, err)
+
+	_, err = repo.GetTaskByID(ctx, other, task.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	tasks, err := repo.GetTasks(ctx, other, 0)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	tasks, err = repo.SearchTasksByString(ctx, other, 0, "secret")
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	tasks, err = repo.SearchTasksByDate(ctx, other, 0, task.Date)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	edited := task
+	edited.Title = "Stolen"
+	assert.ErrorIs(t, repo.EditTask(ctx, other, edited), models.ErrNotFound)
+	assert.ErrorIs(t, repo.DeleteByID(ctx, other, task.ID, 0), models.ErrNotFound)
+	assert.Error(t, repo.RestoreTask(ctx, other, task), "id задачи занят задачей другого пользователя")
+
+	saved, err := repo.GetTaskByID(ctx, userID, task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, task, saved)
+
+	opID, err := repo.AddOperation(ctx, userID, models.Operation{
+		Kind: models.OperationEdit, TaskID: task.ID, Snapshot: task, CreatedAt: time.Now(),
+	})
+	require.NoError(t, err)
+	_, err = repo.GetOperation(ctx, other, idString(opID))
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.Error(t, repo.MarkOperationUndone(ctx, other, idString(opID)))
+	op, err := repo.GetOperation(ctx, userID, idString(opID))
+	require.NoError(t, err)
+	assert.False(t, op.Undone)
+
+	_, err = repo.AddRevision(ctx, userID, models.Revision{TaskID: task.ID, Before: task, After: task, CreatedAt: time.Now()})
+	require.NoError(t, err)
+	revisions, err := repo.GetRevisions(ctx, other, task.ID)
+	require.NoError(t, err)
+	assert.Empty(t, revisions)
+	_, err = repo.GetRevision(ctx, other, task.ID, 1)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	rec := models.IdempotencyRecord{Key: "key", Scope: "add", RequestHash: "hash", CreatedAt: time.Now()}
+	_, created, err := repo.ReserveIdempotencyKey(ctx, userID, rec, time.Time{})
+	require.NoError(t, err)
+	require.True(t, created)
+
+	rec.RequestHash = "other"
+	existing, created, err := repo.ReserveIdempotencyKey(ctx, other, rec, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, created, "ключи разных пользователей не пересекаются")
+	assert.Equal(t, "other", existing.RequestHash)
+}
+
+func testIdentities(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	alice, err := repo.AddUser(ctx, models.User{Login: "alice", PasswordHash: "hash", CreatedAt: now})
+	require.NoError(t, err)
+
+	_, err = repo.GetUserByIdentity(ctx, "https://id.example.com", "sub-1")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	identity := models.Identity{Issuer: "https://id.example.com", Subject: "sub-1", CreatedAt: now}
+	require.NoError(t, repo.AddIdentity(ctx, alice, identity))
+	assert.ErrorIs(t, repo.AddIdentity(ctx, userID, identity), models.ErrConflict)
+
+	user, err := repo.GetUserByIdentity(ctx, "https://id.example.com", "sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, alice, user.ID)
+	assert.Equal(t, "alice", user.Login)
+
+	// Субъект уникален только в пределах провайдера
+	_, err = repo.GetUserByIdentity(ctx, "https://other.example.com", "sub-1")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	require.NoError(t, repo.AddIdentity(ctx, userID, models.Identity{Issuer: "https://other.example.com", Subject: "sub-1", CreatedAt: now}))
+
+	user, err = repo.GetUserByIdentity(ctx, "https://other.example.com", "sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, userID, user.ID)
+}
+
+func testAPITokens(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	expiresAt := now.Add(time.Hour)
+
+	other, err := repo.AddUser(ctx, models.User{Login: "other", PasswordHash: "hash", CreatedAt: now})
+	require.NoError(t, err)
+
+	first, err := repo.AddAPIToken(ctx, userID, models.APIToken{
+		Name: "ci", Scopes: []string{models.ScopeTasksRead}, Hash: "hash1", CreatedAt: now,
+	})
+	require.NoError(t, err)
+	second, err := repo.AddAPIToken(ctx, userID, models.APIToken{
+		Name:      "backup",
+		Scopes:    []string{models.ScopeTasksRead, models.ScopeTasksWrite},
+		Hash:      "hash2",
+		CreatedAt: now,
+		ExpiresAt: &expiresAt,
+	})
+	require.NoError(t, err)
+
+	tokens, err := repo.GetAPITokens(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, idString(first), tokens[0].ID)
+	assert.Equal(t, "ci", tokens[0].Name)
+	assert.Equal(t, []string{models.ScopeTasksRead}, tokens[0].Scopes)
+	assert.Nil(t, tokens[0].ExpiresAt)
+	assert.True(t, now.Equal(tokens[0].CreatedAt))
+	assert.Equal(t, idString(second), tokens[1].ID)
+	require.NotNil(t, tokens[1].ExpiresAt)
+	assert.True(t, expiresAt.Equal(*tokens[1].ExpiresAt))
+
+	token, err := repo.GetAPITokenByHash(ctx, "hash2")
+	require.NoError(t, err)
+	assert.Equal(t, idString(second), token.ID)
+	assert.Equal(t, userID, token.UserID)
+	assert.Equal(t, []string{models.ScopeTasksRead, models.ScopeTasksWrite}, token.Scopes)
+
+	_, err = repo.GetAPITokenByHash(ctx, "missing")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	tokens, err = repo.GetAPITokens(ctx, other)
+	require.NoError(t, err)
+	assert.NotNil(t, tokens)
+	assert.Empty(t, tokens)
+	assert.ErrorIs(t, repo.DeleteAPIToken(ctx, other, idString(first)), models.ErrNotFound)
+
+	require.NoError(t, repo.DeleteAPIToken(ctx, userID, idString(first)))
+	assert.ErrorIs(t, repo.DeleteAPIToken(ctx, userID, idString(first)), models.ErrNotFound)
+	assert.ErrorIs(t, repo.DeleteAPIToken(ctx, userID, "abc"), models.ErrNotFound)
+
+	_, err = repo.GetAPITokenByHash(ctx, "hash1")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func testSessions(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	other, err := repo.AddUser(ctx, models.User{Login: "other", PasswordHash: "hash", CreatedAt: now})
+	require.NoError(t, err)
+
+	id, err := repo.AddSession(ctx, userID, models.Session{Fingerprint: "fingerprint", CreatedAt: now})
+	require.NoError(t, err)
+
+	session, err := repo.GetSession(ctx, userID, id)
+	require.NoError(t, err)
+	assert.Equal(t, id, session.ID)
+	assert.Equal(t, userID, session.UserID)
+	assert.Equal(t, "fingerprint", session.Fingerprint)
+	assert.True(t, now.Equal(session.CreatedAt))
+	assert.False(t, session.Revoked)
+	assert.False(t, session.SecondFactor)
+
+	_, err = repo.GetSession(ctx, other, id)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.ErrorIs(t, repo.RevokeSession(ctx, other, id), models.ErrNotFound)
+	assert.ErrorIs(t, repo.CompleteSecondFactor(ctx, other, id), models.ErrNotFound)
+
+	require.NoError(t, repo.CompleteSecondFactor(ctx, userID, id))
+	session, err = repo.GetSession(ctx, userID, id)
+	require.NoError(t, err)
+	assert.True(t, session.SecondFactor)
+
+	verified, err := repo.AddSession(ctx, userID, models.Session{Fingerprint: "fingerprint", CreatedAt: now, SecondFactor: true})
+	require.NoError(t, err)
+	session, err = repo.GetSession(ctx, userID, verified)
+	require.NoError(t, err)
+	assert.True(t, session.SecondFactor)
+
+	require.NoError(t, repo.AddRefreshToken(ctx, userID, models.RefreshToken{
+		Hash: "hash1", SessionID: id, CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+	}))
+	require.NoError(t, repo.AddRefreshToken(ctx, userID, models.RefreshToken{
+		Hash: "hash2", SessionID: id, CreatedAt: now, ExpiresAt: now.Add(-time.Hour),
+	}))
+
+	token, err := repo.GetRefreshToken(ctx, "hash1")
+	require.NoError(t, err)
+	assert.Equal(t, id, token.SessionID)
+	assert.Equal(t, userID, token.UserID)
+	assert.True(t, now.Add(time.Hour).Equal(token.ExpiresAt))
+	assert.False(t, token.Used)
+
+	require.NoError(t, repo.UseRefreshToken(ctx, "hash1"))
+	assert.ErrorIs(t, repo.UseRefreshToken(ctx, "hash1"), models.ErrConflict)
+	token, err = repo.GetRefreshToken(ctx, "hash1")
+	require.NoError(t, err)
+	assert.True(t, token.Used)
+
+	require.NoError(t, repo.DeleteRefreshTokensBefore(ctx, now))
+	_, err = repo.GetRefreshToken(ctx, "hash2")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = repo.GetRefreshToken(ctx, "hash1")
+	assert.NoError(t, err)
+
+	require.NoError(t, repo.RevokeSession(ctx, userID, id))
+	require.NoError(t, repo.RevokeSession(ctx, userID, id))
+	session, err = repo.GetSession(ctx, userID, id)
+	require.NoError(t, err)
+	assert.True(t, session.Revoked)
+}
+
+func testTwoFactor(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	other, err := repo.AddUser(ctx, models.User{Login: "other", PasswordHash: "hash", CreatedAt: now})
+	require.NoError(t, err)
+
+	_, err = repo.GetTwoFactor(ctx, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	require.NoError(t, repo.SaveTwoFactor(ctx, userID, models.TwoFactor{Secret: "pending", CreatedAt: now}))
+	require.NoError(t, repo.SaveTwoFactor(ctx, userID, models.TwoFactor{Secret: "secret", Enabled: true, LastStep: 10, CreatedAt: now}))
+
+	tf, err := repo.GetTwoFactor(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, userID, tf.UserID)
+	assert.Equal(t, "secret", tf.Secret)
+	assert.True(t, tf.Enabled)
+	assert.Equal(t, int64(10), tf.LastStep)
+	assert.True(t, now.Equal(tf.CreatedAt))
+
+	_, err = repo.GetTwoFactor(ctx, other)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	assert.ErrorIs(t, repo.UseTOTPStep(ctx, userID, 10), models.ErrConflict)
+	assert.ErrorIs(t, repo.UseTOTPStep(ctx, userID, 9), models.ErrConflict)
+	require.NoError(t, repo.UseTOTPStep(ctx, userID, 11))
+	assert.ErrorIs(t, repo.UseTOTPStep(ctx, userID, 11), models.ErrConflict)
+	assert.ErrorIs(t, repo.UseTOTPStep(ctx, other, 11), models.ErrConflict)
+
+	require.NoError(t, repo.ReplaceRecoveryCodes(ctx, userID, []string{"old1", "old2"}, now))
+	require.NoError(t, repo.ReplaceRecoveryCodes(ctx, userID, []string{"code1", "code2", "code3"}, now))
+	require.NoError(t, repo.ReplaceRecoveryCodes(ctx, other, []string{"code1"}, now))
+
+	count, err := repo.CountRecoveryCodes(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	assert.ErrorIs(t, repo.UseRecoveryCode(ctx, userID, "old1"), models.ErrNotFound)
+	require.NoError(t, repo.UseRecoveryCode(ctx, userID, "code1"))
+	assert.ErrorIs(t, repo.UseRecoveryCode(ctx, userID, "code1"), models.ErrNotFound)
+
+	count, err = repo.CountRecoveryCodes(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Код другого пользователя не использован
+	count, err = repo.CountRecoveryCodes(ctx, other)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, repo.DeleteTwoFactor(ctx, userID))
+	require.NoError(t, repo.ReplaceRecoveryCodes(ctx, userID, nil, now))
+	_, err = repo.GetTwoFactor(ctx, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	count, err = repo.CountRecoveryCodes(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func testLists(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	other, err := repo.AddUser(ctx, models.User{Login: "other", PasswordHash: "hash", CreatedAt: now})
+	require.NoError(t, err)
+
+	id, err := repo.AddList(ctx, models.List{Name: "Family", CreatedAt: now})
+	require.NoError(t, err)
+
+	list, err := repo.GetList(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.List{ID: id, Name: "Family", CreatedAt: list.CreatedAt}, list)
+	assert.True(t, now.Equal(list.CreatedAt))
+
+	_, err = repo.GetList(ctx, id+100)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	require.NoError(t, repo.AddListMember(ctx, models.ListMember{ListID: id, UserID: userID, Role: models.RoleOwner, CreatedAt: now}))
+	require.NoError(t, repo.AddListMember(ctx, models.ListMember{ListID: id, UserID: other, Role: models.RoleViewer, Pending: true, CreatedAt: now}))
+	assert.ErrorIs(t, repo.AddListMember(ctx, models.ListMember{ListID: id, UserID: other, Role: models.RoleEditor, CreatedAt: now}), models.ErrConflict)
+
+	member, err := repo.GetListMember(ctx, id, other)
+	require.NoError(t, err)
+	assert.Equal(t, "other", member.Login)
+	assert.Equal(t, models.RoleViewer, member.Role)
+	assert.True(t, member.Pending)
+
+	members, err := repo.GetListMembers(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "admin", members[0].Login)
+	assert.Equal(t, models.RoleOwner, members[0].Role)
+	assert.Equal(t, other, members[1].UserID)
+
+	lists, err := repo.GetLists(ctx, other)
+	require.NoError(t, err)
+	require.Len(t, lists, 1)
+	assert.Equal(t, id, lists[0].ID)
+	assert.Equal(t, models.RoleViewer, lists[0].Role)
+	assert.True(t, lists[0].Pending)
+
+	require.NoError(t, repo.UpdateListMember(ctx, models.ListMember{ListID: id, UserID: other, Role: models.RoleEditor}))
+	member, err = repo.GetListMember(ctx, id, other)
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleEditor, member.Role)
+	assert.False(t, member.Pending)
+	assert.ErrorIs(t, repo.UpdateListMember(ctx, models.ListMember{ListID: id + 100, UserID: other, Role: models.RoleEditor}), models.ErrNotFound)
+
+	require.NoError(t, repo.RenameList(ctx, id, "Home"))
+	list, err = repo.GetList(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Home", list.Name)
+	assert.ErrorIs(t, repo.RenameList(ctx, id+100, "Home"), models.ErrNotFound)
+
+	require.NoError(t, repo.DeleteListMember(ctx, id, other))
+	assert.ErrorIs(t, repo.DeleteListMember(ctx, id, other), models.ErrNotFound)
+	lists, err = repo.GetLists(ctx, other)
+	require.NoError(t, err)
+	assert.Empty(t, lists)
+
+	require.NoError(t, repo.DeleteList(ctx, id))
+	_, err = repo.GetList(ctx, id)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	members, err = repo.GetListMembers(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, members)
+	assert.ErrorIs(t, repo.DeleteList(ctx, id), models.ErrNotFound)
+}
+
+// Задачи списка доступны участникам, принявшим приглашение, и не смешиваются с личными
+func testListTasks(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	now := time.Now()
+
+	editor, err := repo.AddUser(ctx, models.User{Login: "editor", PasswordHash: "hash", CreatedAt: now})
+	require.NoError(t, err)
+	invited, err := repo.AddUser(ctx, models.User{Login: "invited", PasswordHash: "hash", CreatedAt: now})
+	require.NoError(t, err)
+
+	listID, err := repo.AddList(ctx, models.List{Name: "Family", CreatedAt: now})
+	require.NoError(t, err)
+	require.NoError(t, repo.AddListMember(ctx, models.ListMember{ListID: listID, UserID: userID, Role: models.RoleOwner, CreatedAt: now}))
+	require.NoError(t, repo.AddListMember(ctx, models.ListMember{ListID: listID, UserID: editor, Role: models.RoleEditor, CreatedAt: now}))
+	require.NoError(t, repo.AddListMember(ctx, models.ListMember{ListID: listID, UserID: invited, Role: models.RoleEditor, Pending: true, CreatedAt: now}))
+
+	personal := addTask(t, repo, models.Task{Date: "20240101", Title: "Personal"})
+	assert.Equal(t, userID, personal.CreatedByID)
+	assert.Equal(t, "admin", personal.CreatedBy)
+	assert.Equal(t, userID, personal.ModifiedByID)
+	assert.Equal(t, "admin", personal.ModifiedBy)
+
+	id, err := repo.AddTask(ctx, userID, models.Task{Date: "20240102", Title: "Shared milk", ListID: listID})
+	require.NoError(t, err)
+
+	task, err := repo.GetTaskByID(ctx, editor, idString(id))
+	require.NoError(t, err)
+	assert.Equal(t, listID, task.ListID)
+
+	_, err = repo.GetTaskByID(ctx, invited, task.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound, "приглашение не принято")
+	_, err = repo.GetTaskByID(ctx, editor, personal.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound, "личная задача другого участника")
+
+	tasks, err := repo.GetTasks(ctx, userID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{personal}, tasks, "задачи списка не попадают в личные")
+
+	tasks, err = repo.GetTasks(ctx, editor, listID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{task}, tasks)
+
+	tasks, err = repo.GetTasks(ctx, invited, listID)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	tasks, err = repo.SearchTasksByString(ctx, editor, listID, "milk")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{task}, tasks)
+
+	tasks, err = repo.SearchTasksByDate(ctx, editor, listID, task.Date)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Task{task}, tasks)
+
+	edited := task
+	edited.Title = "Shared bread"
+	require.NoError(t, repo.EditTask(ctx, editor, edited))
+	assert.ErrorIs(t, repo.EditTask(ctx, invited, edited), models.ErrNotFound)
+
+	saved, err := repo.GetTaskByID(ctx, userID, task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Shared bread", saved.Title)
+	assert.Equal(t, "admin", saved.CreatedBy)
+	assert.Equal(t, editor, saved.ModifiedByID)
+	assert.Equal(t, "editor", saved.ModifiedBy)
+
+	// Восстановленная задача остается в списке и сохраняет автора
+	require.NoError(t, repo.DeleteByID(ctx, editor, task.ID, 0))
+	require.NoError(t, repo.RestoreTask(ctx, editor, saved))
+	restored, err := repo.GetTaskByID(ctx, userID, task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, listID, restored.ListID)
+	assert.Equal(t, saved.CreatedByID, restored.CreatedByID)
+	assert.Equal(t, saved.CreatedBy, restored.CreatedBy)
+	assert.Equal(t, saved.ModifiedBy, restored.ModifiedBy)
+
+	// Участник, покинувший список, теряет доступ к его задачам
+	require.NoError(t, repo.DeleteListMember(ctx, listID, editor))
+	_, err = repo.GetTaskByID(ctx, editor, task.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.ErrorIs(t, repo.DeleteByID(ctx, editor, task.ID, 0), models.ErrNotFound)
+
+	require.NoError(t, repo.DeleteList(ctx, listID))
+	_, err = repo.GetTaskByID(ctx, userID, task.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound, "задачи удаляются вместе со списком")
+
+	_, err = repo.GetTaskByID(ctx, userID, personal.ID)
+	require.NoError(t, err)
+}
+
+// Записи отдаются от новых к старым, фильтры складываются, а откат транзакции
+// отменяет и записи, сделанные в ней
+func testAudit(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	events := []models.AuditEvent{
+		{CreatedAt: now.Add(-time.Hour), Login: "ghost", IP: "10.0.0.1", Action: models.AuditSignIn, Details: "invalid credentials"},
+		{CreatedAt: now.Add(-time.Minute), UserID: userID, IP: "10.0.0.2", Action: models.AuditSignIn, Success: true},
+		{CreatedAt: now, UserID: userID, IP: "10.0.0.2", Action: models.AuditTaskCreate, Target: "7", Success: true},
+	}
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		var err error
+		ids[i], err = repo.AddAuditEvent(ctx, event)
+		require.NoError(t, err)
+	}
+
+	all, err := repo.GetAuditEvents(ctx, models.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, []int64{ids[2], ids[1], ids[0]}, []int64{all[0].ID, all[1].ID, all[2].ID})
+	assert.Equal(t, "7", all[0].Target)
+	assert.True(t, now.Equal(all[0].CreatedAt))
+	assert.Equal(t, "admin", all[1].Login)
+	assert.Equal(t, "ghost", all[2].Login)
+	assert.False(t, all[2].Success)
+	assert.Equal(t, "invalid credentials", all[2].Details)
+
+	failed := false
+	filtered, err := repo.GetAuditEvents(ctx, models.AuditFilter{Action: models.AuditSignIn, Success: &failed})
+	require.NoError(t, err)
+	require.Len(t, filtered, 1)
+	assert.Equal(t, ids[0], filtered[0].ID)
+
+	filtered, err = repo.GetAuditEvents(ctx, models.AuditFilter{UserID: userID, IP: "10.0.0.2", Since: now.Add(-time.Minute), Until: now})
+	require.NoError(t, err)
+	require.Len(t, filtered, 1)
+	assert.Equal(t, ids[1], filtered[0].ID)
+
+	page, err := repo.GetAuditEvents(ctx, models.AuditFilter{BeforeID: ids[2], Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, ids[1], page[0].ID)
+
+	err = repo.InTx(ctx, func(tx repository.Repository) error {
+		_, err := tx.AddAuditEvent(ctx, models.AuditEvent{CreatedAt: now, UserID: userID, Action: models.AuditTaskDelete, Success: true})
+		require.NoError(t, err)
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	all, err = repo.GetAuditEvents(ctx, models.AuditFilter{})
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+func testConcurrentAdd(t *testing.T, repo repository.Repository) {
+	const workers = 20
+
+	ids := make(chan int64, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := repo.AddTask(context.Background(), userID, models.Task{Date: "20240101", Title: "Title"})
+			assert.NoError(t, err)
+			ids <- id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[int64]bool{}
+	for id := range ids {
+		assert.False(t, seen[id], "id %d выдан дважды", id)
+		seen[id] = true
+	}
+
+	tasks, err := repo.GetTasks(context.Background(), userID, 0)
+	require.NoError(t, err)
+	assert.Len(t, tasks, workers)
+}
+
+// Чтение и запись в одной транзакции не должны терять параллельные изменения
+func testConcurrentReadModifyWrite(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	task := addTask(t, repo, models.Task{Date: "20240101", Title: "0"})
+
+	const workers = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.InTx(ctx, func(tx repository.Repository) error {
+				current, err := tx.GetTaskByID(ctx, userID, task.ID)
+				if err != nil {
+					return err
+				}
+
+				n, err := strconv.Atoi(current.Title)
+				if err != nil {
+					return err
+				}
+				current.Title = strconv.Itoa(n + 1)
+
+				return tx.EditTask(ctx, userID, current)
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	saved, err := repo.GetTaskByID(ctx, userID, task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(workers), saved.Title)
+	assert.Equal(t, int64(workers+1), saved.Version)
+}
+
+func idString(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,110 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) ReserveIdempotencyKey(ctx context.Context, userID int64, rec models.IdempotencyRecord, staleBefore time.Time) (models.IdempotencyRecord, bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO idempotency_keys (user_id, key, scope, request_hash, created_at)
+	VALUES (?, ?, ?, ?, ?) ON CONFLICT (user_id, key, scope) DO UPDATE SET created_at = excluded.created_at
+	WHERE idempotency_keys.status = 0 AND idempotency_keys.request_hash = excluded.request_hash
+		AND idempotency_keys.created_at < ?`
+
+	result, err := r.db.ExecContext(ctx, query, userID, rec.Key, rec.Scope, rec.RequestHash, rec.CreatedAt.Unix(), staleBefore.Unix())
+	if err != nil {
+		return models.IdempotencyRecord{}, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return models.IdempotencyRecord{}, false, fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 1 {
+		return rec, true, nil
+	}
+
+	existing, err := r.getIdempotencyKey(ctx, userID, rec.Key, rec.Scope)
+	if err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+
+	return existing, false, nil
+}
+
+func (r *Repository) getIdempotencyKey(ctx context.Context, userID int64, key, scope string) (models.IdempotencyRecord, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT key, scope, request_hash, status, headers, body, created_at
+	FROM idempotency_keys WHERE user_id = ? AND key = ? AND scope = ?`
+
+	var rec models.IdempotencyRecord
+	var headers string
+	var createdAt int64
+
+	err := r.db.QueryRowContext(ctx, query, userID, key, scope).Scan(&rec.Key, &rec.Scope, &rec.RequestHash,
+		&rec.Status, &headers, &rec.Body, &createdAt)
+	if err != nil {
+		return models.IdempotencyRecord{}, fmt.Errorf("error executing query: %w", err)
+	}
+
+	err = json.Unmarshal([]byte(headers), &rec.Headers)
+	if err != nil {
+		return models.IdempotencyRecord{}, fmt.Errorf("failed to decode stored headers: %w", err)
+	}
+	rec.CreatedAt = time.Unix(createdAt, 0)
+
+	return rec, nil
+}
+
+func (r *Repository) CompleteIdempotencyKey(ctx context.Context, userID int64, rec models.IdempotencyRecord) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	headers, err := json.Marshal(rec.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	query := `UPDATE idempotency_keys SET status = ?, headers = ?, body = ? WHERE user_id = ? AND key = ? AND scope = ?`
+
+	_, err = r.db.ExecContext(ctx, query, rec.Status, string(headers), rec.Body, userID, rec.Key, rec.Scope)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteIdempotencyKey(ctx context.Context, userID int64, key, scope string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND scope = ?`, userID, key, scope)
+	if err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteIdempotencyKeysBefore(ctx context.Context, before time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_at < ?`, before.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) GetUserByIdentity(ctx context.Context, issuer, subject string) (models.User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT users.id, users.login, users.password_hash, users.created_at FROM identities
+	JOIN users ON users.id = identities.user_id
+	WHERE identities.issuer = ? AND identities.subject = ?`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, issuer, subject))
+	if err == sql.ErrNoRows {
+		return models.User{}, fmt.Errorf("user with identity %s %w", subject, models.ErrNotFound)
+	}
+
+	return user, err
+}
+
+func (r *Repository) AddIdentity(ctx context.Context, userID int64, identity models.Identity) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)`
+
+	_, err := r.db.ExecContext(ctx, query, identity.Issuer, identity.Subject, userID, identity.CreatedAt.Unix())
+	if err != nil {
+		if isConstraintError(err) {
+			return fmt.Errorf("identity %s is already linked: %w", identity.Subject, models.ErrConflict)
+		}
+		return fmt.Errorf("failed to insert identity: %w", err)
+	}
+
+	return nil
+}
@@ -1,26 +1,67 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/Oxygenss/yandex_final_project/internal/models"
 )
 
+// Общие методы *sql.DB и *sql.Tx, чтобы одни и те же запросы
+// выполнялись как в транзакции, так и без нее
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Repository struct {
-	db *sql.DB
+	db   querier
+	conn *sql.DB
+	// Глубина вложенности транзакций: 0 - вне транзакции,
+	// больше 1 - внутри точки сохранения
+	depth int
+	// Ограничение времени одного обращения к базе, 0 - без ограничения
+	timeout time.Duration
+}
+
+func New(db *sql.DB, timeout time.Duration) *Repository {
+	return &Repository{db: db, conn: db, timeout: timeout}
 }
 
-func New(db *sql.DB) *Repository {
-	return &Repository{db: db}
+// Контекст запроса с ограничением времени. Каждый метод отменяет его при выходе,
+// поэтому все строки результата читаются до возврата из метода
+func (r *Repository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
 }
 
-func (r *Repository) AddTask(task models.Task) (int64, error) {
+// Задача вместе с логинами автора и последнего редактора
+const selectTask = `SELECT scheduler.id, scheduler.date, scheduler.title, scheduler.comment, scheduler.repeat,
+	scheduler.list_id, scheduler.user_id, COALESCE(creator.login, ''), scheduler.modified_by, COALESCE(editor.login, ''),
+	scheduler.version
+	FROM scheduler
+	LEFT JOIN users creator ON creator.id = scheduler.user_id
+	LEFT JOIN users editor ON editor.id = scheduler.modified_by`
+
+// Условие доступности задачи пользователю: личная задача или задача списка, приглашение
+// в который он принял. Оба параметра - id пользователя
+const visibleTask = `(scheduler.list_id = 0 AND scheduler.user_id = ? OR scheduler.list_id IN
+	(SELECT list_id FROM list_members WHERE user_id = ? AND pending = 0))`
 
-	query := `INSERT INTO scheduler (date, title, comment, repeat)
-	VALUES (?, ?, ?, ?)`
+func (r *Repository) AddTask(ctx context.Context, userID int64, task models.Task) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	res, err := r.db.Exec(query, task.Date, task.Title, task.Comment, task.Repeat)
+	query := `INSERT INTO scheduler (user_id, list_id, modified_by, date, title, comment, repeat)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	res, err := r.db.ExecContext(ctx, query, userID, task.ListID, userID, task.Date, task.Title, task.Comment, task.Repeat)
 	if err != nil {
 		return 0, fmt.Errorf("failed to insert task: %w", err)
 	}
@@ -33,16 +74,43 @@ func (r *Repository) AddTask(task models.Task) (int64, error) {
 	return id, nil
 }
 
-func (r *Repository) GetTaskByID(id string) (models.Task, error) {
-	query := "SELECT id, date, title, comment, repeat FROM scheduler WHERE id = ?"
+// Восстанавливает удаленную задачу с ее прежним id
+func (r *Repository) RestoreTask(ctx context.Context, userID int64, task models.Task) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	var task models.Task
+	query := `INSERT INTO scheduler (id, user_id, list_id, modified_by, date, title, comment, repeat)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := r.db.ExecContext(ctx, query, task.ID, taskAuthor(userID, task), task.ListID, userID, task.Date, task.Title, task.Comment, task.Repeat)
+	if err != nil {
+		if isConstraintError(err) {
+			return fmt.Errorf("task with id %s already exists: %w", task.ID, models.ErrConflict)
+		}
+		return fmt.Errorf("failed to restore task with id %s: %w", task.ID, err)
+	}
+
+	return nil
+}
+
+// Автор восстанавливаемой задачи. Если он не указан, автором становится userID
+func taskAuthor(userID int64, task models.Task) int64 {
+	if task.CreatedByID != 0 {
+		return task.CreatedByID
+	}
+	return userID
+}
+
+func (r *Repository) GetTaskByID(ctx context.Context, userID int64, id string) (models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := selectTask + " WHERE scheduler.id = ? AND " + visibleTask
 
-	res := r.db.QueryRow(query, id)
-	err := res.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat)
+	task, err := scanTask(r.db.QueryRowContext(ctx, query, id, userID, userID))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Task{}, fmt.Errorf("task with id %s not found: %w", id, err)
+			return models.Task{}, fmt.Errorf("task with id %s %w", id, models.ErrNotFound)
 		}
 		return models.Task{}, fmt.Errorf("error executing query: %w", err)
 	}
@@ -50,73 +118,69 @@ func (r *Repository) GetTaskByID(id string) (models.Task, error) {
 	return task, nil
 }
 
-func (r *Repository) GetTasks() ([]models.Task, error) {
-	query := "SELECT id, date, title, comment, repeat FROM scheduler"
+func (r *Repository) GetTasks(ctx context.Context, userID, listID int64) ([]models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	rows, err := r.db.Query(query)
+	query := selectTask + " WHERE scheduler.list_id = ? AND " + visibleTask + " ORDER BY scheduler.id"
+
+	rows, err := r.db.QueryContext(ctx, query, listID, userID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tasks: %w", err)
 	}
-	defer rows.Close()
-
-	var tasks []models.Task
-	for rows.Next() {
-		var task models.Task
-		err := rows.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-		tasks = append(tasks, task)
-	}
-
-	if tasks == nil {
-		tasks = []models.Task{}
-	}
 
-	return tasks, nil
+	return scanTasks(rows)
 }
 
-func (r *Repository) SearchTasksByString(search string) ([]models.Task, error) {
-	query := `SELECT id, date, title, comment, repeat FROM scheduler WHERE title LIKE ? OR comment LIKE ? ORDER BY date`
+func (r *Repository) SearchTasksByString(ctx context.Context, userID, listID int64, search string) ([]models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	rows, err := r.db.Query(query, "%"+search+"%", "%"+search+"%")
+	query := selectTask + " WHERE scheduler.list_id = ? AND " + visibleTask + `
+	AND (instr(unicode_lower(scheduler.title), ?) > 0 OR instr(unicode_lower(scheduler.comment), ?) > 0)
+	ORDER BY scheduler.date, scheduler.id`
+
+	search = strings.ToLower(search)
+	rows, err := r.db.QueryContext(ctx, query, listID, userID, userID, search, search)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search tasks: %w", err)
 	}
 
-	var tasks []models.Task
-	for rows.Next() {
-		var task models.Task
-		err := rows.Scan(&task.ID, &task.Title, &task.Comment, &task.Date, &task.Repeat)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-		tasks = append(tasks, task)
-	}
+	return scanTasks(rows)
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error after iterating rows: %w", err)
-	}
+func (r *Repository) SearchTasksByDate(ctx context.Context, userID, listID int64, date string) ([]models.Task, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := selectTask + " WHERE scheduler.list_id = ? AND " + visibleTask + " AND scheduler.date = ? ORDER BY scheduler.id"
 
-	if tasks == nil {
-		tasks = []models.Task{}
+	rows, err := r.db.QueryContext(ctx, query, listID, userID, userID, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
 	}
 
-	return tasks, nil
+	return scanTasks(rows)
 }
 
-func (r *Repository) SearchTasksByDate(date string) ([]models.Task, error) {
-	query := `SELECT id, date, title, comment, repeat FROM scheduler WHERE date = ?`
+func scanTask(row scanner) (models.Task, error) {
+	var task models.Task
 
-	rows, err := r.db.Query(query, date)
+	err := row.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat, &task.ListID,
+		&task.CreatedByID, &task.CreatedBy, &task.ModifiedByID, &task.ModifiedBy, &task.Version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search tasks: %w", err)
+		return models.Task{}, err
 	}
 
-	var tasks []models.Task
+	return task, nil
+}
+
+func scanTasks(rows *sql.Rows) ([]models.Task, error) {
+	defer rows.Close()
+
+	tasks := []models.Task{}
 	for rows.Next() {
-		var task models.Task
-		err := rows.Scan(&task.ID, &task.Title, &task.Comment, &task.Date, &task.Repeat)
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning row: %w", err)
 		}
@@ -127,17 +191,19 @@ func (r *Repository) SearchTasksByDate(date string) ([]models.Task, error) {
 		return nil, fmt.Errorf("error after iterating rows: %w", err)
 	}
 
-	if tasks == nil {
-		tasks = []models.Task{}
-	}
-
 	return tasks, nil
 }
 
-func (r *Repository) EditTask(task models.Task) error {
-	query := `UPDATE scheduler SET date = ?, title = ?, comment = ?, repeat = ? WHERE id = ?`
+// Если task.Version не 0, задача изменяется, только если ее версия совпадает
+func (r *Repository) EditTask(ctx context.Context, userID int64, task models.Task) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	result, err := r.db.Exec(query, task.Date, task.Title, task.Comment, task.Repeat, task.ID)
+	query := `UPDATE scheduler SET date = ?, title = ?, comment = ?, repeat = ?, modified_by = ?, version = version + 1
+	WHERE scheduler.id = ? AND ` + visibleTask + ` AND (? = 0 OR version = ?)`
+
+	result, err := r.db.ExecContext(ctx, query, task.Date, task.Title, task.Comment, task.Repeat, userID,
+		task.ID, userID, userID, task.Version, task.Version)
 	if err != nil {
 		return fmt.Errorf("failed to edit task with id %s: %w", task.ID, err)
 	}
@@ -148,16 +214,20 @@ func (r *Repository) EditTask(task models.Task) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("task with id %s not found", task.ID)
+		return r.versionMismatch(ctx, userID, task.ID, task.Version)
 	}
 
 	return nil
 }
 
-func (r *Repository) DeleteByID(id string) error {
-	query := `DELETE FROM scheduler WHERE id = ?`
+// Если version не 0, задача удаляется, только если ее версия совпадает
+func (r *Repository) DeleteByID(ctx context.Context, userID int64, id string, version int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM scheduler WHERE scheduler.id = ? AND ` + visibleTask + ` AND (? = 0 OR version = ?)`
 
-	result, err := r.db.Exec(query, id)
+	result, err := r.db.ExecContext(ctx, query, id, userID, userID, version, version)
 	if err != nil {
 		return fmt.Errorf("failed to delete task: %w", err)
 	}
@@ -168,8 +238,23 @@ func (r *Repository) DeleteByID(id string) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("task with id %s not found", id)
+		return r.versionMismatch(ctx, userID, id, version)
 	}
 
 	return nil
 }
+
+// Объясняет, почему условный UPDATE или DELETE не затронул ни одной строки:
+// задачи нет или ее версия уже изменилась
+func (r *Repository) versionMismatch(ctx context.Context, userID int64, id string, version int64) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	task, err := r.GetTaskByID(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("task with id %s has version %d, expected %d: %w",
+		id, task.Version, version, models.ErrPreconditionFailed)
+}
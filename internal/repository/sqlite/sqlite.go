@@ -2,15 +2,43 @@ package sqlite
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// Драйвер sqlite3 с функцией unicode_lower: встроенная lower в SQLite меняет регистр только у латиницы
+const driverName = "sqlite3_scheduler"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("unicode_lower", strings.ToLower, true)
+		},
+	})
+}
+
+// _txlock=immediate: транзакция сразу берет блокировку на запись, поэтому
+// параллельные read-modify-write выполняются по очереди, а не читают одни и те же данные.
+// _busy_timeout: ожидающая транзакция ждет освобождения блокировки, а не падает с SQLITE_BUSY
 func NewSQLiteDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	dsn := path + separator + "_txlock=immediate&_busy_timeout=5000"
+
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
 	}
 	return db, nil
 }
+
+// Нарушение ограничения целостности (уникальность, первичный ключ)
+func isConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint
+}
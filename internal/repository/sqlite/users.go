@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+func (r *Repository) AddUser(ctx context.Context, user models.User) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO users (login, password_hash, created_at) VALUES (?, ?, ?)`
+
+	res, err := r.db.ExecContext(ctx, query, user.Login, user.PasswordHash, user.CreatedAt.Unix())
+	if err != nil {
+		if isConstraintError(err) {
+			return 0, fmt.Errorf("user with login %s already exists: %w", user.Login, models.ErrConflict)
+		}
+		return 0, fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *Repository) GetUserByID(ctx context.Context, id int64) (models.User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, login, password_hash, created_at FROM users WHERE id = ?`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return models.User{}, fmt.Errorf("user with id %d %w", id, models.ErrNotFound)
+	}
+
+	return user, err
+}
+
+func (r *Repository) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, login, password_hash, created_at FROM users WHERE login = ?`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, login))
+	if err == sql.ErrNoRows {
+		return models.User{}, fmt.Errorf("user with login %s %w", login, models.ErrNotFound)
+	}
+
+	return user, err
+}
+
+func (r *Repository) UpdateUserPassword(ctx context.Context, id int64, passwordHash string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get the number of affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with id %d %w", id, models.ErrNotFound)
+	}
+
+	return nil
+}
+
+func scanUser(row scanner) (models.User, error) {
+	var user models.User
+	var createdAt int64
+
+	err := row.Scan(&user.ID, &user.Login, &user.PasswordHash, &createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.User{}, err
+		}
+		return models.User{}, fmt.Errorf("error scanning row: %w", err)
+	}
+	user.CreatedAt = time.Unix(createdAt, 0)
+
+	return user, nil
+}
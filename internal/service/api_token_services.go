@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+// Префикс отличает токены API от JWT сессий
+const APITokenPrefix = "sch_"
+
+const maxAPITokenNameLength = 100
+
+var (
+	ErrInvalidAPIToken = fmt.Errorf("invalid api token: %w", models.ErrUnauthorized)
+	ErrAPITokenExpired = fmt.Errorf("api token has expired: %w", models.ErrUnauthorized)
+)
+
+var knownScopes = map[string]bool{
+	models.ScopeTasksRead:  true,
+	models.ScopeTasksWrite: true,
+}
+
+type APITokenService struct {
+	repository repository.Repository
+}
+
+func NewAPITokenService(repository repository.Repository) *APITokenService {
+	return &APITokenService{repository: repository}
+}
+
+// Создает токен и возвращает его значение. Получить значение повторно нельзя
+func (s *APITokenService) CreateAPIToken(ctx context.Context, userID int64, req models.CreateAPITokenRequest) (models.CreateAPITokenResponse, error) {
+	// В базе время хранится с точностью до секунды
+	now := time.Now().Truncate(time.Second)
+
+	var errs models.ValidationErrors
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxAPITokenNameLength {
+		errs = append(errs, models.NewValidationError("name", fmt.Sprintf("name must be 1-%d bytes long", maxAPITokenNameLength), nil))
+	}
+
+	scopes, err := normalizeScopes(req.Scopes)
+	errs = appendValidationError(errs, err)
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		errs = append(errs, models.NewValidationError("expires_at", "expires_at must be in the future", nil))
+	}
+
+	err = errs.Err()
+	if err != nil {
+		return models.CreateAPITokenResponse{}, err
+	}
+
+	value, err := newAPITokenValue()
+	if err != nil {
+		return models.CreateAPITokenResponse{}, err
+	}
+
+	token := models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hashAPIToken(value),
+		CreatedAt: now,
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		id, err := tx.AddAPIToken(ctx, userID, token)
+		if err != nil {
+			return err
+		}
+		token.ID = strconv.FormatInt(id, 10)
+
+		return recordAudit(ctx, tx, models.AuditEvent{
+			UserID:  userID,
+			Action:  models.AuditTokenCreate,
+			Target:  token.ID,
+			Success: true,
+			Details: strings.Join(scopes, " "),
+		})
+	})
+	if err != nil {
+		return models.CreateAPITokenResponse{}, err
+	}
+
+	return models.CreateAPITokenResponse{Token: value, APIToken: token}, nil
+}
+
+func (s *APITokenService) GetAPITokens(ctx context.Context, userID int64) ([]models.APIToken, error) {
+	return s.repository.GetAPITokens(ctx, userID)
+}
+
+func (s *APITokenService) RevokeAPIToken(ctx context.Context, userID int64, id string) error {
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		err := tx.DeleteAPIToken(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, models.AuditEvent{
+			UserID:  userID,
+			Action:  models.AuditTokenRevoke,
+			Target:  id,
+			Success: true,
+		})
+	})
+}
+
+// Находит действующий токен по его значению
+func (s *APITokenService) AuthenticateAPIToken(ctx context.Context, value string) (models.APIToken, error) {
+	token, err := s.repository.GetAPITokenByHash(ctx, hashAPIToken(value))
+	if errors.Is(err, models.ErrNotFound) {
+		return models.APIToken{}, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return models.APIToken{}, err
+	}
+
+	if token.ExpiresAt != nil && !time.Now().Before(*token.ExpiresAt) {
+		return models.APIToken{}, ErrAPITokenExpired
+	}
+
+	return token, nil
+}
+
+func IsAPIToken(value string) bool {
+	return strings.HasPrefix(value, APITokenPrefix)
+}
+
+// Права без повторов в порядке запроса. Нужно хотя бы одно известное право
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, models.NewValidationError("scopes", "at least one scope is required", nil)
+	}
+
+	seen := map[string]bool{}
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return nil, models.NewValidationError("scopes", fmt.Sprintf("unknown scope %q, expected %s or %s", scope, models.ScopeTasksRead, models.ScopeTasksWrite), nil)
+		}
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		result = append(result, scope)
+	}
+
+	return result, nil
+}
+
+// 32 случайных байта: перебор невозможен, поэтому для хранения
+// достаточно SHA-256 без соли, и токен можно найти по хешу
+func newAPITokenValue() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate api token: %w", err)
+	}
+
+	return APITokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Хеш случайного токена: токена API или токена обновления
+func hashAPIToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// Способы входа, которые указываются в записи журнала аудита
+const (
+	SignInPassword = "password"
+	SignInOIDC     = "oidc"
+)
+
+type clientIPKey struct{}
+
+// Запоминает адрес клиента, который попадет в записи журнала аудита
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+var ErrUnknownAuditAdmin = fmt.Errorf("audit administrator does not exist: %w", models.ErrNotFound)
+
+type AuditService struct {
+	repository repository.Repository
+	// Логины из AUTH_AUDIT_ADMINS
+	logins []string
+	// id пользователей с доступом к журналу. Заполняется в ResolveAuditAdmins: доступ
+	// проверяется по id, чтобы логин, зарегистрированный позже, не получил журнал
+	admins map[int64]bool
+}
+
+// admins - логины пользователей с доступом к журналу, пустой список - только администратор
+func NewAuditService(repository repository.Repository, admins []string) *AuditService {
+	s := &AuditService{repository: repository}
+	for _, login := range admins {
+		if login = strings.TrimSpace(login); login != "" {
+			s.logins = append(s.logins, login)
+		}
+	}
+	if len(s.logins) == 0 {
+		s.logins = []string{AdminLogin}
+	}
+
+	return s
+}
+
+// Находит пользователей с доступом к журналу. Вызывается при запуске: если кого-то
+// из них нет, запуск прерывается, иначе его логин мог бы занять кто угодно.
+// До вызова журнал недоступен никому
+func (s *AuditService) ResolveAuditAdmins(ctx context.Context) error {
+	admins := make(map[int64]bool, len(s.logins))
+	for _, login := range s.logins {
+		user, err := s.repository.GetUserByLogin(ctx, login)
+		if errors.Is(err, models.ErrNotFound) {
+			return fmt.Errorf("%w: %s", ErrUnknownAuditAdmin, login)
+		}
+		if err != nil {
+			return err
+		}
+		admins[user.ID] = true
+	}
+
+	s.admins = admins
+	return nil
+}
+
+// Записывает результат попытки входа. err - ошибка входа: записываются только отказы
+// из-за неверных данных, а сбои вроде недоступной базы попыткой не считаются.
+// Возвращает err, а если вход удался - ошибку записи: вход, о котором не осталось записи,
+// не должен состояться. userID 0 - пользователь не найден
+func (s *AuditService) AuditSignIn(ctx context.Context, login string, userID int64, method string, err error) error {
+	if err != nil && !errors.Is(err, models.ErrUnauthorized) {
+		return err
+	}
+
+	if login == "" && method == SignInPassword {
+		login = AdminLogin
+	}
+
+	event := models.AuditEvent{
+		UserID:  userID,
+		Login:   login,
+		Action:  models.AuditSignIn,
+		Success: err == nil,
+		Details: method,
+	}
+	if err != nil {
+		event.Details = method + ": " + err.Error()
+	}
+
+	auditErr := recordAudit(ctx, s.repository, event)
+	if err != nil {
+		if auditErr != nil {
+			log.Printf("failed to record failed sign-in: %v", auditErr)
+		}
+		return err
+	}
+
+	return auditErr
+}
+
+// Журнал аудита доступен только пользователям из AUTH_AUDIT_ADMINS
+func (s *AuditService) GetAuditEvents(ctx context.Context, userID int64, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	if !s.admins[userID] {
+		return nil, fmt.Errorf("audit log is available to audit administrators only: %w", models.ErrForbidden)
+	}
+
+	if filter.Limit < 0 || filter.Limit > maxAuditLimit {
+		return nil, models.NewValidationError("limit", fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), nil)
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditLimit
+	}
+
+	return s.repository.GetAuditEvents(ctx, filter)
+}
+
+// Передает в fn все записи, подходящие под фильтр, от новых к старым. Записи читаются
+// страницами, поэтому выгрузка большого журнала не держит его в памяти целиком.
+// filter.Limit не учитывается
+func (s *AuditService) ExportAuditEvents(ctx context.Context, userID int64, filter models.AuditFilter, fn func(models.AuditEvent) error) error {
+	filter.Limit = maxAuditLimit
+
+	for {
+		events, err := s.GetAuditEvents(ctx, userID, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			err = fn(event)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(events) < filter.Limit {
+			return nil
+		}
+		filter.BeforeID = events[len(events)-1].ID
+	}
+}
+
+// Добавляет запись в журнал аудита от имени клиента из ctx. Вызывается в той же транзакции,
+// что и само действие, чтобы запись появлялась тогда и только тогда, когда оно выполнено
+func recordAudit(ctx context.Context, repo repository.Repository, event models.AuditEvent) error {
+	event.CreatedAt = time.Now()
+	event.IP = clientIP(ctx)
+
+	_, err := repo.AddAuditEvent(ctx, event)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return nil
+}
+
+// Запись об изменении задачи. Для задач общих списков указывается список
+func recordTaskAudit(ctx context.Context, repo repository.Repository, userID int64, action string, task models.Task) error {
+	event := models.AuditEvent{UserID: userID, Action: action, Target: task.ID, Success: true}
+	if task.ListID != 0 {
+		event.Details = fmt.Sprintf("list %d", task.ListID)
+	}
+
+	return recordAudit(ctx, repo, event)
+}
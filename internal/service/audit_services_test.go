@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+func TestAuditLog(t *testing.T) {
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	ctx := WithClientIP(context.Background(), "192.0.2.10")
+	users := NewUserService(repo)
+	audit := NewAuditService(repo, nil)
+	require.NoError(t, audit.ResolveAuditAdmins(ctx))
+	tasks := NewTaskService(repo, NewJournalService(repo, time.Minute))
+
+	user, err := users.SignUp(ctx, "user", "secret-password")
+	require.NoError(t, err)
+
+	_, err = users.SignIn(ctx, "user", "wrong-password")
+	assert.ErrorIs(t, audit.AuditSignIn(ctx, "user", 0, SignInPassword, err), ErrInvalidCredentials)
+	require.NoError(t, audit.AuditSignIn(ctx, "user", user.ID, SignInPassword, nil))
+
+	id, _, err := tasks.AddTask(ctx, user.ID, models.Task{Title: "Зарядка"})
+	require.NoError(t, err)
+	taskID := strconv.FormatInt(id, 10)
+	_, err = tasks.DoneTask(ctx, user.ID, taskID, 0)
+	require.NoError(t, err)
+
+	// Неудачное изменение не оставляет записи
+	_, err = tasks.DeleteTask(ctx, user.ID, taskID, 0)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = audit.GetAuditEvents(ctx, user.ID, models.AuditFilter{})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	events, err := audit.GetAuditEvents(ctx, AdminID, models.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+
+	actions := make([]string, len(events))
+	for i, event := range events {
+		actions[i] = event.Action
+		assert.Equal(t, "192.0.2.10", event.IP)
+		assert.Equal(t, "user", event.Login)
+	}
+	assert.Equal(t, []string{models.AuditTaskDone, models.AuditTaskCreate, models.AuditSignIn, models.AuditSignIn}, actions)
+	assert.Equal(t, taskID, events[0].Target)
+	assert.True(t, events[1].Success)
+	assert.True(t, events[2].Success)
+	assert.False(t, events[3].Success)
+	assert.Zero(t, events[3].UserID)
+
+	failed := false
+	events, err = audit.GetAuditEvents(ctx, AdminID, models.AuditFilter{Action: models.AuditSignIn, Success: &failed})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0].Details, SignInPassword)
+
+	_, err = audit.GetAuditEvents(ctx, AdminID, models.AuditFilter{Limit: maxAuditLimit + 1})
+	assert.ErrorIs(t, err, models.ErrValidation)
+
+	var exported []int64
+	err = audit.ExportAuditEvents(ctx, AdminID, models.AuditFilter{UserID: user.ID}, func(event models.AuditEvent) error {
+		exported = append(exported, event.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, exported, 3)
+}
+
+// Отзыв токена, выход и выключение второго фактора тоже попадают в журнал
+func TestAuditSecurityEvents(t *testing.T) {
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	audit := NewAuditService(repo, nil)
+	require.NoError(t, audit.ResolveAuditAdmins(ctx))
+	sessions := NewSessionService(repo, time.Hour)
+	tokens := NewAPITokenService(repo)
+	twoFactor := NewTwoFactorService(repo)
+
+	user, err := NewUserService(repo).SignUp(ctx, "user", "secret-password")
+	require.NoError(t, err)
+
+	token, err := tokens.CreateAPIToken(ctx, user.ID, models.CreateAPITokenRequest{Name: "ci", Scopes: []string{models.ScopeTasksRead}})
+	require.NoError(t, err)
+	require.NoError(t, tokens.RevokeAPIToken(ctx, user.ID, token.ID))
+	assert.ErrorIs(t, tokens.RevokeAPIToken(ctx, user.ID, token.ID), models.ErrNotFound)
+
+	session, _, err := sessions.StartSession(ctx, user, false)
+	require.NoError(t, err)
+	setup, err := twoFactor.SetupTwoFactor(ctx, user.ID)
+	require.NoError(t, err)
+	code, err := totpCode(setup.Secret, totpStep(time.Now()))
+	require.NoError(t, err)
+	codes, err := twoFactor.EnableTwoFactor(ctx, user.ID, session.ID, code)
+	require.NoError(t, err)
+	require.NoError(t, twoFactor.DisableTwoFactor(ctx, user.ID, codes[0]))
+	require.NoError(t, sessions.Logout(ctx, user.ID, session.ID))
+
+	events, err := audit.GetAuditEvents(ctx, AdminID, models.AuditFilter{UserID: user.ID})
+	require.NoError(t, err)
+
+	actions := make([]string, len(events))
+	for i, event := range events {
+		actions[i] = event.Action
+	}
+	assert.Equal(t, []string{models.AuditLogout, models.AuditTwoFactorOff, models.AuditTokenRevoke, models.AuditTokenCreate}, actions)
+	assert.Equal(t, strconv.FormatInt(session.ID, 10), events[0].Target)
+	assert.Equal(t, token.ID, events[2].Target)
+}
+
+func TestAuditAdmins(t *testing.T) {
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	auditor, err := NewUserService(repo).SignUp(ctx, "auditor", "secret-password")
+	require.NoError(t, err)
+
+	audit := NewAuditService(repo, []string{"auditor"})
+
+	// Пока список не разрешен при запуске, журнал недоступен никому
+	_, err = audit.GetAuditEvents(ctx, auditor.ID, models.AuditFilter{})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	require.NoError(t, audit.ResolveAuditAdmins(ctx))
+
+	_, err = audit.GetAuditEvents(ctx, auditor.ID, models.AuditFilter{})
+	assert.NoError(t, err)
+
+	// Администратор не в списке
+	_, err = audit.GetAuditEvents(ctx, AdminID, models.AuditFilter{})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	_, err = audit.GetAuditEvents(ctx, auditor.ID+100, models.AuditFilter{})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	// Незарегистрированный логин из списка мог бы занять кто угодно, поэтому запуск прерывается
+	err = NewAuditService(repo, []string{"auditor", "ghost"}).ResolveAuditAdmins(ctx)
+	assert.ErrorIs(t, err, ErrUnknownAuditAdmin)
+	assert.Contains(t, err.Error(), "ghost")
+}
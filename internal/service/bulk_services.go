@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+const maxBulkOperations = 1000
+
+var errBulkAborted = errors.New("bulk operation aborted")
+
+// Выполняет пакет операций в одной транзакции.
+// В режиме atomic первая же ошибка откатывает весь пакет, в режиме best_effort
+// каждая операция выполняется в своей точке сохранения и ошибка откатывает только ее
+func (s *TaskService) BulkTasks(ctx context.Context, userID int64, req models.BulkRequest) (models.BulkResponse, error) {
+	if req.Mode == "" {
+		req.Mode = models.BulkAtomic
+	}
+
+	if req.Mode != models.BulkAtomic && req.Mode != models.BulkBestEffort {
+		return models.BulkResponse{}, models.NewValidationError("mode", "mode must be atomic or best_effort", nil)
+	}
+
+	if len(req.Operations) == 0 {
+		return models.BulkResponse{}, models.NewValidationError("operations", "operations are required", nil)
+	}
+
+	if len(req.Operations) > maxBulkOperations {
+		return models.BulkResponse{}, models.NewValidationError("operations",
+			fmt.Sprintf("no more than %d operations are allowed", maxBulkOperations), nil)
+	}
+
+	results := make([]models.BulkResult, 0, len(req.Operations))
+
+	err := s.repository.InTx(ctx, func(tx repository.Repository) error {
+		for i, op := range req.Operations {
+			var result models.BulkResult
+
+			if req.Mode == models.BulkBestEffort {
+				err := tx.InTx(ctx, func(sp repository.Repository) error {
+					result = s.withRepository(sp).applyBulk(ctx, userID, op)
+					return result.Err
+				})
+				// Ошибка самой точки сохранения, а не операции, прерывает весь пакет
+				if err != nil && result.Err == nil {
+					return err
+				}
+			} else {
+				result = s.withRepository(tx).applyBulk(ctx, userID, op)
+			}
+
+			result.Index = i
+			results = append(results, result)
+
+			if result.Err != nil && req.Mode == models.BulkAtomic {
+				return errBulkAborted
+			}
+		}
+		return nil
+	})
+
+	if errors.Is(err, errBulkAborted) {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Status = models.BulkStatusRolledBack
+				results[i].OperationID = 0
+				if results[i].Op == models.BulkCreate {
+					results[i].ID = ""
+				}
+			}
+		}
+		for i := len(results); i < len(req.Operations); i++ {
+			results = append(results, models.BulkResult{
+				Index:  i,
+				Op:     req.Operations[i].Op,
+				ID:     req.Operations[i].ID,
+				Status: models.BulkStatusSkipped,
+			})
+		}
+		return models.BulkResponse{Committed: false, Results: results}, nil
+	}
+
+	if err != nil {
+		return models.BulkResponse{}, err
+	}
+
+	return models.BulkResponse{Committed: true, Results: results}, nil
+}
+
+func (s *TaskService) applyBulk(ctx context.Context, userID int64, op models.BulkOperation) models.BulkResult {
+	result := models.BulkResult{Op: op.Op, ID: op.ID}
+
+	var err error
+	switch op.Op {
+	case models.BulkCreate:
+		if op.Task == nil {
+			err = models.NewValidationError("task", "task is required for create", nil)
+			break
+		}
+		var id int64
+		id, result.OperationID, err = s.AddTask(ctx, userID, *op.Task)
+		if err == nil {
+			result.ID = strconv.FormatInt(id, 10)
+		}
+	case models.BulkUpdate:
+		if op.Task == nil {
+			err = models.NewValidationError("task", "task is required for update", nil)
+			break
+		}
+		task := *op.Task
+		if task.ID == "" {
+			task.ID = op.ID
+		}
+		result.ID = task.ID
+		result.OperationID, err = s.EditTask(ctx, userID, task)
+	case models.BulkDelete:
+		result.OperationID, err = s.DeleteTask(ctx, userID, op.ID, 0)
+	case models.BulkDone:
+		result.OperationID, err = s.DoneTask(ctx, userID, op.ID, 0)
+	case models.BulkMove:
+		result.OperationID, err = s.moveTask(ctx, userID, op.ID, op.Days)
+	default:
+		err = models.NewValidationError("op", fmt.Sprintf("unsupported operation %q", op.Op), nil)
+	}
+
+	if err != nil {
+		result.Status = models.BulkStatusFailed
+		result.OperationID = 0
+		result.Err = err
+		return result
+	}
+
+	result.Status = models.BulkStatusOK
+	return result
+}
+
+// Сдвигает дату задачи на days дней. Новая дата проходит те же проверки, что и при EditTask
+func (s *TaskService) moveTask(ctx context.Context, userID int64, id string, days int) (int64, error) {
+	if days == 0 {
+		return 0, models.NewValidationError("days", "days must not be zero", nil)
+	}
+
+	task, err := s.repository.GetTaskByID(ctx, userID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	date, err := time.Parse(DateFormat, task.Date)
+	if err != nil {
+		return 0, fmt.Errorf("task with id %s has invalid date %q: %w", id, task.Date, err)
+	}
+	task.Date = date.AddDate(0, 0, days).Format(DateFormat)
+
+	return s.EditTask(ctx, userID, task)
+}
+
+// Копия сервиса, работающая через другой репозиторий (например, внутри транзакции)
+func (s *TaskService) withRepository(repository repository.Repository) *TaskService {
+	return &TaskService{
+		repository: repository,
+		journal:    s.journal.withRepository(repository),
+	}
+}
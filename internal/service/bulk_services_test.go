@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+// Ошибка в режиме atomic откатывает весь пакет, а в best_effort - только свою операцию
+func TestBulkTasks(t *testing.T) {
+	s := newTestTaskService(t)
+	ctx := context.Background()
+
+	req := models.BulkRequest{
+		Operations: []models.BulkOperation{
+			{Op: models.BulkCreate, Task: &models.Task{Title: "Зарядка"}},
+			{Op: models.BulkDelete, ID: "100"},
+			{Op: models.BulkCreate, Task: &models.Task{Title: "Пробежка"}},
+		},
+	}
+
+	resp, err := s.BulkTasks(ctx, userID, req)
+	require.NoError(t, err)
+	assert.False(t, resp.Committed)
+	require.Len(t, resp.Results, 3)
+	assert.Equal(t, models.BulkStatusRolledBack, resp.Results[0].Status)
+	assert.Empty(t, resp.Results[0].ID)
+	assert.Equal(t, models.BulkStatusFailed, resp.Results[1].Status)
+	assert.ErrorIs(t, resp.Results[1].Err, models.ErrNotFound)
+	assert.Equal(t, models.BulkStatusSkipped, resp.Results[2].Status)
+
+	tasks, err := s.GetTasks(ctx, userID, 0)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	req.Mode = models.BulkBestEffort
+	resp, err = s.BulkTasks(ctx, userID, req)
+	require.NoError(t, err)
+	assert.True(t, resp.Committed)
+	require.Len(t, resp.Results, 3)
+	assert.Equal(t, models.BulkStatusOK, resp.Results[0].Status)
+	assert.Equal(t, models.BulkStatusFailed, resp.Results[1].Status)
+	assert.Equal(t, models.BulkStatusOK, resp.Results[2].Status)
+	assert.NotZero(t, resp.Results[2].OperationID)
+	assert.NotEmpty(t, resp.Results[2].ID)
+
+	tasks, err = s.GetTasks(ctx, userID, 0)
+	require.NoError(t, err)
+	assert.Len(t, tasks, 2)
+
+	// Задача, которую не удалось создать, не получает id
+	invalid := []models.BulkOperation{{Op: models.BulkCreate, Task: &models.Task{}}}
+	for _, mode := range []string{models.BulkAtomic, models.BulkBestEffort} {
+		resp, err = s.BulkTasks(ctx, userID, models.BulkRequest{Mode: mode, Operations: invalid})
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 1)
+		assert.Equal(t, models.BulkStatusFailed, resp.Results[0].Status, mode)
+		assert.ErrorIs(t, resp.Results[0].Err, models.ErrValidation, mode)
+		assert.Empty(t, resp.Results[0].ID, mode)
+	}
+
+	_, err = s.BulkTasks(ctx, userID, models.BulkRequest{Mode: "sometimes", Operations: req.Operations})
+	assert.ErrorIs(t, err, models.ErrValidation)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+var (
+	ErrIdempotencyKeyReused = fmt.Errorf("idempotency key was already used with a different request: %w", models.ErrUnprocessable)
+	ErrIdempotencyInFlight  = fmt.Errorf("a request with this idempotency key is still in progress: %w", models.ErrConflict)
+)
+
+type IdempotencyService struct {
+	repository repository.Repository
+	window     time.Duration
+	// Через lease незавершенный запрос считается брошенным, 0 - никогда
+	lease time.Duration
+}
+
+func NewIdempotencyService(repository repository.Repository, window, lease time.Duration) *IdempotencyService {
+	return &IdempotencyService{repository: repository, window: window, lease: lease}
+}
+
+// Резервирует ключ для запроса. Если запрос с этим ключом уже выполнялся
+// в пределах window, возвращает его сохраненный результат и replay = true.
+// Ключ, повторно использованный для другого запроса, отклоняется. Ключ запроса,
+// который не завершился за lease (например, сервер упал), переходит к повтору
+func (s *IdempotencyService) ReserveKey(ctx context.Context, userID int64, key, scope, requestHash string) (models.IdempotencyRecord, bool, error) {
+	now := time.Now()
+
+	err := s.repository.DeleteIdempotencyKeysBefore(ctx, now.Add(-s.window))
+	if err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+
+	var staleBefore time.Time
+	if s.lease > 0 {
+		staleBefore = now.Add(-s.lease)
+	}
+
+	rec, created, err := s.repository.ReserveIdempotencyKey(ctx, userID, models.IdempotencyRecord{
+		Key:         key,
+		Scope:       scope,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+	}, staleBefore)
+	if err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+
+	if created {
+		return rec, false, nil
+	}
+
+	if rec.RequestHash != requestHash {
+		return models.IdempotencyRecord{}, false, ErrIdempotencyKeyReused
+	}
+
+	if rec.Status == 0 {
+		return models.IdempotencyRecord{}, false, ErrIdempotencyInFlight
+	}
+
+	return rec, true, nil
+}
+
+func (s *IdempotencyService) SaveResponse(ctx context.Context, userID int64, rec models.IdempotencyRecord) error {
+	return s.repository.CompleteIdempotencyKey(ctx, userID, rec)
+}
+
+// Освобождает ключ, если запрос завершился внутренней ошибкой,
+// чтобы клиент мог повторить его с тем же ключом
+func (s *IdempotencyService) ReleaseKey(ctx context.Context, userID int64, key, scope string) error {
+	return s.repository.DeleteIdempotencyKey(ctx, userID, key, scope)
+}
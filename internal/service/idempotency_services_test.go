@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+func TestReserveKey(t *testing.T) {
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	s := NewIdempotencyService(repo, time.Hour, 0)
+	ctx := context.Background()
+
+	rec, replay, err := s.ReserveKey(ctx, userID, "key", "add", "hash")
+	require.NoError(t, err)
+	assert.False(t, replay)
+
+	// Пока первый запрос выполняется, повтор с тем же ключом отклоняется
+	_, _, err = s.ReserveKey(ctx, userID, "key", "add", "hash")
+	assert.ErrorIs(t, err, ErrIdempotencyInFlight)
+	assert.ErrorIs(t, err, models.ErrConflict)
+
+	rec.Status = 201
+	rec.Body = []byte(`{"id":1}`)
+	require.NoError(t, s.SaveResponse(ctx, userID, rec))
+
+	saved, replay, err := s.ReserveKey(ctx, userID, "key", "add", "hash")
+	require.NoError(t, err)
+	assert.True(t, replay)
+	assert.Equal(t, 201, saved.Status)
+	assert.Equal(t, `{"id":1}`, string(saved.Body))
+
+	_, _, err = s.ReserveKey(ctx, userID, "key", "add", "other-hash")
+	assert.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	// Освобожденный ключ можно использовать снова, в том числе для другого запроса
+	require.NoError(t, s.ReleaseKey(ctx, userID, "key", "add"))
+	_, replay, err = s.ReserveKey(ctx, userID, "key", "add", "other-hash")
+	require.NoError(t, err)
+	assert.False(t, replay)
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+var (
+	ErrAlreadyUndone = fmt.Errorf("operation has already been undone: %w", models.ErrConflict)
+	ErrUndoExpired   = fmt.Errorf("undo window has expired: %w", models.ErrConflict)
+)
+
+type JournalService struct {
+	repository repository.Repository
+	window     time.Duration
+}
+
+func NewJournalService(repository repository.Repository, window time.Duration) *JournalService {
+	return &JournalService{repository: repository, window: window}
+}
+
+func (s *JournalService) withRepository(repository repository.Repository) *JournalService {
+	return &JournalService{repository: repository, window: s.window}
+}
+
+// Записывает операцию в журнал и возвращает ее id. version - версия задачи после операции,
+// 0 - задачи больше нет. Заодно удаляет записи, которые уже нельзя отменить
+func (s *JournalService) Record(ctx context.Context, userID int64, kind string, snapshot models.Task, version int64) (int64, error) {
+	now := time.Now()
+
+	err := s.repository.DeleteOperationsBefore(ctx, now.Add(-s.window))
+	if err != nil {
+		return 0, err
+	}
+
+	opID, err := s.repository.AddOperation(ctx, userID, models.Operation{
+		Kind:      kind,
+		TaskID:    snapshot.ID,
+		Snapshot:  snapshot,
+		Version:   version,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to record operation: %w", err)
+	}
+
+	return opID, nil
+}
+
+// Отменяет операцию, если с момента ее выполнения прошло не больше window:
+// - add - созданная задача удаляется
+// - edit - возвращаются прежние поля задачи
+// - delete - удаленная задача восстанавливается с прежним id
+// - done - задача без повторения восстанавливается, у повторяющейся возвращается прежняя дата
+//
+// Если задачу изменили после операции, отмена отклоняется с ErrPreconditionFailed.
+// Возврат полей записывается в историю изменений задачи, как обычное изменение
+func (s *JournalService) Undo(ctx context.Context, userID int64, opID string) error {
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		return s.withRepository(tx).undo(ctx, userID, opID)
+	})
+}
+
+func (s *JournalService) undo(ctx context.Context, userID int64, opID string) error {
+	op, err := s.repository.GetOperation(ctx, userID, opID)
+	if err != nil {
+		return err
+	}
+
+	if op.Undone {
+		return ErrAlreadyUndone
+	}
+
+	if time.Since(op.CreatedAt) > s.window {
+		return ErrUndoExpired
+	}
+
+	// Права в списке задачи могли измениться с момента операции
+	err = authorizeTask(ctx, s.repository, userID, op.Snapshot, models.RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	switch op.Kind {
+	case models.OperationAdd:
+		err = s.repository.DeleteByID(ctx, userID, op.TaskID, op.Version)
+	case models.OperationEdit:
+		err = s.revertTask(ctx, userID, op)
+	case models.OperationDelete:
+		err = s.restoreTask(ctx, userID, op.Snapshot)
+	case models.OperationDone:
+		if op.Snapshot.Repeat == "" {
+			err = s.restoreTask(ctx, userID, op.Snapshot)
+		} else {
+			err = s.revertTask(ctx, userID, op)
+		}
+	default:
+		err = fmt.Errorf("unsupported operation kind %q", op.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to undo operation %s: %w", opID, err)
+	}
+
+	err = recordAudit(ctx, s.repository, models.AuditEvent{
+		UserID:  userID,
+		Action:  models.AuditTaskUndo,
+		Target:  op.TaskID,
+		Success: true,
+		Details: fmt.Sprintf("operation %s (%s)", opID, op.Kind),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.repository.MarkOperationUndone(ctx, userID, opID)
+}
+
+// Возвращает задаче поля из снимка, если ее версия все еще та, что была после операции
+func (s *JournalService) revertTask(ctx context.Context, userID int64, op models.Operation) error {
+	current, err := s.repository.GetTaskByID(ctx, userID, op.TaskID)
+	if err != nil {
+		return err
+	}
+
+	err = checkVersion(current, op.Version)
+	if err != nil {
+		return err
+	}
+
+	task := op.Snapshot
+	task.Version = current.Version
+	task.ListID = current.ListID
+	task.CreatedBy = current.CreatedBy
+	task.CreatedByID = current.CreatedByID
+
+	err = s.repository.EditTask(ctx, userID, task)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.repository.AddRevision(ctx, revisionOwner(userID, current), models.Revision{
+		TaskID:    task.ID,
+		Before:    current,
+		After:     task,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// В журнале снимок хранится так же, как его видит клиент, без id автора задачи.
+// Автор находится по логину, а если его нет, автором становится userID
+func (s *JournalService) restoreTask(ctx context.Context, userID int64, snapshot models.Task) error {
+	if snapshot.CreatedByID == 0 && snapshot.CreatedBy != "" {
+		author, err := s.repository.GetUserByLogin(ctx, snapshot.CreatedBy)
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			return err
+		}
+		snapshot.CreatedByID = author.ID
+	}
+
+	return s.repository.RestoreTask(ctx, userID, snapshot)
+}
@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+const maxListNameLength = 100
+
+var ErrLastOwner = fmt.Errorf("list must keep at least one owner: %w", models.ErrConflict)
+
+// Каждая следующая роль может все, что и предыдущие
+var roleRank = map[string]int{
+	models.RoleViewer: 1,
+	models.RoleEditor: 2,
+	models.RoleOwner:  3,
+}
+
+type ListService struct {
+	repository repository.Repository
+}
+
+func NewListService(repository repository.Repository) *ListService {
+	return &ListService{repository: repository}
+}
+
+// Создает список, в котором пользователь становится владельцем
+func (s *ListService) CreateList(ctx context.Context, userID int64, name string) (models.List, error) {
+	name, err := validateListName(name)
+	if err != nil {
+		return models.List{}, err
+	}
+
+	// В базе время хранится с точностью до секунды
+	list := models.List{Name: name, Role: models.RoleOwner, CreatedAt: time.Now().Truncate(time.Second)}
+
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		list.ID, err = tx.AddList(ctx, list)
+		if err != nil {
+			return err
+		}
+
+		return tx.AddListMember(ctx, models.ListMember{
+			ListID:    list.ID,
+			UserID:    userID,
+			Role:      models.RoleOwner,
+			CreatedAt: list.CreatedAt,
+		})
+	})
+	if err != nil {
+		return models.List{}, err
+	}
+
+	return list, nil
+}
+
+// Списки, приглашения в которые пользователь принял
+func (s *ListService) GetLists(ctx context.Context, userID int64) ([]models.List, error) {
+	return s.filterLists(ctx, userID, false)
+}
+
+// Списки, приглашения в которые пользователь еще не принял
+func (s *ListService) GetInvitations(ctx context.Context, userID int64) ([]models.List, error) {
+	return s.filterLists(ctx, userID, true)
+}
+
+func (s *ListService) filterLists(ctx context.Context, userID int64, pending bool) ([]models.List, error) {
+	lists, err := s.repository.GetLists(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []models.List{}
+	for _, list := range lists {
+		if list.Pending == pending {
+			filtered = append(filtered, list)
+		}
+	}
+
+	return filtered, nil
+}
+
+// Список с участниками и приглашенными. Доступен любому участнику
+func (s *ListService) GetList(ctx context.Context, userID, id int64) (models.GetListResponse, error) {
+	member, err := checkListRole(ctx, s.repository, userID, id, models.RoleViewer)
+	if err != nil {
+		return models.GetListResponse{}, err
+	}
+
+	list, err := s.repository.GetList(ctx, id)
+	if err != nil {
+		return models.GetListResponse{}, err
+	}
+	list.Role = member.Role
+
+	members, err := s.repository.GetListMembers(ctx, id)
+	if err != nil {
+		return models.GetListResponse{}, err
+	}
+
+	return models.GetListResponse{List: list, Members: members}, nil
+}
+
+func (s *ListService) RenameList(ctx context.Context, userID, id int64, name string) error {
+	name, err := validateListName(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = checkListRole(ctx, s.repository, userID, id, models.RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.RenameList(ctx, id, name)
+}
+
+// Удаляет список вместе с его задачами
+func (s *ListService) DeleteList(ctx context.Context, userID, id int64) error {
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		_, err := checkListRole(ctx, tx, userID, id, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		return tx.DeleteList(ctx, id)
+	})
+}
+
+// Приглашает пользователя в список. Задачи списка станут ему доступны,
+// когда он примет приглашение
+func (s *ListService) InviteMember(ctx context.Context, userID, listID int64, req models.InviteRequest) (models.ListMember, error) {
+	var errs models.ValidationErrors
+	login := strings.TrimSpace(req.Login)
+	if login == "" {
+		errs = append(errs, models.NewValidationError("login", "login is required", nil))
+	}
+	errs = appendValidationError(errs, validateRole(req.Role))
+
+	err := errs.Err()
+	if err != nil {
+		return models.ListMember{}, err
+	}
+
+	member := models.ListMember{
+		ListID:    listID,
+		Login:     login,
+		Role:      req.Role,
+		Pending:   true,
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		_, err := checkListRole(ctx, tx, userID, listID, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		user, err := tx.GetUserByLogin(ctx, login)
+		if err != nil {
+			return err
+		}
+		member.UserID = user.ID
+
+		return tx.AddListMember(ctx, member)
+	})
+	if err != nil {
+		return models.ListMember{}, err
+	}
+
+	return member, nil
+}
+
+func (s *ListService) AcceptInvitation(ctx context.Context, userID, listID int64) error {
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		member, err := getInvitation(ctx, tx, userID, listID)
+		if err != nil {
+			return err
+		}
+
+		member.Pending = false
+		return tx.UpdateListMember(ctx, member)
+	})
+}
+
+func (s *ListService) DeclineInvitation(ctx context.Context, userID, listID int64) error {
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		_, err := getInvitation(ctx, tx, userID, listID)
+		if err != nil {
+			return err
+		}
+
+		return tx.DeleteListMember(ctx, listID, userID)
+	})
+}
+
+func getInvitation(ctx context.Context, repo repository.Repository, userID, listID int64) (models.ListMember, error) {
+	member, err := repo.GetListMember(ctx, listID, userID)
+	if err == nil && !member.Pending {
+		err = fmt.Errorf("invitation to list %d has already been accepted: %w", listID, models.ErrConflict)
+	}
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("invitation to list %d %w", listID, models.ErrNotFound)
+	}
+
+	return member, err
+}
+
+// Меняет роль участника или приглашенного. Доступно только владельцу
+func (s *ListService) UpdateMemberRole(ctx context.Context, userID, listID, memberID int64, role string) error {
+	err := validateRole(role)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		_, err := checkListRole(ctx, tx, userID, listID, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		member, err := tx.GetListMember(ctx, listID, memberID)
+		if err != nil {
+			return err
+		}
+
+		if role != models.RoleOwner {
+			err = checkOtherOwner(ctx, tx, member)
+			if err != nil {
+				return err
+			}
+		}
+
+		member.Role = role
+		return tx.UpdateListMember(ctx, member)
+	})
+}
+
+// Исключает участника или отменяет приглашение. Исключить других может
+// только владелец, а покинуть список - любой участник
+func (s *ListService) RemoveMember(ctx context.Context, userID, listID, memberID int64) error {
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		role := models.RoleOwner
+		if memberID == userID {
+			role = models.RoleViewer
+		}
+
+		_, err := checkListRole(ctx, tx, userID, listID, role)
+		if err != nil {
+			return err
+		}
+
+		member, err := tx.GetListMember(ctx, listID, memberID)
+		if err != nil {
+			return err
+		}
+
+		err = checkOtherOwner(ctx, tx, member)
+		if err != nil {
+			return err
+		}
+
+		return tx.DeleteListMember(ctx, listID, memberID)
+	})
+}
+
+// Проверяет, что у списка останется владелец, если member перестанет им быть
+func checkOtherOwner(ctx context.Context, repo repository.Repository, member models.ListMember) error {
+	if member.Role != models.RoleOwner || member.Pending {
+		return nil
+	}
+
+	members, err := repo.GetListMembers(ctx, member.ListID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		if m.UserID != member.UserID && m.Role == models.RoleOwner && !m.Pending {
+			return nil
+		}
+	}
+
+	return ErrLastOwner
+}
+
+// Проверяет, что пользователь участвует в списке с ролью не ниже role. Для того,
+// кто не участвует в списке или еще не принял приглашение, списка не существует
+func checkListRole(ctx context.Context, repo repository.Repository, userID, listID int64, role string) (models.ListMember, error) {
+	member, err := repo.GetListMember(ctx, listID, userID)
+	if errors.Is(err, models.ErrNotFound) || err == nil && member.Pending {
+		return models.ListMember{}, fmt.Errorf("list with id %d %w", listID, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.ListMember{}, err
+	}
+
+	if roleRank[member.Role] < roleRank[role] {
+		return models.ListMember{}, fmt.Errorf("role %s in list %d does not allow this action: %w",
+			member.Role, listID, models.ErrForbidden)
+	}
+
+	return member, nil
+}
+
+// Задачу из списка читает любой его участник, а изменяет - editor и owner.
+// Личные задачи репозиторий и так отдает только их владельцу
+func authorizeTask(ctx context.Context, repo repository.Repository, userID int64, task models.Task, role string) error {
+	if task.ListID == 0 {
+		return nil
+	}
+
+	_, err := checkListRole(ctx, repo, userID, task.ListID, role)
+	return err
+}
+
+func validateListName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxListNameLength {
+		return "", models.NewValidationError("name", fmt.Sprintf("name must be 1-%d bytes long", maxListNameLength), nil)
+	}
+	return name, nil
+}
+
+func validateRole(role string) error {
+	if _, ok := roleRank[role]; !ok {
+		return models.NewValidationError("role", fmt.Sprintf("role must be one of %s, %s, %s",
+			models.RoleViewer, models.RoleEditor, models.RoleOwner), nil)
+	}
+	return nil
+}
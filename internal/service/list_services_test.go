@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+func TestListRoles(t *testing.T) {
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	users := NewUserService(repo)
+	lists := NewListService(repo)
+	journal := NewJournalService(repo, time.Minute)
+	tasks := NewTaskService(repo, journal)
+
+	owner, err := users.SignUp(ctx, "owner", "secret-password")
+	require.NoError(t, err)
+	editor, err := users.SignUp(ctx, "editor", "secret-password")
+	require.NoError(t, err)
+	viewer, err := users.SignUp(ctx, "viewer", "secret-password")
+	require.NoError(t, err)
+	stranger, err := users.SignUp(ctx, "stranger", "secret-password")
+	require.NoError(t, err)
+
+	list, err := lists.CreateList(ctx, owner.ID, " Family ")
+	require.NoError(t, err)
+	assert.Equal(t, "Family", list.Name)
+	assert.Equal(t, models.RoleOwner, list.Role)
+
+	_, err = lists.InviteMember(ctx, owner.ID, list.ID, models.InviteRequest{Login: "editor", Role: models.RoleEditor})
+	require.NoError(t, err)
+	_, err = lists.InviteMember(ctx, owner.ID, list.ID, models.InviteRequest{Login: "viewer", Role: models.RoleViewer})
+	require.NoError(t, err)
+	_, err = lists.InviteMember(ctx, owner.ID, list.ID, models.InviteRequest{Login: "nobody", Role: models.RoleViewer})
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = lists.InviteMember(ctx, owner.ID, list.ID, models.InviteRequest{Login: "viewer", Role: "admin"})
+	assert.ErrorIs(t, err, models.ErrValidation)
+	_, err = lists.InviteMember(ctx, owner.ID, list.ID, models.InviteRequest{Login: "viewer", Role: models.RoleViewer})
+	assert.ErrorIs(t, err, models.ErrConflict)
+
+	id, _, err := tasks.AddTask(ctx, owner.ID, models.Task{Title: "Купить молоко", ListID: list.ID})
+	require.NoError(t, err)
+	taskID := strconv.FormatInt(id, 10)
+
+	// Пока приглашение не принято, списка и его задач для пользователя нет
+	_, err = tasks.GetTaskByID(ctx, editor.ID, taskID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = tasks.GetTasks(ctx, editor.ID, list.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	invitations, err := lists.GetInvitations(ctx, editor.ID)
+	require.NoError(t, err)
+	require.Len(t, invitations, 1)
+	assert.Equal(t, list.ID, invitations[0].ID)
+
+	require.NoError(t, lists.AcceptInvitation(ctx, editor.ID, list.ID))
+	require.NoError(t, lists.AcceptInvitation(ctx, viewer.ID, list.ID))
+	assert.ErrorIs(t, lists.AcceptInvitation(ctx, viewer.ID, list.ID), models.ErrConflict)
+	assert.ErrorIs(t, lists.AcceptInvitation(ctx, stranger.ID, list.ID), models.ErrNotFound)
+
+	// viewer только читает
+	listTasks, err := tasks.GetTasks(ctx, viewer.ID, list.ID)
+	require.NoError(t, err)
+	require.Len(t, listTasks, 1)
+	assert.Equal(t, "owner", listTasks[0].CreatedBy)
+
+	_, err = tasks.PatchTask(ctx, viewer.ID, taskID, 0, models.TaskPatch{Title: strPtr("Купить хлеб")})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = tasks.DeleteTask(ctx, viewer.ID, taskID, 0)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, _, err = tasks.AddTask(ctx, viewer.ID, models.Task{Title: "Чужая задача", ListID: list.ID})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	assert.ErrorIs(t, lists.RenameList(ctx, viewer.ID, list.ID, "Mine"), models.ErrForbidden)
+
+	// editor изменяет задачи, но не управляет списком
+	opID, err := tasks.PatchTask(ctx, editor.ID, taskID, 0, models.TaskPatch{Title: strPtr("Купить хлеб")})
+	require.NoError(t, err)
+
+	task, err := tasks.GetTaskByID(ctx, owner.ID, taskID)
+	require.NoError(t, err)
+	assert.Equal(t, "Купить хлеб", task.Title)
+	assert.Equal(t, "owner", task.CreatedBy)
+	assert.Equal(t, "editor", task.ModifiedBy)
+
+	revisions, err := tasks.GetRevisions(ctx, viewer.ID, taskID)
+	require.NoError(t, err)
+	assert.Len(t, revisions, 1, "история задачи списка видна всем участникам")
+
+	_, err = lists.InviteMember(ctx, editor.ID, list.ID, models.InviteRequest{Login: "stranger", Role: models.RoleOwner})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	// Пониженный участник не может отменить свою прежнюю правку
+	require.NoError(t, lists.UpdateMemberRole(ctx, owner.ID, list.ID, editor.ID, models.RoleViewer))
+	assert.ErrorIs(t, journal.Undo(ctx, editor.ID, strconv.FormatInt(opID, 10)), models.ErrForbidden)
+
+	// Личные задачи не видны участникам списка
+	personal, _, err := tasks.AddTask(ctx, owner.ID, models.Task{Title: "Личное"})
+	require.NoError(t, err)
+	_, err = tasks.GetTaskByID(ctx, editor.ID, strconv.FormatInt(personal, 10))
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	// У списка всегда остается владелец
+	assert.ErrorIs(t, lists.RemoveMember(ctx, owner.ID, list.ID, owner.ID), ErrLastOwner)
+	assert.ErrorIs(t, lists.UpdateMemberRole(ctx, owner.ID, list.ID, owner.ID, models.RoleEditor), ErrLastOwner)
+
+	// Покинувший список теряет доступ к его задачам
+	require.NoError(t, lists.RemoveMember(ctx, viewer.ID, list.ID, viewer.ID))
+	_, err = tasks.GetTaskByID(ctx, viewer.ID, taskID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	require.NoError(t, lists.DeleteList(ctx, owner.ID, list.ID))
+	_, err = tasks.GetTaskByID(ctx, owner.ID, taskID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	remaining, err := lists.GetLists(ctx, editor.ID)
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,663 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+const (
+	// Сколько пользователь может пробыть на странице входа провайдера
+	OIDCFlowTTL = 10 * time.Minute
+	// Отличает состояние входа от токенов доступа, подписанных тем же секретом
+	oidcFlowAudience = "scheduler-oidc-flow"
+	// Отличает вход, ожидающий кода двухфакторной аутентификации
+	oidcSecondFactorAudience = "scheduler-oidc-2fa"
+	// Ключи провайдера перечитываются при незнакомом kid, но не чаще этого интервала
+	oidcKeysRefreshInterval = time.Minute
+	// Допустимое расхождение часов с провайдером при проверке exp и iat
+	oidcLeeway = time.Minute
+)
+
+var (
+	ErrOIDCDisabled    = fmt.Errorf("oidc login is not configured: %w", models.ErrNotFound)
+	ErrInvalidOIDCFlow = fmt.Errorf("oidc login state is invalid or has expired: %w", models.ErrUnauthorized)
+	ErrInvalidIDToken  = fmt.Errorf("invalid id token: %w", models.ErrUnauthorized)
+)
+
+// Состояние входа между переходом к провайдеру и возвратом от него. Хранится
+// в cookie браузера, подписанное секретом из config.Auth: state защищает от CSRF,
+// nonce связывает ID-токен с этим входом, verifier - ключ PKCE (RFC 7636)
+type oidcFlowClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// Вход через провайдера пользователя с двухфакторной аутентификацией до ввода кода.
+// Хранится в cookie браузера так же, как состояние входа. jti - хеш одноразового
+// токена сеанса sid, который расходуется при вводе кода
+type oidcSecondFactorClaims struct {
+	UserID    int64 `json:"uid"`
+	SessionID int64 `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+type idTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// Поля /.well-known/openid-configuration, которые нужны для входа
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type OIDCService struct {
+	repository repository.Repository
+	cfg        config.OIDC
+	secret     string
+	client     *http.Client
+
+	mu sync.Mutex
+	// Документ discovery читается при первом входе и дальше не меняется
+	provider      *oidcProvider
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+func NewOIDCService(repository repository.Repository, cfg config.Auth) *OIDCService {
+	return &OIDCService{
+		repository: repository,
+		cfg:        cfg.OIDC,
+		secret:     cfg.Secret,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *OIDCService) OIDCEnabled() bool {
+	return s.cfg.Issuer != ""
+}
+
+// Возвращает адрес страницы входа провайдера и подписанное состояние входа,
+// которое нужно вернуть в FinishOIDCLogin
+func (s *OIDCService) StartOIDCLogin(ctx context.Context) (string, string, error) {
+	if !s.OIDCEnabled() {
+		return "", "", ErrOIDCDisabled
+	}
+
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	var values [3]string
+	for i := range values {
+		values[i], err = randomString()
+		if err != nil {
+			return "", "", err
+		}
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	now := time.Now()
+	flow, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcFlowClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{oidcFlowAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(OIDCFlowTTL)),
+		},
+	}).SignedString([]byte(s.secret))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign oidc state: %w", err)
+	}
+
+	authURL, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", s.cfg.ClientID)
+	query.Set("redirect_uri", s.cfg.RedirectURL)
+	query.Set("scope", s.cfg.Scopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkceChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), flow, nil
+}
+
+// Завершает вход: сверяет state, обменивает код на токены, проверяет ID-токен
+// и возвращает пользователя, за которым закреплена учетная запись провайдера.
+// При первом входе пользователь создается, войти в него по паролю нельзя
+func (s *OIDCService) FinishOIDCLogin(ctx context.Context, flow, state, code string) (models.User, error) {
+	if !s.OIDCEnabled() {
+		return models.User{}, ErrOIDCDisabled
+	}
+
+	claims := &oidcFlowClaims{}
+	_, err := jwt.ParseWithClaims(flow, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(oidcFlowAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(claims.State), []byte(state)) != 1 {
+		return models.User{}, ErrInvalidOIDCFlow
+	}
+
+	if code == "" {
+		return models.User{}, models.NewValidationError("code", "code is required", nil)
+	}
+
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	rawIDToken, err := s.exchangeCode(ctx, provider, code, claims.Verifier)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	idToken, err := s.verifyIDToken(ctx, provider, rawIDToken, claims.Nonce)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return s.userForIdentity(ctx, provider.Issuer, idToken)
+}
+
+// Записывает вход пользователя, прошедшего провайдера, но еще не введшего код
+// двухфакторной аутентификации, и подписывает его. Вход хранится как сеанс без второго
+// фактора с одноразовым токеном: токен расходуется в FinishOIDCSecondFactor, поэтому
+// подписанный вход нельзя повторить. Действует столько же, сколько состояние входа
+func (s *OIDCService) StartOIDCSecondFactor(ctx context.Context, user models.User) (string, error) {
+	now := time.Now()
+
+	value, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	hash := hashAPIToken(value)
+
+	var sessionID int64
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		var err error
+		sessionID, err = tx.AddSession(ctx, user.ID, models.Session{
+			UserID:      user.ID,
+			Fingerprint: PasswordFingerprint(user),
+			CreatedAt:   now,
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.AddRefreshToken(ctx, user.ID, models.RefreshToken{
+			Hash:      hash,
+			SessionID: sessionID,
+			CreatedAt: now,
+			ExpiresAt: now.Add(OIDCFlowTTL),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	pending, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcSecondFactorClaims{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hash,
+			Audience:  jwt.ClaimStrings{oidcSecondFactorAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(OIDCFlowTTL)),
+		},
+	}).SignedString([]byte(s.secret))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign oidc second factor state: %w", err)
+	}
+
+	return pending, nil
+}
+
+// Пользователь входа из StartOIDCSecondFactor. Код проверяет вызывающий.
+// Уже завершенный вход не принимается
+func (s *OIDCService) GetOIDCSecondFactorUser(ctx context.Context, pending string) (models.User, error) {
+	claims, err := s.parseSecondFactor(pending)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	token, err := s.repository.GetRefreshToken(ctx, claims.ID)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.User{}, ErrInvalidOIDCFlow
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if token.Used || token.UserID != claims.UserID || token.SessionID != claims.SessionID {
+		return models.User{}, ErrInvalidOIDCFlow
+	}
+
+	user, err := s.repository.GetUserByID(ctx, claims.UserID)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.User{}, ErrInvalidOIDCFlow
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// Расходует вход из StartOIDCSecondFactor после проверки кода и закрывает его сеанс.
+// Из параллельных запросов с одной cookie проходит только один
+func (s *OIDCService) FinishOIDCSecondFactor(ctx context.Context, pending string) error {
+	claims, err := s.parseSecondFactor(pending)
+	if err != nil {
+		return err
+	}
+
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		err := tx.UseRefreshToken(ctx, claims.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.RevokeSession(ctx, claims.UserID, claims.SessionID)
+	})
+	if errors.Is(err, models.ErrConflict) || errors.Is(err, models.ErrNotFound) {
+		return ErrInvalidOIDCFlow
+	}
+
+	return err
+}
+
+func (s *OIDCService) parseSecondFactor(pending string) (*oidcSecondFactorClaims, error) {
+	claims := &oidcSecondFactorClaims{}
+	_, err := jwt.ParseWithClaims(pending, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(oidcSecondFactorAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.ID == "" {
+		return nil, ErrInvalidOIDCFlow
+	}
+
+	return claims, nil
+}
+
+func (s *OIDCService) discover(ctx context.Context) (*oidcProvider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.provider != nil {
+		return s.provider, nil
+	}
+
+	var provider oidcProvider
+	err := s.getJSON(ctx, strings.TrimSuffix(s.cfg.Issuer, "/")+"/.well-known/openid-configuration", &provider)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+
+	// OpenID Connect Discovery требует точного совпадения, иначе документ от чужого провайдера
+	if provider.Issuer != s.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery failed: issuer %q does not match configured %q", provider.Issuer, s.cfg.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery failed: authorization_endpoint, token_endpoint and jwks_uri are required")
+	}
+
+	s.provider = &provider
+	return s.provider, nil
+}
+
+func (s *OIDCService) exchangeCode(ctx context.Context, provider *oidcProvider, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	// Публичный клиент без секрета передает только client_id
+	if s.cfg.ClientSecret == "" {
+		form.Set("client_id", s.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.cfg.ClientSecret != "" {
+		// client_secret_basic, способ по умолчанию в OpenID Connect Core
+		req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("oidc token request failed: %s: %w", resp.Status, err)
+	}
+
+	// Код неверный, просрочен или не подходит к verifier
+	if body.Error != "" {
+		return "", fmt.Errorf("oidc token request rejected: %s %s: %w", body.Error, body.ErrorDescription, models.ErrUnauthorized)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token request failed: %s", resp.Status)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("oidc token response has no id_token: %w", ErrInvalidIDToken)
+	}
+
+	return body.IDToken, nil
+}
+
+// Проверяет подпись, издателя, получателя, срок действия и nonce ID-токена
+func (s *OIDCService) verifyIDToken(ctx context.Context, provider *oidcProvider, raw, nonce string) (*idTokenClaims, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.key(ctx, provider, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(s.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: sub is missing", ErrInvalidIDToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != s.cfg.ClientID {
+		return nil, fmt.Errorf("%w: azp does not match", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+// Открытый ключ провайдера с идентификатором kid. Если ключа нет, набор ключей
+// перечитывается: провайдер мог сменить ключи
+func (s *OIDCService) key(ctx context.Context, provider *oidcProvider, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.findKey(kid)
+	if ok || time.Since(s.keysFetchedAt) < oidcKeysRefreshInterval {
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+
+	keys, err := s.fetchKeys(ctx, provider.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	s.keys = keys
+	s.keysFetchedAt = time.Now()
+
+	key, ok = s.findKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// Токен без kid допускается, только если у провайдера один ключ
+func (s *OIDCService) findKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// Читает RSA ключи подписи из JWK Set (RFC 7517)
+func (s *OIDCService) fetchKeys(ctx context.Context, uri string) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err := s.getJSON(ctx, uri, &set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch oidc keys: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	}
+
+	return keys, nil
+}
+
+func (s *OIDCService) getJSON(ctx context.Context, uri string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", uri, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Находит пользователя по учетной записи провайдера или создает нового.
+// Существующие локальные пользователи с тем же логином не привязываются:
+// иначе владелец учетной записи у провайдера получил бы чужие задачи
+func (s *OIDCService) userForIdentity(ctx context.Context, issuer string, claims *idTokenClaims) (models.User, error) {
+	user, err := s.repository.GetUserByIdentity(ctx, issuer, claims.Subject)
+	if !errors.Is(err, models.ErrNotFound) {
+		return user, err
+	}
+
+	password, err := randomString()
+	if err != nil {
+		return models.User{}, err
+	}
+	// Случайный пароль, которого никто не знает: войти можно только через провайдера
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	now := time.Now()
+	user = models.User{PasswordHash: string(hash), CreatedAt: now}
+
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		for _, login := range oidcLoginCandidates(claims) {
+			user.Login = login
+			// Точка сохранения: в PostgreSQL ошибка запроса прерывает всю транзакцию
+			err := tx.InTx(ctx, func(tx repository.Repository) error {
+				var err error
+				user.ID, err = tx.AddUser(ctx, user)
+				return err
+			})
+			if errors.Is(err, models.ErrConflict) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			return tx.AddIdentity(ctx, user.ID, models.Identity{
+				Issuer:    issuer,
+				Subject:   claims.Subject,
+				CreatedAt: now,
+			})
+		}
+
+		return fmt.Errorf("no free login for oidc subject %s", claims.Subject)
+	})
+	// Параллельный первый вход того же пользователя успел его создать
+	if errors.Is(err, models.ErrConflict) {
+		return s.repository.GetUserByIdentity(ctx, issuer, claims.Subject)
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// Логины для нового пользователя: preferred_username или имя из email,
+// а если они заняты - они же с номером и со случайным суффиксом
+func oidcLoginCandidates(claims *idTokenClaims) []string {
+	base := sanitizeLogin(claims.PreferredUsername)
+	if base == "" {
+		email := claims.Email
+		if i := strings.IndexByte(email, '@'); i >= 0 {
+			email = email[:i]
+		}
+		base = sanitizeLogin(email)
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	candidates := []string{base}
+	for i := 2; i <= 9; i++ {
+		candidates = append(candidates, base+"-"+strconv.Itoa(i))
+	}
+
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err == nil {
+		candidates = append(candidates, base+"-"+hex.EncodeToString(suffix))
+	}
+
+	return candidates
+}
+
+// Убирает символы, недопустимые в логине, и оставляет место для суффикса
+func sanitizeLogin(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		default:
+			return -1
+		}
+	}, s)
+
+	if len(s) > 54 {
+		s = s[:54]
+	}
+	if len(s) < 3 {
+		return ""
+	}
+	return s
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// 256 случайных бит в base64url: подходит и для state с nonce, и для verifier PKCE
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,365 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+const (
+	testClientID     = "scheduler"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "http://localhost:7540/api/oidc/callback"
+)
+
+// Провайдер OpenID Connect для тестов: discovery, JWKS и обмен кода на токены с проверкой PKCE.
+// Страницу входа заменяет authorize, которая сразу выдает код для указанного пользователя
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+	// Меняет claims ID-токена перед подписью
+	tamper func(claims jwt.MapClaims)
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	subject   string
+	username  string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &mockProvider{t: t, key: key, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// Проверяет запрос на вход и возвращает код и state, с которыми провайдер вернул бы пользователя
+func (p *mockProvider) authorize(authURL, subject, username string) (code, state string) {
+	u, err := url.Parse(authURL)
+	require.NoError(p.t, err)
+	q := u.Query()
+
+	assert.Equal(p.t, p.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(p.t, "code", q.Get("response_type"))
+	assert.Equal(p.t, testClientID, q.Get("client_id"))
+	assert.Equal(p.t, testRedirectURL, q.Get("redirect_uri"))
+	assert.Equal(p.t, "openid profile email", q.Get("scope"))
+	assert.Equal(p.t, "S256", q.Get("code_challenge_method"))
+
+	code, err = randomString()
+	require.NoError(p.t, err)
+
+	p.mu.Lock()
+	p.codes[code] = mockGrant{
+		challenge: q.Get("code_challenge"),
+		nonce:     q.Get("nonce"),
+		subject:   subject,
+		username:  username,
+	}
+	p.mu.Unlock()
+
+	return code, q.Get("state")
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != testClientID || secret != testClientSecret {
+		fail("invalid_client")
+		return
+	}
+
+	p.mu.Lock()
+	grant, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != testRedirectURL {
+		fail("invalid_grant")
+		return
+	}
+	if pkceChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                p.server.URL,
+		"aud":                testClientID,
+		"sub":                grant.subject,
+		"nonce":              grant.nonce,
+		"preferred_username": grant.username,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+	}
+	if p.tamper != nil {
+		p.tamper(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key-1"
+	idToken, err := token.SignedString(p.key)
+	require.NoError(p.t, err)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func newTestOIDCService(t *testing.T, issuer string) *OIDCService {
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	return NewOIDCService(repo, config.Auth{
+		Secret: "secret",
+		OIDC: config.OIDC{
+			Issuer:       issuer,
+			ClientID:     testClientID,
+			ClientSecret: testClientSecret,
+			RedirectURL:  testRedirectURL,
+			Scopes:       "openid profile email",
+		},
+	})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockProvider(t)
+	s := newTestOIDCService(t, provider.server.URL)
+	ctx := context.Background()
+
+	login := func(subject, username string) (models.User, error) {
+		authURL, flow, err := s.StartOIDCLogin(ctx)
+		require.NoError(t, err)
+
+		code, state := provider.authorize(authURL, subject, username)
+		return s.FinishOIDCLogin(ctx, flow, state, code)
+	}
+
+	alice, err := login("sub-alice", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", alice.Login)
+	assert.NotEmpty(t, alice.PasswordHash)
+
+	// Повторный вход - тот же пользователь
+	again, err := login("sub-alice", "alice-renamed")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, again.ID)
+
+	// Другой субъект с тем же именем не получает чужую учетную запись
+	other, err := login("sub-other", "alice")
+	require.NoError(t, err)
+	assert.NotEqual(t, alice.ID, other.ID)
+	assert.Equal(t, "alice-2", other.Login)
+
+	// Имени нет: логин по умолчанию
+	anonymous, err := login("sub-anonymous", "")
+	require.NoError(t, err)
+	assert.Equal(t, "user", anonymous.Login)
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	provider := newMockProvider(t)
+	s := newTestOIDCService(t, provider.server.URL)
+	ctx := context.Background()
+
+	t.Run("StateMismatch", func(t *testing.T) {
+		authURL, flow, err := s.StartOIDCLogin(ctx)
+		require.NoError(t, err)
+		code, _ := provider.authorize(authURL, "sub", "alice")
+
+		_, err = s.FinishOIDCLogin(ctx, flow, "forged", code)
+		assert.ErrorIs(t, err, ErrInvalidOIDCFlow)
+	})
+
+	t.Run("MissingFlow", func(t *testing.T) {
+		authURL, _, err := s.StartOIDCLogin(ctx)
+		require.NoError(t, err)
+		code, state := provider.authorize(authURL, "sub", "alice")
+
+		// Браузер вернулся без cookie с состоянием: например, вход начат в другом браузере
+		_, err = s.FinishOIDCLogin(ctx, "", state, code)
+		assert.ErrorIs(t, err, ErrInvalidOIDCFlow)
+	})
+
+	t.Run("CodeReplay", func(t *testing.T) {
+		authURL, flow, err := s.StartOIDCLogin(ctx)
+		require.NoError(t, err)
+		code, state := provider.authorize(authURL, "sub", "alice")
+
+		_, err = s.FinishOIDCLogin(ctx, flow, state, code)
+		require.NoError(t, err)
+		_, err = s.FinishOIDCLogin(ctx, flow, state, code)
+		assert.ErrorIs(t, err, models.ErrUnauthorized)
+	})
+
+	t.Run("ForeignFlow", func(t *testing.T) {
+		authURL, _, err := s.StartOIDCLogin(ctx)
+		require.NoError(t, err)
+		code, state := provider.authorize(authURL, "sub", "alice")
+
+		// Код выдан для другого входа: verifier PKCE не подходит
+		_, flow, err := s.StartOIDCLogin(ctx)
+		require.NoError(t, err)
+		other, err := jwt.ParseWithClaims(flow, &oidcFlowClaims{}, func(*jwt.Token) (interface{}, error) { return []byte("secret"), nil })
+		require.NoError(t, err)
+		claims := other.Claims.(*oidcFlowClaims)
+		claims.State = state
+		forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		require.NoError(t, err)
+
+		_, err = s.FinishOIDCLogin(ctx, forged, state, code)
+		assert.ErrorIs(t, err, models.ErrUnauthorized)
+	})
+
+	tamper := []struct {
+		name   string
+		tamper func(claims jwt.MapClaims)
+	}{
+		{"WrongNonce", func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{"WrongAudience", func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{"WrongIssuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"Expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"NoSubject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tamper {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.tamper = tt.tamper
+			defer func() { provider.tamper = nil }()
+
+			authURL, flow, err := s.StartOIDCLogin(ctx)
+			require.NoError(t, err)
+			code, state := provider.authorize(authURL, "sub", "alice")
+
+			_, err = s.FinishOIDCLogin(ctx, flow, state, code)
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("ForeignSignature", func(t *testing.T) {
+		// Токен подписан ключом, которого нет в JWKS провайдера
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		original := provider.key
+		provider.key = key
+		defer func() { provider.key = original }()
+
+		authURL, flow, err := s.StartOIDCLogin(ctx)
+		require.NoError(t, err)
+		code, state := provider.authorize(authURL, "sub", "alice")
+
+		_, err = s.FinishOIDCLogin(ctx, flow, state, code)
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+}
+
+func TestOIDCSecondFactorState(t *testing.T) {
+	provider := newMockProvider(t)
+	s := newTestOIDCService(t, provider.server.URL)
+	ctx := context.Background()
+
+	authURL, flow, err := s.StartOIDCLogin(ctx)
+	require.NoError(t, err)
+	code, state := provider.authorize(authURL, "sub-alice", "alice")
+	alice, err := s.FinishOIDCLogin(ctx, flow, state, code)
+	require.NoError(t, err)
+
+	pending, err := s.StartOIDCSecondFactor(ctx, alice)
+	require.NoError(t, err)
+	user, err := s.GetOIDCSecondFactorUser(ctx, pending)
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID)
+
+	// Состояние входа подписано тем же секретом, но для другой цели
+	_, err = s.GetOIDCSecondFactorUser(ctx, flow)
+	assert.ErrorIs(t, err, ErrInvalidOIDCFlow)
+	_, err = s.GetOIDCSecondFactorUser(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidOIDCFlow)
+
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcSecondFactorClaims{
+		UserID:           alice.ID,
+		RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{oidcSecondFactorAudience}, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+	}).SignedString([]byte("other-secret"))
+	require.NoError(t, err)
+	_, err = s.GetOIDCSecondFactorUser(ctx, forged)
+	assert.ErrorIs(t, err, ErrInvalidOIDCFlow)
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcSecondFactorClaims{
+		UserID:           alice.ID,
+		RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{oidcSecondFactorAudience}, ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))},
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = s.GetOIDCSecondFactorUser(ctx, expired)
+	assert.ErrorIs(t, err, ErrInvalidOIDCFlow)
+
+	// Вход расходуется один раз
+	require.NoError(t, s.FinishOIDCSecondFactor(ctx, pending))
+	assert.ErrorIs(t, s.FinishOIDCSecondFactor(ctx, pending), ErrInvalidOIDCFlow)
+	_, err = s.GetOIDCSecondFactorUser(ctx, pending)
+	assert.ErrorIs(t, err, ErrInvalidOIDCFlow)
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	s := newTestOIDCService(t, "")
+
+	_, _, err := s.StartOIDCLogin(context.Background())
+	assert.ErrorIs(t, err, ErrOIDCDisabled)
+}
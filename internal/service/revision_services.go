@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+)
+
+// Возвращает историю изменений задачи: для каждой ревизии только изменившиеся поля
+func (s *TaskService) GetRevisions(ctx context.Context, userID int64, id string) ([]models.RevisionDiff, error) {
+	task, err := s.GetTaskByID(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.repository.GetRevisions(ctx, revisionOwner(userID, task), id)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]models.RevisionDiff, 0, len(revisions))
+	for _, rev := range revisions {
+		diffs = append(diffs, models.RevisionDiff{
+			Revision:  rev.Revision,
+			CreatedAt: rev.CreatedAt.Format(time.RFC3339),
+			Changes:   diffTasks(rev.Before, rev.After),
+		})
+	}
+
+	return diffs, nil
+}
+
+// Возвращает задаче поля, которые были у нее до указанной ревизии.
+// Откат выполняется как обычное редактирование, поэтому сам попадает
+// в историю и может быть отменен через журнал
+func (s *TaskService) RollbackTask(ctx context.Context, userID int64, id string, revision int64) (int64, error) {
+	current, err := s.GetTaskByID(ctx, userID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	rev, err := s.repository.GetRevision(ctx, revisionOwner(userID, current), id, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	task := rev.Before
+	task.ID = id
+
+	return s.EditTask(ctx, userID, task)
+}
+
+// Ревизии хранятся у автора задачи, чтобы историю задачи из общего списка
+// видели все его участники. Для личной задачи автор - ее владелец
+func revisionOwner(userID int64, task models.Task) int64 {
+	if task.CreatedByID != 0 {
+		return task.CreatedByID
+	}
+	return userID
+}
+
+func diffTasks(before, after models.Task) map[string]models.FieldChange {
+	changes := map[string]models.FieldChange{}
+
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"date", before.Date, after.Date},
+		{"title", before.Title, after.Title},
+		{"comment", before.Comment, after.Comment},
+		{"repeat", before.Repeat, after.Repeat},
+	}
+
+	for _, f := range fields {
+		if f.old != f.new {
+			changes[f.name] = models.FieldChange{Old: f.old, New: f.new}
+		}
+	}
+
+	return changes
+}
@@ -1,27 +1,127 @@
 package service
 
 import (
+	"context"
 	"time"
 
+	"github.com/Oxygenss/yandex_final_project/internal/config"
 	"github.com/Oxygenss/yandex_final_project/internal/models"
 	"github.com/Oxygenss/yandex_final_project/internal/repository"
 )
 
+// Методы, изменяющие задачи, возвращают id операции в журнале отмены.
+// version - ожидаемая версия задачи из If-Match (для EditTask - task.Version), 0 - не проверять
 type Task interface {
-	AddTask(task models.Task) (int64, error)
-	EditTask(task models.Task) error
-	DeleteTask(id string) error
-	DoneTask(id string) error
-	GetTaskByID(id string) (models.Task, error)
-	GetTasks() ([]models.Task, error)
-	SearchTasks(search string) ([]models.Task, error)
+	AddTask(ctx context.Context, userID int64, task models.Task) (id int64, opID int64, err error)
+	EditTask(ctx context.Context, userID int64, task models.Task) (opID int64, err error)
+	PatchTask(ctx context.Context, userID int64, id string, version int64, patch models.TaskPatch) (opID int64, err error)
+	DeleteTask(ctx context.Context, userID int64, id string, version int64) (opID int64, err error)
+	DoneTask(ctx context.Context, userID int64, id string, version int64) (opID int64, err error)
+	GetTaskByID(ctx context.Context, userID int64, id string) (models.Task, error)
+	// listID 0 - личные задачи, иначе задачи общего списка
+	GetTasks(ctx context.Context, userID, listID int64) ([]models.Task, error)
+	SearchTasks(ctx context.Context, userID, listID int64, search string) ([]models.Task, error)
 	NextDate(now time.Time, dateStr string, repeat string) (string, error)
+	GetRevisions(ctx context.Context, userID int64, id string) ([]models.RevisionDiff, error)
+	RollbackTask(ctx context.Context, userID int64, id string, revision int64) (opID int64, err error)
+	BulkTasks(ctx context.Context, userID int64, req models.BulkRequest) (models.BulkResponse, error)
+}
+
+type List interface {
+	CreateList(ctx context.Context, userID int64, name string) (models.List, error)
+	GetLists(ctx context.Context, userID int64) ([]models.List, error)
+	GetList(ctx context.Context, userID, id int64) (models.GetListResponse, error)
+	RenameList(ctx context.Context, userID, id int64, name string) error
+	DeleteList(ctx context.Context, userID, id int64) error
+	InviteMember(ctx context.Context, userID, listID int64, req models.InviteRequest) (models.ListMember, error)
+	GetInvitations(ctx context.Context, userID int64) ([]models.List, error)
+	AcceptInvitation(ctx context.Context, userID, listID int64) error
+	DeclineInvitation(ctx context.Context, userID, listID int64) error
+	UpdateMemberRole(ctx context.Context, userID, listID, memberID int64, role string) error
+	RemoveMember(ctx context.Context, userID, listID, memberID int64) error
+}
+
+type Journal interface {
+	Undo(ctx context.Context, userID int64, opID string) error
+}
+
+type Idempotency interface {
+	ReserveKey(ctx context.Context, userID int64, key, scope, requestHash string) (rec models.IdempotencyRecord, replay bool, err error)
+	SaveResponse(ctx context.Context, userID int64, rec models.IdempotencyRecord) error
+	ReleaseKey(ctx context.Context, userID int64, key, scope string) error
+}
+
+type User interface {
+	SignUp(ctx context.Context, login, password string) (models.User, error)
+	SignIn(ctx context.Context, login, password string) (models.User, error)
+	EnsureAdminPassword(ctx context.Context, password string) error
+}
+
+type Session interface {
+	StartSession(ctx context.Context, user models.User, secondFactor bool) (session models.Session, refreshToken string, err error)
+	Refresh(ctx context.Context, refreshToken string) (user models.User, session models.Session, newRefreshToken string, err error)
+	Logout(ctx context.Context, userID, sessionID int64) error
+	Authenticate(ctx context.Context, userID, sessionID int64, fingerprint string) error
+}
+
+type OIDC interface {
+	OIDCEnabled() bool
+	StartOIDCLogin(ctx context.Context) (authURL, flow string, err error)
+	FinishOIDCLogin(ctx context.Context, flow, state, code string) (models.User, error)
+	StartOIDCSecondFactor(ctx context.Context, user models.User) (pending string, err error)
+	GetOIDCSecondFactorUser(ctx context.Context, pending string) (models.User, error)
+	FinishOIDCSecondFactor(ctx context.Context, pending string) error
+}
+
+type TwoFactor interface {
+	GetTwoFactorStatus(ctx context.Context, userID int64) (models.TwoFactorStatusResponse, error)
+	SetupTwoFactor(ctx context.Context, userID int64) (models.TwoFactorSetupResponse, error)
+	EnableTwoFactor(ctx context.Context, userID, sessionID int64, code string) (recoveryCodes []string, err error)
+	DisableTwoFactor(ctx context.Context, userID int64, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID int64, code string) (recoveryCodes []string, err error)
+	VerifySecondFactor(ctx context.Context, userID int64, code string) (verified bool, err error)
+}
+
+type APIToken interface {
+	CreateAPIToken(ctx context.Context, userID int64, req models.CreateAPITokenRequest) (models.CreateAPITokenResponse, error)
+	GetAPITokens(ctx context.Context, userID int64) ([]models.APIToken, error)
+	RevokeAPIToken(ctx context.Context, userID int64, id string) error
+	AuthenticateAPIToken(ctx context.Context, value string) (models.APIToken, error)
+}
+
+type Audit interface {
+	ResolveAuditAdmins(ctx context.Context) error
+	AuditSignIn(ctx context.Context, login string, userID int64, method string, err error) error
+	GetAuditEvents(ctx context.Context, userID int64, filter models.AuditFilter) ([]models.AuditEvent, error)
+	ExportAuditEvents(ctx context.Context, userID int64, filter models.AuditFilter, fn func(models.AuditEvent) error) error
 }
 
 type Service struct {
+	User
+	Session
+	OIDC
+	TwoFactor
+	APIToken
 	Task
+	List
+	Journal
+	Idempotency
+	Audit
 }
 
-func NewService(repository repository.Repository) *Service {
-	return &Service{Task: NewTaskService(repository)}
+func NewService(repository repository.Repository, cfg config.Config) *Service {
+	journal := NewJournalService(repository, cfg.Undo.Window)
+
+	return &Service{
+		User:        NewUserService(repository),
+		Session:     NewSessionService(repository, cfg.Auth.RefreshTTL),
+		OIDC:        NewOIDCService(repository, cfg.Auth),
+		TwoFactor:   NewTwoFactorService(repository),
+		APIToken:    NewAPITokenService(repository),
+		Task:        NewTaskService(repository, journal),
+		List:        NewListService(repository),
+		Journal:     journal,
+		Idempotency: NewIdempotencyService(repository, cfg.Idempotency.Window, cfg.Idempotency.Lease),
+		Audit:       NewAuditService(repository, cfg.Auth.AuditAdmins),
+	}
 }
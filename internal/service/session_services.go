@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+var (
+	ErrInvalidRefreshToken = fmt.Errorf("invalid refresh token: %w", models.ErrUnauthorized)
+	ErrRefreshTokenExpired = fmt.Errorf("refresh token has expired: %w", models.ErrUnauthorized)
+	ErrRefreshTokenReused  = fmt.Errorf("refresh token was already used, the session is revoked: %w", models.ErrUnauthorized)
+	ErrSessionRevoked      = fmt.Errorf("session has been revoked: %w", models.ErrUnauthorized)
+)
+
+type SessionService struct {
+	repository repository.Repository
+	// Время жизни токена обновления. Каждый обмен выдает токен на новый срок
+	ttl time.Duration
+}
+
+func NewSessionService(repository repository.Repository, ttl time.Duration) *SessionService {
+	return &SessionService{repository: repository, ttl: ttl}
+}
+
+// Начинает сеанс пользователя после входа и выдает первый токен обновления.
+// secondFactor - при входе был проверен код двухфакторной аутентификации
+func (s *SessionService) StartSession(ctx context.Context, user models.User, secondFactor bool) (models.Session, string, error) {
+	now := time.Now()
+
+	err := s.repository.DeleteRefreshTokensBefore(ctx, now)
+	if err != nil {
+		return models.Session{}, "", err
+	}
+
+	session := models.Session{
+		UserID:       user.ID,
+		Fingerprint:  PasswordFingerprint(user),
+		CreatedAt:    now,
+		SecondFactor: secondFactor,
+	}
+
+	var refreshToken string
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		var err error
+		session.ID, err = tx.AddSession(ctx, user.ID, session)
+		if err != nil {
+			return err
+		}
+
+		refreshToken, err = s.issueRefreshToken(ctx, tx, session, now)
+		return err
+	})
+	if err != nil {
+		return models.Session{}, "", err
+	}
+
+	return session, refreshToken, nil
+}
+
+// Обменивает токен обновления на новый и возвращает пользователя и сеанс, для которых
+// нужно выдать токен доступа. Повторное использование уже обмененного токена означает,
+// что он украден, поэтому сеанс отзывается целиком вместе со всеми его токенами
+func (s *SessionService) Refresh(ctx context.Context, value string) (models.User, models.Session, string, error) {
+	now := time.Now()
+
+	token, err := s.repository.GetRefreshToken(ctx, hashAPIToken(value))
+	if errors.Is(err, models.ErrNotFound) {
+		return models.User{}, models.Session{}, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return models.User{}, models.Session{}, "", err
+	}
+
+	session, err := s.repository.GetSession(ctx, token.UserID, token.SessionID)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.User{}, models.Session{}, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return models.User{}, models.Session{}, "", err
+	}
+
+	if session.Revoked {
+		return models.User{}, models.Session{}, "", ErrSessionRevoked
+	}
+
+	if token.Used {
+		return models.User{}, models.Session{}, "", s.revoke(ctx, session, ErrRefreshTokenReused)
+	}
+
+	if !now.Before(token.ExpiresAt) {
+		return models.User{}, models.Session{}, "", ErrRefreshTokenExpired
+	}
+
+	user, err := s.repository.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		return models.User{}, models.Session{}, "", err
+	}
+
+	if PasswordFingerprint(user) != session.Fingerprint {
+		return models.User{}, models.Session{}, "", s.revoke(ctx, session, ErrTokenRevoked)
+	}
+
+	err = s.requireSecondFactor(ctx, session)
+	if err != nil {
+		return models.User{}, models.Session{}, "", err
+	}
+
+	var refreshToken string
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		err := tx.UseRefreshToken(ctx, token.Hash)
+		if err != nil {
+			return err
+		}
+
+		refreshToken, err = s.issueRefreshToken(ctx, tx, session, now)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, models.AuditEvent{
+			UserID:  user.ID,
+			Action:  models.AuditTokenRefresh,
+			Target:  strconv.FormatInt(session.ID, 10),
+			Success: true,
+		})
+	})
+	// Параллельный запрос успел обменять этот же токен
+	if errors.Is(err, models.ErrConflict) {
+		return models.User{}, models.Session{}, "", s.revoke(ctx, session, ErrRefreshTokenReused)
+	}
+	if err != nil {
+		return models.User{}, models.Session{}, "", err
+	}
+
+	return user, session, refreshToken, nil
+}
+
+// Завершает сеанс: его токены доступа и обновления перестают приниматься
+func (s *SessionService) Logout(ctx context.Context, userID, sessionID int64) error {
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		err := tx.RevokeSession(ctx, userID, sessionID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, models.AuditEvent{
+			UserID:  userID,
+			Action:  models.AuditLogout,
+			Target:  strconv.FormatInt(sessionID, 10),
+			Success: true,
+		})
+	})
+}
+
+// Проверяет, что сеанс токена доступа не завершен, пароль пользователя
+// не менялся после выдачи токена, а при включенной двухфакторной аутентификации
+// сеанс начат с кодом
+func (s *SessionService) Authenticate(ctx context.Context, userID, sessionID int64, fingerprint string) error {
+	user, err := s.repository.GetUserByID(ctx, userID)
+	if errors.Is(err, models.ErrNotFound) {
+		return ErrTokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.PasswordHash == "" || subtle.ConstantTimeCompare([]byte(PasswordFingerprint(user)), []byte(fingerprint)) != 1 {
+		return ErrTokenRevoked
+	}
+
+	session, err := s.repository.GetSession(ctx, userID, sessionID)
+	if errors.Is(err, models.ErrNotFound) {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+
+	if session.Revoked {
+		return ErrSessionRevoked
+	}
+
+	return s.requireSecondFactor(ctx, session)
+}
+
+// Сеанс, начатый без кода, например до включения двухфакторной аутентификации,
+// не принимается, пока она включена
+func (s *SessionService) requireSecondFactor(ctx context.Context, session models.Session) error {
+	if session.SecondFactor {
+		return nil
+	}
+
+	tf, err := s.repository.GetTwoFactor(ctx, session.UserID)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if tf.Enabled {
+		return ErrSecondFactorRequired
+	}
+
+	return nil
+}
+
+func (s *SessionService) issueRefreshToken(ctx context.Context, tx repository.Repository, session models.Session, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+
+	err = tx.AddRefreshToken(ctx, session.UserID, models.RefreshToken{
+		Hash:      hashAPIToken(value),
+		SessionID: session.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// Отзывает сеанс, записывает отказ в журнал аудита и возвращает его причину
+func (s *SessionService) revoke(ctx context.Context, session models.Session, reason error) error {
+	err := s.repository.InTx(ctx, func(tx repository.Repository) error {
+		err := tx.RevokeSession(ctx, session.UserID, session.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, models.AuditEvent{
+			UserID:  session.UserID,
+			Action:  models.AuditTokenRefresh,
+			Target:  strconv.FormatInt(session.ID, 10),
+			Details: reason.Error(),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return reason
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+func newTestSessionService(t *testing.T, ttl time.Duration) (*SessionService, models.User) {
+	t.Helper()
+
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	user, err := NewUserService(repo).SignUp(context.Background(), "user", "secret-password")
+	require.NoError(t, err)
+
+	return NewSessionService(repo, ttl), user
+}
+
+func TestRefreshRotation(t *testing.T) {
+	sessions, user := newTestSessionService(t, time.Hour)
+	ctx := context.Background()
+
+	session, first, err := sessions.StartSession(ctx, user, false)
+	require.NoError(t, err)
+
+	_, refreshed, second, err := sessions.Refresh(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, refreshed.ID, "обмен продолжает тот же сеанс")
+	assert.NotEqual(t, first, second)
+
+	_, _, third, err := sessions.Refresh(ctx, second)
+	require.NoError(t, err)
+	assert.NotEqual(t, second, third)
+
+	require.NoError(t, sessions.Authenticate(ctx, user.ID, session.ID, PasswordFingerprint(user)))
+
+	_, _, _, err = sessions.Refresh(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+// Повторный обмен уже обмененного токена отзывает сеанс вместе с его новыми токенами
+func TestRefreshReuse(t *testing.T) {
+	sessions, user := newTestSessionService(t, time.Hour)
+	ctx := context.Background()
+
+	session, first, err := sessions.StartSession(ctx, user, false)
+	require.NoError(t, err)
+	_, _, second, err := sessions.Refresh(ctx, first)
+	require.NoError(t, err)
+
+	_, _, _, err = sessions.Refresh(ctx, first)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	_, _, _, err = sessions.Refresh(ctx, second)
+	assert.ErrorIs(t, err, ErrSessionRevoked)
+
+	err = sessions.Authenticate(ctx, user.ID, session.ID, PasswordFingerprint(user))
+	assert.ErrorIs(t, err, ErrSessionRevoked)
+
+	// Другие сеансы пользователя не затрагиваются
+	other, token, err := sessions.StartSession(ctx, user, false)
+	require.NoError(t, err)
+	_, _, _, err = sessions.Refresh(ctx, token)
+	require.NoError(t, err)
+	require.NoError(t, sessions.Authenticate(ctx, user.ID, other.ID, PasswordFingerprint(user)))
+}
+
+func TestRefreshExpired(t *testing.T) {
+	sessions, user := newTestSessionService(t, -time.Second)
+	ctx := context.Background()
+
+	_, token, err := sessions.StartSession(ctx, user, false)
+	require.NoError(t, err)
+
+	_, _, _, err = sessions.Refresh(ctx, token)
+	assert.ErrorIs(t, err, ErrRefreshTokenExpired)
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+}
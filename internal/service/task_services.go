@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -16,10 +18,11 @@ const (
 
 type TaskService struct {
 	repository repository.Repository
+	journal    *JournalService
 }
 
-func NewTaskService(repository repository.Repository) *TaskService {
-	return &TaskService{repository: repository}
+func NewTaskService(repository repository.Repository, journal *JournalService) *TaskService {
+	return &TaskService{repository: repository, journal: journal}
 }
 
 // Title - обязательное поле
@@ -29,143 +32,361 @@ func NewTaskService(repository repository.Repository) *TaskService {
 // Если date < now, то
 // - Если repeat пустой или не указан, то берется сегодняшнее число
 // - Если repeat указан, то с помощью nextDate считаем дату, которая больше сегодняшней
-func (s *TaskService) AddTask(task models.Task) (int64, error) {
-	if task.Title == "" {
-		return 0, fmt.Errorf("title is required")
+func (s *TaskService) AddTask(ctx context.Context, userID int64, task models.Task) (int64, int64, error) {
+	err := s.validateTask(&task, time.Now())
+	if err != nil {
+		return 0, 0, err
 	}
 
-	now := time.Now()
-	nowFormatted := now.Format(DateFormat)
-	nowDate, _ := time.Parse(DateFormat, nowFormatted)
+	var id int64
+	opID, err := s.inTx(ctx, func(tx *TaskService) (int64, error) {
+		err := authorizeTask(ctx, tx.repository, userID, task, models.RoleEditor)
+		if err != nil {
+			return 0, err
+		}
 
-	if task.Date == "" {
-		task.Date = nowFormatted
-	} else {
-		parsedDate, err := time.Parse(DateFormat, task.Date)
+		id, err = tx.repository.AddTask(ctx, userID, task)
 		if err != nil {
-			return 0, fmt.Errorf("invalid date format. Expected format is YYYYMMDD: %w", err)
+			return 0, fmt.Errorf("failed to add task to repository: %w", err)
 		}
 
-		if parsedDate.Equal(nowDate) {
-			task.Date = parsedDate.Format(DateFormat)
-		} else if parsedDate.Before(now) {
-			if task.Repeat == "" {
-				task.Date = nowFormatted
-			} else {
-				nextDate, err := s.NextDate(now, task.Date, task.Repeat)
-				if err != nil {
-					return 0, fmt.Errorf("invalid repeat format or error calculating next date: %w", err)
-				}
-				task.Date = nextDate
-			}
+		task.ID = strconv.FormatInt(id, 10)
+		err = recordTaskAudit(ctx, tx.repository, userID, models.AuditTaskCreate, task)
+		if err != nil {
+			return 0, err
 		}
-	}
 
-	id, err := s.repository.AddTask(task)
+		return tx.journal.Record(ctx, userID, models.OperationAdd, task, 1)
+	})
 	if err != nil {
-		return 0, fmt.Errorf("failed to add task to repository: %w", err)
+		return 0, 0, err
 	}
 
-	return id, nil
+	return id, opID, nil
 }
 
-func (s *TaskService) EditTask(task models.Task) error {
+func (s *TaskService) EditTask(ctx context.Context, userID int64, task models.Task) (int64, error) {
 	_, err := strconv.Atoi(task.ID)
 	if err != nil {
-		return fmt.Errorf("failed to parse id: %w", err)
+		return 0, models.NewValidationError("id", "failed to parse id", err)
+	}
+
+	err = s.validateTask(&task, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return s.inTx(ctx, func(tx *TaskService) (int64, error) {
+		previous, err := tx.getTaskForUpdate(ctx, userID, task.ID, task.Version)
+		if err != nil {
+			return 0, err
+		}
+
+		return tx.saveTask(ctx, userID, previous, task)
+	})
+}
+
+// Частичное изменение задачи по правилам JSON Merge Patch (RFC 7396):
+// меняются только переданные поля. Дата пересчитывается,
+// только если в патче есть date или repeat
+func (s *TaskService) PatchTask(ctx context.Context, userID int64, id string, version int64, patch models.TaskPatch) (int64, error) {
+	_, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, models.NewValidationError("id", "failed to parse id", err)
+	}
+
+	return s.inTx(ctx, func(tx *TaskService) (int64, error) {
+		return tx.patchTask(ctx, userID, id, version, patch)
+	})
+}
+
+func (s *TaskService) patchTask(ctx context.Context, userID int64, id string, version int64, patch models.TaskPatch) (int64, error) {
+	previous, err := s.getTaskForUpdate(ctx, userID, id, version)
+	if err != nil {
+		return 0, err
+	}
+
+	task := previous
+	if patch.Date != nil {
+		task.Date = *patch.Date
+	}
+	if patch.Title != nil {
+		task.Title = *patch.Title
+	}
+	if patch.Comment != nil {
+		task.Comment = *patch.Comment
+	}
+	if patch.Repeat != nil {
+		task.Repeat = *patch.Repeat
 	}
 
+	var errs models.ValidationErrors
 	if task.Title == "" {
-		return fmt.Errorf("title is required")
+		errs = append(errs, models.NewValidationError("title", "title is required", nil))
+	}
+
+	if patch.Date != nil || patch.Repeat != nil {
+		errs = appendValidationError(errs, s.normalizeDate(&task, time.Now()))
+	}
+
+	err = errs.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	return s.saveTask(ctx, userID, previous, task)
+}
+
+// Читает задачу, которую пользователь собирается изменить: проверяет его роль
+// в списке задачи и версию из If-Match
+func (s *TaskService) getTaskForUpdate(ctx context.Context, userID int64, id string, version int64) (models.Task, error) {
+	task, err := s.repository.GetTaskByID(ctx, userID, id)
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	err = authorizeTask(ctx, s.repository, userID, task, models.RoleEditor)
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	err = checkVersion(task, version)
+	if err != nil {
+		return models.Task{}, err
 	}
 
-	now := time.Now()
+	return task, nil
+}
+
+// Сохраняет измененную задачу, добавляет ревизию в историю, запись в журнал аудита и в журнал отмены.
+// Запись условная по версии previous: если задачу успели изменить после чтения,
+// репозиторий вернет ErrPreconditionFailed. Список и автор задачи не меняются
+func (s *TaskService) saveTask(ctx context.Context, userID int64, previous, task models.Task) (int64, error) {
+	task.Version = previous.Version
+	task.ListID = previous.ListID
+	task.CreatedBy = previous.CreatedBy
+	task.CreatedByID = previous.CreatedByID
+
+	err := s.repository.EditTask(ctx, userID, task)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.repository.AddRevision(ctx, revisionOwner(userID, previous), models.Revision{
+		TaskID:    task.ID,
+		Before:    previous,
+		After:     task,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = recordTaskAudit(ctx, s.repository, userID, models.AuditTaskUpdate, task)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.journal.Record(ctx, userID, models.OperationEdit, previous, previous.Version+1)
+}
+
+// Проверяет сразу все поля задачи, чтобы клиент узнал обо всех ошибках за один запрос,
+// и приводит дату к правилам, описанным у AddTask
+func (s *TaskService) validateTask(task *models.Task, now time.Time) error {
+	var errs models.ValidationErrors
+	if task.Title == "" {
+		errs = append(errs, models.NewValidationError("title", "title is required", nil))
+	}
+
+	errs = appendValidationError(errs, s.normalizeDate(task, now))
+
+	return errs.Err()
+}
+
+func appendValidationError(errs models.ValidationErrors, err error) models.ValidationErrors {
+	var vErr *models.ValidationError
+	if errors.As(err, &vErr) {
+		return append(errs, vErr)
+	}
+	return errs
+}
+
+// Приводит дату задачи к правилам, описанным у AddTask
+func (s *TaskService) normalizeDate(task *models.Task, now time.Time) error {
 	nowFormatted := now.Format(DateFormat)
 	nowDate, _ := time.Parse(DateFormat, nowFormatted)
 
 	if task.Date == "" {
 		task.Date = nowFormatted
-	} else {
-		parsedDate, err := time.Parse(DateFormat, task.Date)
-		if err != nil {
-			return fmt.Errorf("invalid date format. Expected format is YYYYMMDD: %w", err)
-		}
+		return nil
+	}
+
+	parsedDate, err := time.Parse(DateFormat, task.Date)
+	if err != nil {
+		return models.NewValidationError("date", "invalid date format. Expected format is YYYYMMDD", err)
+	}
 
-		if parsedDate.Equal(nowDate) {
-			task.Date = parsedDate.Format(DateFormat)
-		} else if parsedDate.Before(now) {
-			if task.Repeat == "" {
-				task.Date = nowFormatted
-			} else {
-				nextDate, err := s.NextDate(now, task.Date, task.Repeat)
-				if err != nil {
-					return fmt.Errorf("invalid repeat format or error calculating next date: %w", err)
-				}
-				task.Date = nextDate
+	if parsedDate.Equal(nowDate) {
+		task.Date = parsedDate.Format(DateFormat)
+	} else if parsedDate.Before(now) {
+		if task.Repeat == "" {
+			task.Date = nowFormatted
+		} else {
+			nextDate, err := s.NextDate(now, task.Date, task.Repeat)
+			if err != nil {
+				return models.NewValidationError("repeat", "invalid repeat format or error calculating next date", err)
 			}
+			task.Date = nextDate
 		}
 	}
 
-	return s.repository.EditTask(task)
+	return nil
 }
 
-func (s *TaskService) DeleteTask(id string) error {
-	return s.repository.DeleteByID(id)
+func (s *TaskService) DeleteTask(ctx context.Context, userID int64, id string, version int64) (int64, error) {
+	return s.inTx(ctx, func(tx *TaskService) (int64, error) {
+		return tx.deleteTask(ctx, userID, id, version)
+	})
 }
 
-func (s *TaskService) DoneTask(id string) error {
-	task, err := s.GetTaskByID(id)
+func (s *TaskService) deleteTask(ctx context.Context, userID int64, id string, version int64) (int64, error) {
+	task, err := s.getTaskForUpdate(ctx, userID, id, version)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	err = s.repository.DeleteByID(ctx, userID, id, task.Version)
+	if err != nil {
+		return 0, err
+	}
+
+	err = recordTaskAudit(ctx, s.repository, userID, models.AuditTaskDelete, task)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.journal.Record(ctx, userID, models.OperationDelete, task, 0)
+}
+
+// Чтение задачи, расчет следующей даты и запись выполняются в одной транзакции,
+// поэтому параллельные отметки о выполнении не пропускают повторения
+func (s *TaskService) DoneTask(ctx context.Context, userID int64, id string, version int64) (int64, error) {
+	return s.inTx(ctx, func(tx *TaskService) (int64, error) {
+		return tx.doneTask(ctx, userID, id, version)
+	})
+}
+
+func (s *TaskService) doneTask(ctx context.Context, userID int64, id string, version int64) (int64, error) {
+	task, err := s.getTaskForUpdate(ctx, userID, id, version)
+	if err != nil {
+		return 0, err
+	}
+	previous := task
+	// Версия задачи после отметки, у удаленной задачи версии нет
+	var after int64
+
 	if task.Repeat == "" {
-		err = s.repository.DeleteByID(id)
+		err = s.repository.DeleteByID(ctx, userID, id, task.Version)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	} else {
 		nextDate, err := s.NextDate(time.Now(), task.Date, task.Repeat)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		task.Date = nextDate
 
-		err = s.repository.EditTask(task)
+		err = s.repository.EditTask(ctx, userID, task)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		after = task.Version + 1
 	}
 
+	err = recordTaskAudit(ctx, s.repository, userID, models.AuditTaskDone, previous)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.journal.Record(ctx, userID, models.OperationDone, previous, after)
+}
+
+// Выполняет fn в транзакции репозитория: чтение задачи и запись изменений
+// вместе с ревизией и журналом отмены либо выполняются целиком, либо откатываются
+func (s *TaskService) inTx(ctx context.Context, fn func(tx *TaskService) (int64, error)) (opID int64, err error) {
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		opID, err = fn(s.withRepository(tx))
+		return err
+	})
+	return opID, err
+}
+
+// Версия 0 означает, что клиент не прислал If-Match и проверка не нужна
+func checkVersion(task models.Task, expected int64) error {
+	if expected != 0 && task.Version != expected {
+		return fmt.Errorf("task with id %s has version %d, expected %d: %w",
+			task.ID, task.Version, expected, models.ErrPreconditionFailed)
+	}
 	return nil
 }
 
-func (s *TaskService) GetTaskByID(id string) (models.Task, error) {
-	return s.repository.GetTaskByID(id)
+func (s *TaskService) GetTaskByID(ctx context.Context, userID int64, id string) (models.Task, error) {
+	task, err := s.repository.GetTaskByID(ctx, userID, id)
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	err = authorizeTask(ctx, s.repository, userID, task, models.RoleViewer)
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	return task, nil
 }
 
-func (s *TaskService) GetTasks() ([]models.Task, error) {
-	return s.repository.GetTasks()
+// listID 0 - личные задачи пользователя, иначе задачи общего списка
+func (s *TaskService) GetTasks(ctx context.Context, userID, listID int64) ([]models.Task, error) {
+	err := s.checkListAccess(ctx, userID, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repository.GetTasks(ctx, userID, listID)
 }
 
-func (s *TaskService) SearchTasks(search string) ([]models.Task, error) {
+func (s *TaskService) SearchTasks(ctx context.Context, userID, listID int64, search string) ([]models.Task, error) {
+	err := s.checkListAccess(ctx, userID, listID)
+	if err != nil {
+		return nil, err
+	}
+
 	time, err := time.Parse("02.01.2006", search)
 	if err == nil {
 		dateFormatted := time.Format(DateFormat)
-		return s.repository.SearchTasksByDate(dateFormatted)
+		return s.repository.SearchTasksByDate(ctx, userID, listID, dateFormatted)
+	}
+
+	return s.repository.SearchTasksByString(ctx, userID, listID, search)
+}
+
+func (s *TaskService) checkListAccess(ctx context.Context, userID, listID int64) error {
+	if listID == 0 {
+		return nil
 	}
 
-	return s.repository.SearchTasksByString(search)
+	_, err := checkListRole(ctx, s.repository, userID, listID, models.RoleViewer)
+	return err
 }
 
 func (s *TaskService) NextDate(now time.Time, dateStr string, repeat string) (string, error) {
 	if repeat == "" {
-		return "", fmt.Errorf("repeat rule is missing")
+		return "", models.NewValidationError("repeat", "repeat rule is missing", nil)
 	}
 
 	date, err := time.Parse(DateFormat, dateStr)
 	if err != nil {
-		return "", fmt.Errorf("invalid time format: %w", err)
+		return "", models.NewValidationError("date", "invalid time format", err)
 	}
 
 	if strings.HasPrefix(repeat, "d ") {
@@ -173,11 +394,11 @@ func (s *TaskService) NextDate(now time.Time, dateStr string, repeat string) (st
 
 		days, err := strconv.Atoi(daysStr)
 		if err != nil {
-			return "", fmt.Errorf("invalid day interval: %w", err)
+			return "", models.NewValidationError("repeat", "invalid day interval", err)
 		}
 
 		if days <= 0 || days > 400 {
-			return "", fmt.Errorf("day interval must be between 1 and 400: %w", err)
+			return "", models.NewValidationError("repeat", "day interval must be between 1 and 400", nil)
 		}
 
 		for {
@@ -194,6 +415,6 @@ func (s *TaskService) NextDate(now time.Time, dateStr string, repeat string) (st
 			}
 		}
 	} else {
-		return "", fmt.Errorf("unsupported repeat format")
+		return "", models.NewValidationError("repeat", "unsupported repeat format", nil)
 	}
 }
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+// Пользователь, которого создает миграция схемы
+const userID = 1
+
+func newTestTaskService(t *testing.T) *TaskService {
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	return NewTaskService(repo, NewJournalService(repo, time.Minute))
+}
+
+// Параллельные отметки о выполнении повторяющейся задачи не должны терять
+// повторения: после N вызовов дата сдвигается ровно на N интервалов
+func TestDoneTaskConcurrent(t *testing.T) {
+	s := newTestTaskService(t)
+	ctx := context.Background()
+
+	today := time.Now().Format(DateFormat)
+	id, _, err := s.AddTask(ctx, userID, models.Task{Date: today, Title: "Зарядка", Repeat: "d 1"})
+	require.NoError(t, err)
+	taskID := strconv.FormatInt(id, 10)
+
+	const workers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.DoneTask(ctx, userID, taskID, 0)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	task, err := s.GetTaskByID(ctx, userID, taskID)
+	require.NoError(t, err)
+
+	want := time.Now().AddDate(0, 0, workers).Format(DateFormat)
+	assert.Equal(t, want, task.Date)
+	assert.Equal(t, int64(workers+1), task.Version)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Параметры TOTP по умолчанию из RFC 6238: их поддерживают все приложения-аутентификаторы
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// Сколько соседних интервалов принимается, чтобы не мешало расхождение часов
+	totpSkew = 1
+	// Название сервиса, которое приложение показывает рядом с логином
+	totpIssuer = "Scheduler"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// 160 бит - длина ключа, рекомендованная RFC 4226 для HMAC-SHA1
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate two-factor secret: %w", err)
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// Номер 30-секундного интервала, к которому относится момент t
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// Код для интервала step по RFC 4226: HMAC-SHA1 и динамическое усечение
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid two-factor secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// Адрес otpauth:// в формате Key Uri Format, который понимают приложения-аутентификаторы
+func totpURI(login, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+login) + "?" + query.Encode()
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	return strings.Trim(code, "0123456789") == ""
+}
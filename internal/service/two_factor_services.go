@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrSecondFactorRequired = fmt.Errorf("two-factor code is required: %w", models.ErrUnauthorized)
+	ErrInvalidTwoFactorCode = fmt.Errorf("invalid two-factor code: %w", models.ErrUnauthorized)
+
+	errTwoFactorEnabled    = fmt.Errorf("two-factor authentication is already enabled: %w", models.ErrConflict)
+	errTwoFactorNotEnabled = fmt.Errorf("two-factor authentication is not enabled: %w", models.ErrConflict)
+	errTwoFactorNotSetUp   = fmt.Errorf("two-factor setup has not been started: %w", models.ErrConflict)
+)
+
+// Коды восстановления записываются строчными буквами base32, группами по 4 символа
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+type TwoFactorService struct {
+	repository repository.Repository
+}
+
+func NewTwoFactorService(repository repository.Repository) *TwoFactorService {
+	return &TwoFactorService{repository: repository}
+}
+
+func (s *TwoFactorService) GetTwoFactorStatus(ctx context.Context, userID int64) (models.TwoFactorStatusResponse, error) {
+	tf, err := s.repository.GetTwoFactor(ctx, userID)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.TwoFactorStatusResponse{}, nil
+	}
+	if err != nil {
+		return models.TwoFactorStatusResponse{}, err
+	}
+
+	if !tf.Enabled {
+		return models.TwoFactorStatusResponse{}, nil
+	}
+
+	count, err := s.repository.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return models.TwoFactorStatusResponse{}, err
+	}
+
+	return models.TwoFactorStatusResponse{Enabled: true, RecoveryCodesLeft: count}, nil
+}
+
+// Создает новый ключ. Двухфакторная аутентификация включается только после
+// того, как пользователь подтвердит ключ кодом из приложения в EnableTwoFactor.
+// Повторный вызов до подтверждения заменяет ключ
+func (s *TwoFactorService) SetupTwoFactor(ctx context.Context, userID int64) (models.TwoFactorSetupResponse, error) {
+	tf, err := s.repository.GetTwoFactor(ctx, userID)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		return models.TwoFactorSetupResponse{}, err
+	}
+	if tf.Enabled {
+		return models.TwoFactorSetupResponse{}, errTwoFactorEnabled
+	}
+
+	user, err := s.repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return models.TwoFactorSetupResponse{}, err
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return models.TwoFactorSetupResponse{}, err
+	}
+
+	err = s.repository.SaveTwoFactor(ctx, userID, models.TwoFactor{
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return models.TwoFactorSetupResponse{}, err
+	}
+
+	return models.TwoFactorSetupResponse{Secret: secret, URI: totpURI(user.Login, secret)}, nil
+}
+
+// Включает двухфакторную аутентификацию после проверки кода и возвращает коды восстановления.
+// Текущий сеанс считается прошедшим проверку, остальные сеансы пользователя
+// перестают приниматься, пока в них не будет выполнен вход с кодом
+func (s *TwoFactorService) EnableTwoFactor(ctx context.Context, userID, sessionID int64, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		tf, err := tx.GetTwoFactor(ctx, userID)
+		if errors.Is(err, models.ErrNotFound) {
+			return errTwoFactorNotSetUp
+		}
+		if err != nil {
+			return err
+		}
+		if tf.Enabled {
+			return errTwoFactorEnabled
+		}
+
+		// Кодов восстановления еще нет, поэтому подходит только код из приложения
+		if !isTOTPCode(normalizeCode(code)) {
+			return invalidCodeError()
+		}
+		err = s.checkCode(ctx, tx, &tf, code)
+		if err != nil {
+			return err
+		}
+
+		tf.Enabled = true
+		err = tx.SaveTwoFactor(ctx, userID, tf)
+		if err != nil {
+			return err
+		}
+
+		err = tx.ReplaceRecoveryCodes(ctx, userID, hashes, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return tx.CompleteSecondFactor(ctx, userID, sessionID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Выключает двухфакторную аутентификацию. Нужен код из приложения или код восстановления
+func (s *TwoFactorService) DisableTwoFactor(ctx context.Context, userID int64, code string) error {
+	return s.repository.InTx(ctx, func(tx repository.Repository) error {
+		tf, err := s.enabledTwoFactor(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = s.checkCode(ctx, tx, &tf, code)
+		if err != nil {
+			return err
+		}
+
+		err = tx.DeleteTwoFactor(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.ReplaceRecoveryCodes(ctx, userID, nil, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, models.AuditEvent{UserID: userID, Action: models.AuditTwoFactorOff, Success: true})
+	})
+}
+
+// Заменяет коды восстановления новыми, прежние перестают приниматься
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID int64, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repository.InTx(ctx, func(tx repository.Repository) error {
+		tf, err := s.enabledTwoFactor(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = s.checkCode(ctx, tx, &tf, code)
+		if err != nil {
+			return err
+		}
+
+		return tx.ReplaceRecoveryCodes(ctx, userID, hashes, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Проверяет второй фактор при входе. Возвращает true, если код проверен,
+// и false, если у пользователя двухфакторная аутентификация не включена
+func (s *TwoFactorService) VerifySecondFactor(ctx context.Context, userID int64, code string) (bool, error) {
+	tf, err := s.repository.GetTwoFactor(ctx, userID)
+	if errors.Is(err, models.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !tf.Enabled {
+		return false, nil
+	}
+
+	if normalizeCode(code) == "" {
+		return false, ErrSecondFactorRequired
+	}
+
+	err = s.checkCode(ctx, s.repository, &tf, code)
+	if errors.Is(err, models.ErrValidation) {
+		return false, ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *TwoFactorService) enabledTwoFactor(ctx context.Context, tx repository.Repository, userID int64) (models.TwoFactor, error) {
+	tf, err := tx.GetTwoFactor(ctx, userID)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.TwoFactor{}, errTwoFactorNotEnabled
+	}
+	if err != nil {
+		return models.TwoFactor{}, err
+	}
+
+	if !tf.Enabled {
+		return models.TwoFactor{}, errTwoFactorNotEnabled
+	}
+
+	return tf, nil
+}
+
+// Проверяет код из приложения или код восстановления и отмечает его использованным,
+// чтобы один и тот же код нельзя было предъявить дважды
+func (s *TwoFactorService) checkCode(ctx context.Context, tx repository.Repository, tf *models.TwoFactor, code string) error {
+	code = normalizeCode(code)
+
+	if !isTOTPCode(code) {
+		err := tx.UseRecoveryCode(ctx, tf.UserID, hashAPIToken(code))
+		if errors.Is(err, models.ErrNotFound) {
+			return invalidCodeError()
+		}
+		return err
+	}
+
+	now := totpStep(time.Now())
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= tf.LastStep {
+			continue
+		}
+
+		expected, err := totpCode(tf.Secret, step)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		// Параллельный запрос успел принять этот же код
+		err = tx.UseTOTPStep(ctx, tf.UserID, step)
+		if errors.Is(err, models.ErrConflict) {
+			return invalidCodeError()
+		}
+		if err != nil {
+			return err
+		}
+
+		tf.LastStep = step
+		return nil
+	}
+
+	return invalidCodeError()
+}
+
+func invalidCodeError() error {
+	return models.NewValidationError("code", "invalid two-factor code", nil)
+}
+
+// Убирает пробелы и дефисы, которыми пользователь мог разделить код, и приводит его к нижнему регистру
+func normalizeCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// Коды восстановления и их хеши. 80 случайных бит на код: как и токены API,
+// их достаточно хранить как SHA-256 без соли
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		raw := recoveryEncoding.EncodeToString(b)
+		codes = append(codes, raw[:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:])
+		hashes = append(hashes, hashAPIToken(raw))
+	}
+
+	return codes, hashes, nil
+}
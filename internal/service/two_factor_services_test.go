@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Oxygenss/yandex_final_project/internal/config"
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+// Контрольные значения для SHA-1 из приложения B RFC 6238, последние 6 цифр
+func TestTOTPCode(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := totpCode(secret, totpStep(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.code, code, "time %d", tt.unix)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("alice", "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, "otpauth://totp/Scheduler:alice?algorithm=SHA1&digits=6&issuer=Scheduler&period=30&secret=JBSWY3DPEHPK3PXP", uri)
+}
+
+func TestTwoFactor(t *testing.T) {
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	users := NewUserService(repo)
+	sessions := NewSessionService(repo, time.Hour)
+	s := NewTwoFactorService(repo)
+
+	user, err := users.SignUp(ctx, "alice", "secret-password")
+	require.NoError(t, err)
+
+	// Сеанс, начатый до включения двухфакторной аутентификации
+	oldSession, _, err := sessions.StartSession(ctx, user, false)
+	require.NoError(t, err)
+	current, _, err := sessions.StartSession(ctx, user, false)
+	require.NoError(t, err)
+
+	verified, err := s.VerifySecondFactor(ctx, user.ID, "")
+	require.NoError(t, err)
+	assert.False(t, verified)
+
+	setup, err := s.SetupTwoFactor(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Contains(t, setup.URI, "secret="+setup.Secret)
+
+	// Пока ключ не подтвержден, код при входе не нужен
+	_, err = s.VerifySecondFactor(ctx, user.ID, "")
+	require.NoError(t, err)
+
+	_, err = s.EnableTwoFactor(ctx, user.ID, current.ID, "000000")
+	assert.ErrorIs(t, err, models.ErrValidation)
+
+	step := totpStep(time.Now())
+	code, err := totpCode(setup.Secret, step)
+	require.NoError(t, err)
+
+	codes, err := s.EnableTwoFactor(ctx, user.ID, current.ID, code)
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	_, err = s.EnableTwoFactor(ctx, user.ID, current.ID, code)
+	assert.ErrorIs(t, err, models.ErrConflict)
+	_, err = s.SetupTwoFactor(ctx, user.ID)
+	assert.ErrorIs(t, err, models.ErrConflict)
+
+	fingerprint := PasswordFingerprint(user)
+	assert.NoError(t, sessions.Authenticate(ctx, user.ID, current.ID, fingerprint))
+	assert.ErrorIs(t, sessions.Authenticate(ctx, user.ID, oldSession.ID, fingerprint), ErrSecondFactorRequired)
+
+	_, err = s.VerifySecondFactor(ctx, user.ID, "")
+	assert.ErrorIs(t, err, ErrSecondFactorRequired)
+
+	// Код уже принят при включении и повторно не подходит
+	_, err = s.VerifySecondFactor(ctx, user.ID, code)
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	next, err := totpCode(setup.Secret, step+1)
+	require.NoError(t, err)
+	verified, err = s.VerifySecondFactor(ctx, user.ID, next)
+	require.NoError(t, err)
+	assert.True(t, verified)
+
+	// Код восстановления подходит один раз, регистр и разделители не важны
+	verified, err = s.VerifySecondFactor(ctx, user.ID, " "+codes[0]+" ")
+	require.NoError(t, err)
+	assert.True(t, verified)
+	_, err = s.VerifySecondFactor(ctx, user.ID, codes[0])
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	status, err := s.GetTwoFactorStatus(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.TwoFactorStatusResponse{Enabled: true, RecoveryCodesLeft: recoveryCodeCount - 1}, status)
+
+	newCodes, err := s.RegenerateRecoveryCodes(ctx, user.ID, codes[1])
+	require.NoError(t, err)
+	_, err = s.VerifySecondFactor(ctx, user.ID, codes[2])
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	assert.ErrorIs(t, s.DisableTwoFactor(ctx, user.ID, "wrong-code"), models.ErrValidation)
+	require.NoError(t, s.DisableTwoFactor(ctx, user.ID, newCodes[0]))
+
+	assert.NoError(t, sessions.Authenticate(ctx, user.ID, oldSession.ID, fingerprint))
+	status, err = s.GetTwoFactorStatus(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+	assert.ErrorIs(t, s.DisableTwoFactor(ctx, user.ID, newCodes[1]), models.ErrConflict)
+}
+
+// Пользователь с включенной двухфакторной аутентификацией: секрет, интервал
+// кода, принятого при включении, и коды восстановления
+func newTestTwoFactor(t *testing.T) (*TwoFactorService, models.User, string, int64, []string) {
+	t.Helper()
+
+	repo, err := repository.New(config.Database{Path: filepath.Join(t.TempDir(), "scheduler.db")})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := NewTwoFactorService(repo)
+
+	user, err := NewUserService(repo).SignUp(ctx, "alice", "secret-password")
+	require.NoError(t, err)
+	session, _, err := NewSessionService(repo, time.Hour).StartSession(ctx, user, false)
+	require.NoError(t, err)
+
+	setup, err := s.SetupTwoFactor(ctx, user.ID)
+	require.NoError(t, err)
+
+	step := totpStep(time.Now())
+	code, err := totpCode(setup.Secret, step)
+	require.NoError(t, err)
+	codes, err := s.EnableTwoFactor(ctx, user.ID, session.ID, code)
+	require.NoError(t, err)
+
+	return s, user, setup.Secret, step, codes
+}
+
+// Параллельно выполняет verify и возвращает, сколько раз код был принят
+func verifyConcurrently(t *testing.T, n int, verify func() (bool, error)) int {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			verified, err := verify()
+			if err == nil && verified {
+				atomic.AddInt32(&accepted, 1)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+		}()
+	}
+	wg.Wait()
+
+	return int(accepted)
+}
+
+// Код из приложения принимается один раз, даже если его предъявили параллельно,
+// а после него не подходят и коды более ранних интервалов
+func TestTOTPStepReuse(t *testing.T) {
+	s, user, secret, step, _ := newTestTwoFactor(t)
+	ctx := context.Background()
+
+	next, err := totpCode(secret, step+1)
+	require.NoError(t, err)
+
+	accepted := verifyConcurrently(t, 10, func() (bool, error) {
+		return s.VerifySecondFactor(ctx, user.ID, next)
+	})
+	assert.Equal(t, 1, accepted)
+
+	_, err = s.VerifySecondFactor(ctx, user.ID, next)
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	previous, err := totpCode(secret, step)
+	require.NoError(t, err)
+	_, err = s.VerifySecondFactor(ctx, user.ID, previous)
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	// Гонку закрывает хранилище: интервал не позже принятого отклоняется
+	assert.ErrorIs(t, s.repository.UseTOTPStep(ctx, user.ID, step+1), models.ErrConflict)
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	s, user, _, _, codes := newTestTwoFactor(t)
+	ctx := context.Background()
+
+	accepted := verifyConcurrently(t, 10, func() (bool, error) {
+		return s.VerifySecondFactor(ctx, user.ID, codes[0])
+	})
+	assert.Equal(t, 1, accepted)
+
+	// Использованный код не подходит и для управления вторым фактором
+	_, err := s.RegenerateRecoveryCodes(ctx, user.ID, codes[0])
+	assert.ErrorIs(t, err, models.ErrValidation)
+	assert.ErrorIs(t, s.DisableTwoFactor(ctx, user.ID, codes[0]), models.ErrValidation)
+
+	status, err := s.GetTwoFactorStatus(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, recoveryCodeCount-1, status.RecoveryCodesLeft)
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Oxygenss/yandex_final_project/internal/models"
+	"github.com/Oxygenss/yandex_final_project/internal/repository"
+)
+
+const (
+	// Учетная запись, которую создает миграция схемы. Ей принадлежат задачи,
+	// созданные до появления пользователей, а пароль берется из config.Auth
+	AdminID    = 1
+	AdminLogin = "admin"
+
+	minPasswordLength = 8
+	// bcrypt учитывает только первые 72 байта пароля
+	maxPasswordLength = 72
+)
+
+var (
+	ErrInvalidCredentials = fmt.Errorf("incorrect login or password: %w", models.ErrUnauthorized)
+	ErrTokenRevoked       = fmt.Errorf("token was issued before the password was changed: %w", models.ErrUnauthorized)
+)
+
+var loginPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,64}$`)
+
+type UserService struct {
+	repository repository.Repository
+}
+
+func NewUserService(repository repository.Repository) *UserService {
+	return &UserService{repository: repository}
+}
+
+// Регистрирует пользователя
+func (s *UserService) SignUp(ctx context.Context, login, password string) (models.User, error) {
+	var errs models.ValidationErrors
+	if !loginPattern.MatchString(login) {
+		errs = append(errs, models.NewValidationError("login", "login must be 3-64 characters: latin letters, digits, '_', '.' or '-'", nil))
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		errs = append(errs, models.NewValidationError("password", fmt.Sprintf("password must be %d-%d bytes long", minPasswordLength, maxPasswordLength), nil))
+	}
+	err := errs.Err()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := models.User{
+		Login:        login,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	}
+
+	user.ID, err = s.repository.AddUser(ctx, user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// Проверяет логин и пароль. Пустой логин - вход администратора,
+// как было до появления учетных записей
+func (s *UserService) SignIn(ctx context.Context, login, password string) (models.User, error) {
+	if login == "" {
+		login = AdminLogin
+	}
+
+	user, err := s.repository.GetUserByLogin(ctx, login)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		return models.User{}, err
+	}
+
+	// Пароль сверяется с хешем, даже если пользователя нет или у администратора
+	// без пароля в конфиге пустой хеш: по времени ответа нельзя понять, существует ли логин
+	hash := user.PasswordHash
+	if hash == "" {
+		hash = dummyPasswordHash()
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil || user.PasswordHash == "" {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// Хеш случайного пароля с той же стоимостью, что и у настоящих
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		password := make([]byte, 16)
+		rand.Read(password)
+		dummyHash, _ = bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	})
+	return string(dummyHash)
+}
+
+// Отпечаток текущего пароля пользователя, который записывается в токен.
+// bcrypt хеш меняется при каждой смене пароля, поэтому после нее
+// отпечаток перестает совпадать и выданные ранее токены отклоняются
+func PasswordFingerprint(user models.User) string {
+	sum := sha256.Sum256([]byte(user.PasswordHash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Приводит пароль администратора к значению из конфига. Хеш пересчитывается,
+// только если пароль изменился, пустой пароль запрещает вход администратора
+func (s *UserService) EnsureAdminPassword(ctx context.Context, password string) error {
+	admin, err := s.repository.GetUserByID(ctx, AdminID)
+	if err != nil {
+		return err
+	}
+
+	if password == "" {
+		if admin.PasswordHash == "" {
+			return nil
+		}
+		return s.repository.UpdateUserPassword(ctx, AdminID, "")
+	}
+
+	if admin.PasswordHash != "" && bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), []byte(password)) == nil {
+		return nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return s.repository.UpdateUserPassword(ctx, AdminID, string(hash))
+}
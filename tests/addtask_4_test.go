@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/cookiejar"
+	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -14,6 +15,49 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+var signedInToken string
+
+// Токен из TODO_TOKEN, а если он не задан - полученный входом с паролем
+// Password или TODO_PASSWORD. Вход выполняется один раз на запуск тестов
+func getToken() (string, error) {
+	if envToken := os.Getenv("TODO_TOKEN"); len(envToken) > 0 {
+		return envToken, nil
+	}
+	if len(signedInToken) > 0 {
+		return signedInToken, nil
+	}
+
+	password := Password
+	if envPassword := os.Getenv("TODO_PASSWORD"); len(envPassword) > 0 {
+		password = envPassword
+	}
+	data, err := json.Marshal(map[string]string{"password": password})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Post(getURL("api/signin"), "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Token string `json:"token"`
+		Error string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("sign in failed: %d %s", resp.StatusCode, body.Error)
+	}
+
+	signedInToken = body.Token
+	return signedInToken, nil
+}
+
 func requestJSON(apipath string, values map[string]any, method string) ([]byte, error) {
 	var (
 		data []byte
@@ -35,7 +79,11 @@ func requestJSON(apipath string, values map[string]any, method string) ([]byte,
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
-	if len(Token) > 0 {
+	token, err := getToken()
+	if err != nil {
+		return nil, err
+	}
+	if len(token) > 0 {
 		jar, err := cookiejar.New(nil)
 		if err != nil {
 			return nil, err
@@ -43,7 +91,7 @@ func requestJSON(apipath string, values map[string]any, method string) ([]byte,
 		jar.SetCookies(req.URL, []*http.Cookie{
 			{
 				Name:  "token",
-				Value: Token,
+				Value: token,
 			},
 		})
 		client.Jar = jar
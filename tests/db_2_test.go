@@ -11,11 +11,15 @@ import (
 )
 
 type Task struct {
-	ID      int64  `db:"id"`
-	Date    string `db:"date"`
-	Title   string `db:"title"`
-	Comment string `db:"comment"`
-	Repeat  string `db:"repeat"`
+	ID         int64  `db:"id"`
+	Date       string `db:"date"`
+	Title      string `db:"title"`
+	Comment    string `db:"comment"`
+	Repeat     string `db:"repeat"`
+	Version    int64  `db:"version"`
+	UserID     int64  `db:"user_id"`
+	ListID     int64  `db:"list_id"`
+	ModifiedBy int64  `db:"modified_by"`
 }
 
 func count(db *sqlx.DB) (int, error) {
@@ -0,0 +1,28 @@
+// Токен доступа действует недолго. Когда сервер отвечает 401, токен обновления
+// из cookie обменивается на новый и запрос повторяется. Если обменять не удалось,
+// ошибка передается интерфейсу, и он отправляет на страницу входа
+(function () {
+    let refreshing = null;
+
+    axios.interceptors.response.use(undefined, function (error) {
+        const config = error.config;
+        if (!error.response || error.response.status !== 401 || !config || config.retried ||
+            /api\/(signin|refresh)$/.test(config.url)) {
+            return Promise.reject(error);
+        }
+
+        // Параллельные запросы ждут один обмен: повторный обмен того же токена отзывает сеанс
+        if (!refreshing) {
+            refreshing = axios.post("/api/refresh").finally(function () {
+                refreshing = null;
+            });
+        }
+
+        return refreshing.then(function () {
+            config.retried = true;
+            return axios(config);
+        }, function () {
+            return Promise.reject(error);
+        });
+    });
+})();